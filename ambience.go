@@ -0,0 +1,91 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+)
+
+const DefaultAmbienceWindow = 4
+
+// AmbienceConfig maps the mood of the recent messages to shell commands,
+// e.g. {"tense": "mpc clear; mpc load battle; mpc play"}. Moods are happy,
+// sad, tense and calm; a command runs when the mood changes, with the mood
+// in the CHARCHAT_MOOD environment variable.
+type AmbienceConfig struct {
+	Window   int               `json:"window,omitempty"`
+	Commands map[string]string `json:"commands"`
+}
+
+var (
+	tenseWords = wordSet("fight fighting attack attacks attacked sword swords blade run running chase chased danger dangerous threat scream screamed blood battle enemy enemies trap trapped hurry fire explosion gun shot shots growl growls monster")
+
+	currentMood string
+)
+
+// detectMood reads the mood of the last window messages.
+func detectMood(history []Message, window int) string {
+	if len(history) > window {
+		history = history[len(history)-window:]
+	}
+	var text strings.Builder
+	for _, msg := range history {
+		text.WriteString(msg.Content + " ")
+	}
+
+	words := strings.Fields(strings.ToLower(text.String()))
+	tense := 0
+	for _, word := range words {
+		if tenseWords[strings.Trim(word, ".,!?;:\"'*()-")] {
+			tense++
+		}
+	}
+	score := sentiment(text.String())
+	switch {
+	case len(words) > 0 && float64(tense)/float64(len(words)) >= 0.02:
+		return "tense"
+	case score > 0.25:
+		return "happy"
+	case score < -0.25:
+		return "sad"
+	default:
+		return "calm"
+	}
+}
+
+// updateAmbience runs the configured command when the mood has changed.
+func updateAmbience(config *Config, debug bool) {
+	if config.Ambience == nil || len(config.Ambience.Commands) == 0 {
+		return
+	}
+	window := config.Ambience.Window
+	if window <= 0 {
+		window = DefaultAmbienceWindow
+	}
+	mood := detectMood(messageHistory, window)
+	if mood == currentMood {
+		return
+	}
+	currentMood = mood
+	if debug {
+		notice("[Debug] Mood is now %s.\n", mood)
+	}
+
+	command, ok := config.Ambience.Commands[mood]
+	if !ok || command == "" {
+		return
+	}
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.Command("cmd", "/c", command)
+	} else {
+		cmd = exec.Command("sh", "-c", command)
+	}
+	cmd.Env = append(os.Environ(), "CHARCHAT_MOOD="+mood)
+	go func() {
+		if out, err := cmd.CombinedOutput(); err != nil {
+			printErrorf("Error running ambience command for %s: %v %s\n", mood, err, strings.TrimSpace(string(out)))
+		}
+	}()
+}
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"io/ioutil"
+	"sort"
+	"strings"
+)
+
+var sparkBlocks = []rune("▁▂▃▄▅▆▇█")
+
+// A small sentiment lexicon; enough to see the mood of a scene rise and
+// fall without sending the chat anywhere.
+var (
+	positiveWords = wordSet("love happy glad joy smile smiles smiled laugh laughs laughed warm kind gentle hope beautiful wonderful great good safe thank thanks calm delight delighted excited friend friends win won relief proud sweet bright peace")
+	negativeWords = wordSet("hate sad angry fear afraid scared cry cries cried pain hurt hurts cold cruel dark death dead die kill killed blood scream screamed alone lost fail failed worry worried terrible awful bad danger threat grim tears rage")
+)
+
+func wordSet(words string) map[string]bool {
+	set := map[string]bool{}
+	for _, word := range strings.Fields(words) {
+		set[word] = true
+	}
+	return set
+}
+
+// sentiment scores text from -1 (negative) to 1 (positive).
+func sentiment(text string) float64 {
+	positive, negative := 0, 0
+	for _, word := range strings.Fields(strings.ToLower(text)) {
+		word = strings.Trim(word, ".,!?;:\"'*()-")
+		if positiveWords[word] {
+			positive++
+		} else if negativeWords[word] {
+			negative++
+		}
+	}
+	if positive+negative == 0 {
+		return 0
+	}
+	return float64(positive-negative) / float64(positive+negative)
+}
+
+func sparkline(values []float64) string {
+	if len(values) == 0 {
+		return ""
+	}
+	min, max := values[0], values[0]
+	for _, v := range values {
+		if v < min {
+			min = v
+		}
+		if v > max {
+			max = v
+		}
+	}
+	var b strings.Builder
+	for _, v := range values {
+		i := 0
+		if max > min {
+			i = int((v - min) / (max - min) * float64(len(sparkBlocks)-1))
+		}
+		b.WriteRune(sparkBlocks[i])
+	}
+	return b.String()
+}
+
+func median(values []float64) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+	return sorted[len(sorted)/2]
+}
+
+// sessionMetrics are per reply, in chat order.
+type sessionMetrics struct {
+	indexes    []int
+	userWords  []float64
+	replyWords []float64
+	seconds    []float64
+	sentiment  []float64
+}
+
+// collectMetrics measures the replies in history, skipping the messages
+// at the indexes in skip.
+func collectMetrics(history []Message, skip map[int]bool) sessionMetrics {
+	var m sessionMetrics
+	lastUser := 0.0
+	for i, msg := range history {
+		if skip[i] {
+			continue
+		}
+		switch msg.Role {
+		case "user":
+			lastUser = float64(len(strings.Fields(msg.Content)))
+		case "assistant":
+			m.indexes = append(m.indexes, i)
+			m.userWords = append(m.userWords, lastUser)
+			m.replyWords = append(m.replyWords, float64(len(strings.Fields(msg.Content))))
+			m.seconds = append(m.seconds, msg.Seconds)
+			m.sentiment = append(m.sentiment, sentiment(msg.Content))
+			lastUser = 0
+		}
+	}
+	return m
+}
+
+func handleAnalyzeCommand(args string, config *Config) {
+	fields := strings.Fields(args)
+	m := collectMetrics(messageHistory, outOfStory(config, messageHistory))
+	if len(m.indexes) < 2 {
+		fmt.Println("Not enough replies to analyze yet.")
+		return
+	}
+	if len(fields) > 0 && fields[0] == "html" {
+		path := "pacing_report.html"
+		if len(fields) > 1 {
+			path = fields[1]
+		}
+		if err := ioutil.WriteFile(path, []byte(pacingReport(m)), 0644); err != nil {
+			printError("Error writing report:", err)
+			return
+		}
+		fmt.Printf("Pacing report written to %s\n", path)
+		return
+	}
+	displayPacing(m)
+}
+
+func displayPacing(m sessionMetrics) {
+	fmt.Printf("\n[Pacing] (%d replies, oldest first):\n", len(m.indexes))
+	fmt.Printf("%-16s %s\n", "Your words", sparkline(m.userWords))
+	fmt.Printf("%-16s %s\n", "Reply words", sparkline(m.replyWords))
+	if hasTimings(m.seconds) {
+		fmt.Printf("%-16s %s\n", "Reply seconds", sparkline(m.seconds))
+	}
+	fmt.Printf("%-16s %s\n", "Sentiment", sparkline(m.sentiment))
+
+	notes := pacingNotes(m)
+	if len(notes) > 0 {
+		fmt.Println()
+		for _, note := range notes {
+			fmt.Println("- " + note)
+		}
+	}
+}
+
+func hasTimings(seconds []float64) bool {
+	for _, s := range seconds {
+		if s > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// pacingNotes points out stretches that may drag: runs of long replies to
+// short messages, and runs where the mood doesn't move.
+func pacingNotes(m sessionMetrics) []string {
+	var notes []string
+	typical := median(m.replyWords)
+
+	for start := 0; start < len(m.indexes); {
+		end := start
+		for end < len(m.indexes) && m.replyWords[end] > 1.5*typical && m.userWords[end] < m.replyWords[end]/4 {
+			end++
+		}
+		if end-start >= 3 {
+			notes = append(notes, fmt.Sprintf("Messages %d-%d: long replies to short prompts; the story may be drifting without you.", m.indexes[start]+1, m.indexes[end-1]+1))
+			start = end
+			continue
+		}
+		start++
+	}
+
+	for start := 0; start < len(m.indexes); {
+		end := start + 1
+		for end < len(m.indexes) && m.sentiment[end] == m.sentiment[start] {
+			end++
+		}
+		if end-start >= 6 {
+			notes = append(notes, fmt.Sprintf("Messages %d-%d: the mood stays flat; a turn of events could help.", m.indexes[start]+1, m.indexes[end-1]+1))
+		}
+		start = end
+	}
+
+	if hasTimings(m.seconds) {
+		slowest := 0
+		for i, s := range m.seconds {
+			if s > m.seconds[slowest] {
+				slowest = i
+			}
+		}
+		notes = append(notes, fmt.Sprintf("Slowest reply: message %d (%.1fs).", m.indexes[slowest]+1, m.seconds[slowest]))
+	}
+	return notes
+}
+
+// pacingReport renders the metrics as a standalone HTML page of line charts.
+func pacingReport(m sessionMetrics) string {
+	var b strings.Builder
+	b.WriteString("<!DOCTYPE html><html><head><meta charset=\"utf-8\"><title>Pacing Report</title>")
+	b.WriteString("<style>body{font-family:sans-serif;background:#111;color:#ddd;max-width:900px;margin:auto}svg{background:#1b1b1b;margin-bottom:1em}polyline{fill:none;stroke:#6cf;stroke-width:2}</style></head><body>")
+	b.WriteString("<h1>Pacing Report</h1>")
+	b.WriteString(fmt.Sprintf("<p>%d replies in %s.</p>", len(m.indexes), html.EscapeString(characterDisplayName(activeCharacter))))
+
+	chart(&b, "Your words", m.userWords)
+	chart(&b, "Reply words", m.replyWords)
+	if hasTimings(m.seconds) {
+		chart(&b, "Reply seconds", m.seconds)
+	}
+	chart(&b, "Sentiment", m.sentiment)
+
+	if notes := pacingNotes(m); len(notes) > 0 {
+		b.WriteString("<h2>Notes</h2><ul>")
+		for _, note := range notes {
+			b.WriteString("<li>" + html.EscapeString(note) + "</li>")
+		}
+		b.WriteString("</ul>")
+	}
+	b.WriteString("</body></html>\n")
+	return b.String()
+}
+
+func chart(b *strings.Builder, title string, values []float64) {
+	const width, height = 880.0, 120.0
+	min, max := values[0], values[0]
+	for _, v := range values {
+		if v < min {
+			min = v
+		}
+		if v > max {
+			max = v
+		}
+	}
+	if max == min {
+		max = min + 1
+	}
+	var points []string
+	for i, v := range values {
+		x := width * float64(i) / float64(len(values)-1)
+		y := height - height*(v-min)/(max-min)
+		points = append(points, fmt.Sprintf("%.1f,%.1f", x, y))
+	}
+	b.WriteString(fmt.Sprintf("<h2>%s</h2><svg width=\"%.0f\" height=\"%.0f\"><polyline points=\"%s\"/></svg>", html.EscapeString(title), width, height, strings.Join(points, " ")))
+}
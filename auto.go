@@ -0,0 +1,126 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const (
+	maxAutoTurns = 50
+	autoPrompt   = "You are %s, talking with %s. Reply as %s only, with one message that carries the scene on."
+)
+
+// handleAutoCommand handles /auto {turns} {character}: the active character
+// and another saved one take turns talking. The other character's lines go
+// in the chat as yours would, named, and typing a message and pressing
+// Enter steps in after the current turn.
+func handleAutoCommand(args string, client *http.Client, config *Config, debug bool) {
+	turnsArg, name, _ := strings.Cut(strings.TrimSpace(args), " ")
+	name = strings.TrimSpace(name)
+	turns, err := strconv.Atoi(turnsArg)
+	if err != nil || turns < 1 || name == "" {
+		fmt.Println("Usage: /auto {turns} {character}")
+		return
+	}
+	turns = min(turns, maxAutoTurns)
+	if gmMode || rawMode {
+		fmt.Println("Turn off /gm and /raw to use /auto.")
+		return
+	}
+	partner, err := loadCharacter(name)
+	if err != nil {
+		printError("Error loading character:", err)
+		return
+	}
+	if partner.Name == activeCharacter.Name {
+		fmt.Println("Pick a character other than the one you're talking to.")
+		return
+	}
+
+	fmt.Printf("%s and %s will talk for %d turns. Type a message and press Enter to step in.\n",
+		characterDisplayName(activeCharacter), characterDisplayName(partner), turns)
+	partnerTurn := len(messageHistory) > 0 && messageHistory[len(messageHistory)-1].Role == "assistant"
+	for i := 0; i < turns; i++ {
+		if partnerTurn {
+			reply, err := partnerReply(client, config, partner, debug)
+			if err != nil {
+				printError("\nRequest error:", err)
+				return
+			}
+			if checkReplies(config) {
+				// The partner answers the last thing said, whoever said it.
+				checked, ok := checkReplyWith(client, config, messageHistory[len(messageHistory)-1].Content, reply, func() (string, error) {
+					return partnerReply(client, config, partner, debug)
+				}, debug)
+				if !ok {
+					return
+				}
+				reply = checked
+			}
+			label := characterDisplayName(partner) + ": "
+			fmt.Printf("\n%s%s\n", label, paintReply(wrapLabeled(config, reply, label)))
+			appendMessage("user", label+reply)
+		} else {
+			stopTyping := showTypingIndicator(config)
+			reply, err := sendChatRequest(client, config, debug)
+			stopTyping()
+			if err != nil {
+				printError("\nRequest error:", err)
+				return
+			}
+			if checkReplies(config) {
+				checked, ok := checkReply(client, config, lastUserInput(messageHistory), reply, debug)
+				if !ok {
+					return
+				}
+				reply = checked
+			}
+			displayResponse(reply, config)
+			acceptReply(config)
+			appendMessage("assistant", reply)
+		}
+		partnerTurn = !partnerTurn
+
+		if canPollKeys && keyPressed(int(os.Stdin.Fd()), 0) {
+			fmt.Println("\nOver to you.")
+			return
+		}
+	}
+}
+
+// partnerReply asks for the other character's next line, with the chat
+// turned around so its lines are the replies and the active character's
+// are the messages.
+func partnerReply(client *http.Client, config *Config, partner Character, debug bool) (string, error) {
+	active := activeCharacter
+	activeName, partnerName := characterDisplayName(active), characterDisplayName(partner)
+	activeCharacter = partner
+	defer func() { activeCharacter = active }()
+
+	system := config.System + "\n" + partner.characterPrompt()
+	if state := statePrompt(); state != "" {
+		system += "\n\n" + state
+	}
+	if clock := clockPrompt(config); clock != "" {
+		system += "\n\n" + clock
+	}
+	messages := []Message{{Role: "system", Content: system}}
+	for _, msg := range messageHistory {
+		switch {
+		case msg.Role == "assistant":
+			msg = Message{Role: "user", Content: activeName + ": " + msg.Content}
+		case msg.Role == "user" && strings.HasPrefix(msg.Content, partnerName+": "):
+			msg = Message{Role: "assistant", Content: strings.TrimPrefix(msg.Content, partnerName+": ")}
+		}
+		messages = append(messages, msg)
+	}
+	messages = append(messages, Message{Role: "system", Content: fmt.Sprintf(autoPrompt, partnerName, activeName, partnerName)})
+
+	stopTyping := showTypingIndicator(config)
+	defer stopTyping()
+	reply, err := requestReply(client, config, messages, debug)
+	return strings.TrimPrefix(strings.TrimSpace(reply), partnerName+": "), err
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	AvatarsDir         = "avatars"
+	DefaultAvatarWidth = 24
+)
+
+func getAvatarsDir() string {
+	return filepath.Join(getConfigDir(), AvatarsDir)
+}
+
+// saveAvatar copies image data into the avatars directory as the avatar of
+// the named character, returning its path.
+func saveAvatar(name string, data []byte, ext string) (string, error) {
+	if name == "" {
+		name = "default"
+	}
+	if err := os.MkdirAll(getAvatarsDir(), os.ModePerm); err != nil {
+		return "", err
+	}
+	path := filepath.Join(getAvatarsDir(), name+strings.ToLower(ext))
+	return path, ioutil.WriteFile(path, data, 0644)
+}
+
+// showAvatar draws the character's avatar, if it has one and avatars are
+// on.
+func showAvatar(character Character, config *Config) {
+	if config.Avatars != nil && !*config.Avatars {
+		return
+	}
+	drawAvatar(character, config)
+}
+
+func drawAvatar(character Character, config *Config) {
+	if character.Avatar == "" {
+		return
+	}
+	protocol := imageProtocol(config)
+	if protocol == "" {
+		return
+	}
+	data, err := ioutil.ReadFile(character.Avatar)
+	if err != nil {
+		printError("Error reading avatar:", err)
+		return
+	}
+	width := config.AvatarWidth
+	if width <= 0 {
+		width = DefaultAvatarWidth
+	}
+	fmt.Println()
+	if err := drawImage(data, width, protocol); err != nil {
+		printError("Error showing avatar:", err)
+	}
+}
+
+// handleAvatarCommand handles /char avatar [file | show | clear] for the
+// active character. Save the character to keep the change.
+func handleAvatarCommand(args string, config *Config) {
+	switch args {
+	case "", "show":
+		if activeCharacter.Avatar == "" {
+			fmt.Println("No avatar. Set one using: /char avatar {image file}")
+			return
+		}
+		if args == "" || imageProtocol(config) == "" {
+			fmt.Printf("Avatar: %s\n", activeCharacter.Avatar)
+			return
+		}
+		drawAvatar(activeCharacter, config)
+	case "clear":
+		activeCharacter.Avatar = ""
+		fmt.Println("Avatar cleared.")
+	default:
+		data, err := ioutil.ReadFile(args)
+		if err != nil {
+			printError("Error reading image:", err)
+			return
+		}
+		if !isImage(data) {
+			printErrorf("Error: %s is not a PNG, JPEG or GIF image.\n", args)
+			return
+		}
+		path, err := saveAvatar(activeCharacter.Name, data, filepath.Ext(args))
+		if err != nil {
+			printError("Error saving avatar:", err)
+			return
+		}
+		activeCharacter.Avatar = path
+		drawAvatar(activeCharacter, config)
+		fmt.Println("Avatar set.")
+	}
+}
+
+func isImage(data []byte) bool {
+	for _, magic := range [][]byte{pngSignature, {0xff, 0xd8, 0xff}, []byte("GIF8")} {
+		if bytes.HasPrefix(data, magic) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// DefaultBlocklistRetries is how many new replies are asked for before
+// the blocked phrases are stripped instead.
+const DefaultBlocklistRetries = 2
+
+// BlocklistConfig lists phrases the model overuses ("smirks",
+// "ministrations"). Replies that use them are regenerated up to Retries
+// times, then the phrases are replaced by their entry in Replace or
+// removed. With Action "strip" they are removed straight away. Characters
+// can add their own phrases with "blocklist".
+type BlocklistConfig struct {
+	Phrases []string          `json:"phrases,omitempty"`
+	Replace map[string]string `json:"replace,omitempty"`
+	Action  string            `json:"action,omitempty"`
+	Retries int               `json:"retries,omitempty"`
+}
+
+var (
+	doubleSpaces    = regexp.MustCompile(` {2,}`)
+	spaceBeforeMark = regexp.MustCompile(` +([.,!?;:])`)
+)
+
+// blockedPhrases are the config's phrases and the loaded character's.
+func blockedPhrases(config *Config) []string {
+	var phrases []string
+	if config.Blocklist != nil {
+		phrases = append(phrases, config.Blocklist.Phrases...)
+	}
+	return append(phrases, activeCharacter.Blocklist...)
+}
+
+// applyBlocklist keeps blocked phrases out of a reply, asking for new
+// replies to data first unless the config says to strip them.
+func applyBlocklist(client *http.Client, config *Config, data ChatMessage, reply string, debug bool) string {
+	phrases := blockedPhrases(config)
+	found := findKeywords(reply, phrases)
+	if len(found) == 0 {
+		return reply
+	}
+	blocklist := config.Blocklist
+	if blocklist == nil {
+		blocklist = &BlocklistConfig{}
+	}
+	retries := blocklist.Retries
+	if retries <= 0 {
+		retries = DefaultBlocklistRetries
+	}
+
+	if blocklist.Action != "strip" {
+		for attempt := 1; attempt <= retries && len(found) > 0; attempt++ {
+			if debug {
+				notice("[Debug] Blocklist: the reply used %s; regenerating (%d of %d).\n", strings.Join(found, ", "), attempt, retries)
+			}
+			retry, err := completeReply(client, config, data, debug)
+			if err != nil {
+				break
+			}
+			reply, found = retry, findKeywords(retry, phrases)
+		}
+	}
+	if len(found) == 0 {
+		return reply
+	}
+	if debug {
+		notice("[Debug] Blocklist: removing %s from the reply.\n", strings.Join(found, ", "))
+	}
+	return stripPhrases(reply, found, blocklist.Replace)
+}
+
+// stripPhrases replaces each phrase in text with its replacement, or
+// removes it, tidying the spaces left behind.
+func stripPhrases(text string, phrases []string, replace map[string]string) string {
+	for _, phrase := range phrases {
+		replacement := replace[phrase]
+		spans := keywordSpans(text, phrase)
+		for i := len(spans) - 1; i >= 0; i-- {
+			text = text[:spans[i][0]] + replacement + text[spans[i][1]:]
+		}
+	}
+	text = doubleSpaces.ReplaceAllString(text, " ")
+	return strings.TrimSpace(spaceBeforeMark.ReplaceAllString(text, "$1"))
+}
@@ -0,0 +1,26 @@
+package main
+
+import "testing"
+
+func TestStripPhrases(t *testing.T) {
+	tests := []struct {
+		text    string
+		phrases []string
+		replace map[string]string
+		want    string
+	}{
+		{"She smirks at you.", []string{"smirks"}, nil, "She at you."},
+		{"She smirks, then smirks again.", []string{"smirks"}, nil, "She, then again."},
+		{"She smirks at you.", []string{"smirks"}, map[string]string{"smirks": "smiles"}, "She smiles at you."},
+		{"Her ministrations continue", []string{"Ministrations"}, nil, "Her continue"},
+		{"smirking is fine", []string{"smirks"}, nil, "smirking is fine"},
+		// Lowering the case of İ changes its length, which must not move
+		// what gets removed.
+		{"İİİİİİ she smirks", []string{"smirks"}, nil, "İİİİİİ she"},
+	}
+	for _, tt := range tests {
+		if got := stripPhrases(tt.text, tt.phrases, tt.replace); got != tt.want {
+			t.Errorf("stripPhrases(%q, %q) = %q, want %q", tt.text, tt.phrases, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// jumpContext is how many messages before a bookmark /jump shows.
+const jumpContext = 4
+
+// handleBookmarkCommand handles /bookmark "name", marking the latest
+// message. Bookmarks are kept on the messages, so they follow them into
+// branches and saved sessions.
+func handleBookmarkCommand(args string) {
+	name := strings.Trim(strings.TrimSpace(args), "\"")
+	if name == "" {
+		fmt.Println("Usage: /bookmark \"name\"")
+		return
+	}
+	if len(messageHistory) == 0 {
+		fmt.Println("No messages to bookmark yet.")
+		return
+	}
+	if i := bookmarkNamed(name); i >= 0 {
+		messageHistory[i].Bookmark = ""
+	}
+	messageHistory[len(messageHistory)-1].Bookmark = name
+	fmt.Printf("Bookmarked message %d as '%s'. Come back to it using /jump %s\n", len(messageHistory), name, name)
+}
+
+// findBookmark returns the index of the message bookmarked as name, or
+// failing that by its number in /bookmarks, or -1. Names come first so a
+// bookmark called "2" can still be reached.
+func findBookmark(name string) int {
+	if i := bookmarkNamed(name); i >= 0 {
+		return i
+	}
+	n, err := strconv.Atoi(name)
+	if err != nil {
+		return -1
+	}
+	count := 0
+	for i, msg := range messageHistory {
+		if msg.Bookmark == "" {
+			continue
+		}
+		if count++; count == n {
+			return i
+		}
+	}
+	return -1
+}
+
+// bookmarkNamed returns the index of the message bookmarked as name, or -1.
+func bookmarkNamed(name string) int {
+	for i, msg := range messageHistory {
+		if msg.Bookmark != "" && strings.EqualFold(msg.Bookmark, name) {
+			return i
+		}
+	}
+	return -1
+}
+
+func bookmarkNames() []string {
+	var names []string
+	for _, msg := range messageHistory {
+		if msg.Bookmark != "" {
+			names = append(names, msg.Bookmark)
+		}
+	}
+	return names
+}
+
+// handleBookmarksCommand lists the bookmarks in the chat.
+func handleBookmarksCommand() {
+	count := 0
+	for i, msg := range messageHistory {
+		if msg.Bookmark == "" {
+			continue
+		}
+		if count == 0 {
+			fmt.Println("\n[Bookmarks]:")
+		}
+		count++
+		fmt.Printf("%d. %s (message %d): %s\n", count, msg.Bookmark, i+1, truncateText(msg.Content, 60))
+	}
+	if count == 0 {
+		fmt.Println("No bookmarks. Mark the latest message using /bookmark \"name\"")
+		return
+	}
+	fmt.Println("\nView one using /jump {name}, or continue from it using /jump {name} branch")
+}
+
+// handleJumpCommand handles /jump {bookmark} [branch]: it shows the chat
+// leading up to the bookmark, or starts a branch from it.
+func handleJumpCommand(args string) {
+	args = strings.TrimSpace(args)
+	branch := false
+	if rest, ok := strings.CutSuffix(args, " branch"); ok {
+		args, branch = strings.TrimSpace(rest), true
+	}
+	name := strings.Trim(args, "\"")
+	if name == "" {
+		fmt.Println("Usage: /jump {bookmark} [branch]")
+		return
+	}
+	i := findBookmark(name)
+	if i < 0 {
+		fmt.Printf("No bookmark '%s'. See them with /bookmarks\n", name)
+		return
+	}
+	bookmark := messageHistory[i].Bookmark
+
+	if branch {
+		created := forkBranch(bookmark, messageHistory[:i+1])
+		fmt.Printf("Created branch '%s' from bookmark '%s', at message %d. Go back using /branches\n", created, bookmark, i+1)
+		return
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "\n[Bookmark: %s]\n", bookmark)
+	for j := max(i-jumpContext, 0); j <= i; j++ {
+		fmt.Fprintf(&b, "%d. [%s]: %s%s\n", j+1, strings.Title(messageHistory[j].Role), messageHistory[j].Content, imageNote(messageHistory[j]))
+	}
+	fmt.Fprintf(&b, "\nContinue the story from here using /jump %s branch\n", bookmark)
+	page(b.String())
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const MainBranch = "main"
+
+var (
+	checkpoints   = map[string][]Message{}
+	branches      = map[string][]Message{}
+	currentBranch = MainBranch
+)
+
+func copyHistory(history []Message) []Message {
+	return append([]Message(nil), history...)
+}
+
+func createCheckpoint(name string) {
+	if name == "" {
+		fmt.Println("Usage: /checkpoint <name>")
+		return
+	}
+	checkpoints[name] = copyHistory(messageHistory)
+	fmt.Printf("Checkpoint '%s' saved at message %d.\n", name, len(messageHistory))
+}
+
+// createBranch forks a new storyline from the named checkpoint and switches
+// to it. The branch that was active stays available under /branches.
+func createBranch(checkpoint string) {
+	if checkpoint == "" {
+		fmt.Println("Usage: /branch <checkpoint>")
+		return
+	}
+	history, ok := checkpoints[checkpoint]
+	if !ok {
+		fmt.Printf("No checkpoint named '%s'. Create one with /checkpoint <name>.\n", checkpoint)
+		return
+	}
+
+	name := forkBranch(checkpoint, history)
+	fmt.Printf("Created branch '%s' from checkpoint '%s'.\n", name, checkpoint)
+}
+
+// forkBranch switches to a new branch with history, named after base,
+// keeping the current one. It returns the new branch's name.
+func forkBranch(base string, history []Message) string {
+	name := base
+	for i := 2; ; i++ {
+		// A branch with no messages is still a branch; its history is nil.
+		if _, taken := branches[name]; !taken && name != currentBranch {
+			break
+		}
+		name = fmt.Sprintf("%s-%d", base, i)
+	}
+
+	branches[currentBranch] = copyHistory(messageHistory)
+	branches[name] = copyHistory(history)
+	switchBranch(name)
+	return name
+}
+
+func handleBranchesCommand(name string) {
+	if name == "" {
+		listBranches()
+		return
+	}
+	if name == currentBranch {
+		fmt.Printf("Already on branch '%s'.\n", name)
+		return
+	}
+	if _, ok := branches[name]; !ok {
+		fmt.Printf("No branch named '%s'.\n", name)
+		return
+	}
+	branches[currentBranch] = copyHistory(messageHistory)
+	switchBranch(name)
+	fmt.Printf("Switched to branch '%s'.\n", name)
+}
+
+func switchBranch(name string) {
+	currentBranch = name
+	setHistory(copyHistory(branches[name]))
+}
+
+func listBranches() {
+	branches[currentBranch] = copyHistory(messageHistory)
+
+	names := make([]string, 0, len(branches))
+	for name := range branches {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	fmt.Println("\n[Branches]:")
+	for _, name := range names {
+		marker := " "
+		if name == currentBranch {
+			marker = "*"
+		}
+		fmt.Printf("%s %s (%d messages)\n", marker, name, len(branches[name]))
+	}
+
+	if len(checkpoints) > 0 {
+		names = names[:0]
+		for name := range checkpoints {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		fmt.Println("\n[Checkpoints]:")
+		for _, name := range names {
+			fmt.Printf("  %s (%d messages)\n", name, len(checkpoints[name]))
+		}
+	}
+	fmt.Println("\nSwitch branches using: /branches {name}")
+}
+
+// rewind removes the last count messages once the user has seen them and
+// agreed, so the story can be retried from an earlier point.
+func rewind(args string) {
+	count, err := strconv.Atoi(strings.TrimSpace(args))
+	if err != nil || count < 1 {
+		fmt.Println("Usage: /rewind {number of messages}")
+		return
+	}
+	if count > len(messageHistory) {
+		fmt.Printf("The chat only has %d messages.\n", len(messageHistory))
+		return
+	}
+
+	from := len(messageHistory) - count
+	fmt.Println("\n[Rewind]: These messages will be removed:")
+	for i, msg := range messageHistory[from:] {
+		fmt.Printf("  %d. %s: %s\n", from+i+1, strings.Title(msg.Role), truncateText(msg.Content, 70))
+	}
+	if promptUserForInput("Remove them? (y/n)", "n") != "y" {
+		fmt.Println("Nothing removed.")
+		return
+	}
+	setHistory(copyHistory(messageHistory[:from]))
+	fmt.Printf("Rewound %d messages. The chat is back at message %d.\n", count, from)
+}
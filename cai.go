@@ -0,0 +1,146 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// caiCharacter is a character as character.ai exports it. Its definition
+// is mostly example chats, separated by END_OF_DIALOG.
+type caiCharacter struct {
+	Name        string `json:"name"`
+	Title       string `json:"title"`
+	Description string `json:"description"`
+	Greeting    string `json:"greeting"`
+	Definition  string `json:"definition"`
+}
+
+// caiMessage covers both the older exports, with src and text, and the
+// newer ones, with author and candidates.
+type caiMessage struct {
+	Src struct {
+		IsHuman bool `json:"is_human"`
+	} `json:"src"`
+	Text   string `json:"text"`
+	Author struct {
+		IsHuman bool `json:"is_human"`
+	} `json:"author"`
+	Candidates []struct {
+		RawContent string `json:"raw_content"`
+	} `json:"candidates"`
+}
+
+type caiChat struct {
+	Msgs  []caiMessage `json:"msgs"`
+	Turns []caiMessage `json:"turns"`
+}
+
+// caiExport is a character.ai character or chat export. Chat exports keep
+// the character under info and the chats under histories.
+type caiExport struct {
+	caiCharacter
+	Character *caiCharacter `json:"character"`
+	Info      struct {
+		Character *caiCharacter `json:"character"`
+	} `json:"info"`
+	Histories json.RawMessage `json:"histories"`
+	Turns     []caiMessage    `json:"turns"`
+}
+
+// importCAI reads a character.ai export, reporting false if data isn't
+// one. The chats in it come back as histories starting with the greeting.
+func importCAI(data []byte) (Character, [][]Message, bool) {
+	var export caiExport
+	if err := json.Unmarshal(data, &export); err != nil {
+		return Character{}, nil, false
+	}
+	source := export.Character
+	if source == nil {
+		source = export.Info.Character
+	}
+	if source == nil {
+		if export.Definition == "" && export.Title == "" && export.Histories == nil {
+			return Character{}, nil, false
+		}
+		source = &export.caiCharacter
+	}
+	if source.Name == "" {
+		return Character{}, nil, false
+	}
+
+	character := Character{
+		Name:         source.Name,
+		Definition:   source.Description,
+		Greeting:     source.Greeting,
+		CreatorNotes: source.Title,
+		Examples:     strings.ReplaceAll(source.Definition, "END_OF_DIALOG", "<START>"),
+	}
+	if character.Definition == "" {
+		character.Definition = source.Title
+	}
+
+	var chats []caiChat
+	var nested struct {
+		Histories []caiChat `json:"histories"`
+	}
+	if json.Unmarshal(export.Histories, &nested) == nil && nested.Histories != nil {
+		chats = nested.Histories
+	} else {
+		json.Unmarshal(export.Histories, &chats)
+	}
+	if len(export.Turns) > 0 {
+		chats = append(chats, caiChat{Turns: export.Turns})
+	}
+
+	var histories [][]Message
+	for _, chat := range chats {
+		var history []Message
+		for _, msg := range append(chat.Msgs, chat.Turns...) {
+			text, human := msg.Text, msg.Src.IsHuman || msg.Author.IsHuman
+			if text == "" && len(msg.Candidates) > 0 {
+				text = msg.Candidates[len(msg.Candidates)-1].RawContent
+			}
+			if strings.TrimSpace(text) == "" {
+				continue
+			}
+			role := "assistant"
+			if human {
+				role = "user"
+			}
+			history = append(history, Message{Role: role, Content: text})
+		}
+		if len(history) > 0 {
+			histories = append(histories, history)
+		}
+	}
+	return character, histories, true
+}
+
+// saveImportedChats saves chats from another app as sessions with
+// character, named after it.
+func saveImportedChats(character Character, histories [][]Message) int {
+	saved := 0
+	for _, history := range histories {
+		name := character.Name + " (c.ai)"
+		for i := 2; ; i++ {
+			if _, err := loadSession(name); err != nil {
+				break
+			}
+			name = character.Name + " (c.ai) " + strconv.Itoa(i)
+		}
+		now := time.Now()
+		session := Session{Name: name, Character: character.Name, Created: now, Updated: now, Branch: MainBranch, History: history}
+		if err := saveSession(session); err != nil {
+			printErrorf("Error saving session '%s': %v\n", name, err)
+			continue
+		}
+		saved++
+	}
+	if saved > 0 {
+		fmt.Printf("Imported %d chat(s). Find them using /sessions\n", saved)
+	}
+	return saved
+}
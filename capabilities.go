@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const probeTimeout = 3 * time.Second
+
+// BackendCapabilities is what the configured backend and model support, as
+// detected at startup. If the probe failed, Probed is false and no feature
+// is denied, since we can't tell either way.
+type BackendCapabilities struct {
+	Probed    bool
+	Version   string
+	Streaming bool
+	Tools     bool
+	Vision    bool
+	LogitBias bool
+}
+
+var backendCaps BackendCapabilities
+
+// backendBaseURL strips the endpoint path from an Ollama URL such as
+// http://localhost:11434/api/chat.
+func backendBaseURL(url string) string {
+	if i := strings.Index(url, "/api/"); i >= 0 {
+		return url[:i]
+	}
+	return strings.TrimSuffix(url, "/")
+}
+
+func probeCapabilities(client *http.Client, config *Config) BackendCapabilities {
+	base := backendBaseURL(config.URL)
+	var caps BackendCapabilities
+
+	var version struct {
+		Version string `json:"version"`
+	}
+	if err := probeJSON(client, "GET", base+"/api/version", nil, &version); err != nil {
+		return caps
+	}
+	caps.Probed = true
+	caps.Version = version.Version
+	caps.Streaming = true
+
+	var show struct {
+		Capabilities  []string        `json:"capabilities"`
+		Template      string          `json:"template"`
+		ProjectorInfo json.RawMessage `json:"projector_info"`
+	}
+	if err := probeJSON(client, "POST", base+"/api/show", map[string]string{"model": config.Model}, &show); err != nil {
+		return caps
+	}
+	if show.Capabilities != nil {
+		for _, capability := range show.Capabilities {
+			switch capability {
+			case "tools":
+				caps.Tools = true
+			case "vision":
+				caps.Vision = true
+			}
+		}
+	} else {
+		// Older Ollama versions don't list capabilities.
+		caps.Tools = strings.Contains(show.Template, ".Tools")
+		caps.Vision = len(show.ProjectorInfo) > 0
+	}
+	return caps
+}
+
+func probeJSON(client *http.Client, method, url string, body interface{}, v interface{}) error {
+	ctx, cancel := context.WithTimeout(context.Background(), probeTimeout)
+	defer cancel()
+
+	var data []byte
+	if body != nil {
+		data, _ = json.Marshal(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, url, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s returned %s", url, resp.Status)
+	}
+	data, _ = ioutil.ReadAll(resp.Body)
+	return json.Unmarshal(data, v)
+}
+
+// requireCapability reports whether a feature can be used with the current
+// backend, explaining why not when it can't.
+func requireCapability(supported bool, feature string) bool {
+	if !backendCaps.Probed || supported {
+		return true
+	}
+	fmt.Printf("%s is not supported by the current backend or model. See /caps.\n", feature)
+	return false
+}
+
+// warnUnsupportedOptions warns once about each backend option that
+// chatOptions leaves out because the backend would silently ignore it. The
+// config keeps them, so saving it doesn't lose them.
+func warnUnsupportedOptions(config *Config) {
+	if _, ok := config.Options["logit_bias"]; ok && !supportsOption("logit_bias") {
+		fmt.Println("Warning: the backend does not support logit_bias; the option will not be sent.")
+	}
+}
+
+// supportsOption reports whether the backend takes an option, assuming it
+// does when it couldn't be probed.
+func supportsOption(key string) bool {
+	return key != "logit_bias" || !backendCaps.Probed || backendCaps.LogitBias
+}
+
+func displayCapabilities(config *Config) {
+	fmt.Println("\n[Backend Capabilities]:")
+	if !backendCaps.Probed {
+		fmt.Printf("Could not probe %s. All features are enabled, but some may not work.\n", backendBaseURL(config.URL))
+		return
+	}
+	fmt.Printf("Ollama Version: %s\n", backendCaps.Version)
+	fmt.Printf("Model: %s\n", config.Model)
+	for _, c := range []struct {
+		name      string
+		supported bool
+	}{
+		{"Streaming", backendCaps.Streaming},
+		{"Function Calls", backendCaps.Tools},
+		{"Images", backendCaps.Vision},
+		{"Logit Bias", backendCaps.LogitBias},
+	} {
+		status := "no"
+		if c.supported {
+			status = "yes"
+		}
+		fmt.Printf("%s: %s\n", c.name, status)
+	}
+}
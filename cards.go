@@ -0,0 +1,381 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"image"
+	"image/png"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+)
+
+// Character card specs. V3 is a superset of V2; both keep the character
+// under "data", while V1 cards have the fields at the top level.
+const (
+	CardSpecV2 = "chara_card_v2"
+	CardSpecV3 = "chara_card_v3"
+)
+
+// cardFields are the card data fields mapped onto Character. Anything else
+// under "data" is kept in Character.CardExtra.
+var cardFields = []string{"name", "description", "personality", "scenario", "first_mes", "mes_example",
+	"creator_notes", "system_prompt", "post_history_instructions", "alternate_greetings", "tags", "creator",
+	"character_version", "character_book", "extensions"}
+
+type cardData struct {
+	Name               string                     `json:"name"`
+	Description        string                     `json:"description"`
+	Personality        string                     `json:"personality"`
+	Scenario           string                     `json:"scenario"`
+	FirstMessage       string                     `json:"first_mes"`
+	Examples           string                     `json:"mes_example"`
+	CreatorNotes       string                     `json:"creator_notes"`
+	SystemPrompt       string                     `json:"system_prompt"`
+	PostHistory        string                     `json:"post_history_instructions"`
+	AlternateGreetings []string                   `json:"alternate_greetings"`
+	Tags               []string                   `json:"tags"`
+	Creator            string                     `json:"creator"`
+	CharacterVersion   string                     `json:"character_version"`
+	CharacterBook      json.RawMessage            `json:"character_book,omitempty"`
+	Extensions         map[string]json.RawMessage `json:"extensions"`
+}
+
+// importCard reads a character card, either JSON or a PNG with the card
+// embedded the way SillyTavern and other frontends share them.
+func importCard(data []byte) (Character, error) {
+	if bytes.HasPrefix(data, pngSignature) {
+		var err error
+		if data, err = cardFromPNG(data); err != nil {
+			return Character{}, err
+		}
+	}
+
+	var card struct {
+		Spec string                     `json:"spec"`
+		Data map[string]json.RawMessage `json:"data"`
+	}
+	if err := json.Unmarshal(data, &card); err != nil {
+		return Character{}, err
+	}
+	raw := card.Data
+	if card.Spec != CardSpecV2 && card.Spec != CardSpecV3 {
+		// A V1 card: the fields are the whole object.
+		raw = nil
+		if err := json.Unmarshal(data, &raw); err != nil {
+			return Character{}, err
+		}
+		delete(raw, "spec")
+		delete(raw, "spec_version")
+	}
+
+	var fields cardData
+	joined, _ := json.Marshal(raw)
+	if err := json.Unmarshal(joined, &fields); err != nil {
+		return Character{}, fmt.Errorf("invalid card: %v", err)
+	}
+	if fields.Name == "" {
+		return Character{}, fmt.Errorf("not a character card: no name found")
+	}
+	for _, field := range cardFields {
+		delete(raw, field)
+	}
+	if len(raw) == 0 {
+		raw = nil
+	}
+
+	return Character{
+		Name:               fields.Name,
+		Definition:         fields.Description,
+		Greeting:           fields.FirstMessage,
+		PostHistory:        fields.PostHistory,
+		Personality:        fields.Personality,
+		Scenario:           fields.Scenario,
+		Examples:           fields.Examples,
+		AlternateGreetings: fields.AlternateGreetings,
+		SystemPrompt:       fields.SystemPrompt,
+		CreatorNotes:       fields.CreatorNotes,
+		Creator:            fields.Creator,
+		CharacterVersion:   fields.CharacterVersion,
+		Tags:               fields.Tags,
+		CharacterBook:      fields.CharacterBook,
+		Extensions:         fields.Extensions,
+		CardSpec:           card.Spec,
+		CardExtra:          raw,
+	}, nil
+}
+
+// exportCard writes character as a card of the given spec, with the V1
+// fields at the top level too for older frontends.
+func exportCard(character Character, spec string) ([]byte, error) {
+	fields := cardData{
+		Name:               character.Name,
+		Description:        character.Definition,
+		Personality:        character.Personality,
+		Scenario:           character.Scenario,
+		FirstMessage:       character.Greeting,
+		Examples:           character.Examples,
+		CreatorNotes:       character.CreatorNotes,
+		SystemPrompt:       character.SystemPrompt,
+		PostHistory:        character.PostHistory,
+		AlternateGreetings: character.AlternateGreetings,
+		Tags:               character.Tags,
+		Creator:            character.Creator,
+		CharacterVersion:   character.CharacterVersion,
+		CharacterBook:      character.CharacterBook,
+		Extensions:         character.Extensions,
+	}
+	// The spec requires these to be present, even if empty.
+	if fields.AlternateGreetings == nil {
+		fields.AlternateGreetings = []string{}
+	}
+	if fields.Tags == nil {
+		fields.Tags = []string{}
+	}
+	if fields.Extensions == nil {
+		fields.Extensions = map[string]json.RawMessage{}
+	}
+
+	encoded, _ := json.Marshal(fields)
+	var data map[string]interface{}
+	json.Unmarshal(encoded, &data)
+	for key, value := range character.CardExtra {
+		data[key] = value
+	}
+	if spec == CardSpecV3 {
+		if _, ok := data["group_only_greetings"]; !ok {
+			data["group_only_greetings"] = []string{}
+		}
+	}
+
+	version := "2.0"
+	if spec == CardSpecV3 {
+		version = "3.0"
+	}
+	card := map[string]interface{}{"spec": spec, "spec_version": version, "data": data}
+	for _, field := range []string{"name", "description", "personality", "scenario", "first_mes", "mes_example"} {
+		card[field] = data[field]
+	}
+	return json.MarshalIndent(card, "", "  ")
+}
+
+var pngSignature = []byte("\x89PNG\r\n\x1a\n")
+
+// cardFromPNG returns the card JSON embedded in a PNG's tEXt chunks,
+// preferring the V3 "ccv3" chunk over the V2 "chara" one.
+func cardFromPNG(data []byte) ([]byte, error) {
+	texts := map[string]string{}
+	for pos := len(pngSignature); pos+8 <= len(data); {
+		length := int(binary.BigEndian.Uint32(data[pos:]))
+		kind := string(data[pos+4 : pos+8])
+		if length < 0 || pos+12+length > len(data) {
+			break
+		}
+		if kind == "tEXt" {
+			chunk := data[pos+8 : pos+8+length]
+			if i := bytes.IndexByte(chunk, 0); i > 0 {
+				texts[strings.ToLower(string(chunk[:i]))] = string(chunk[i+1:])
+			}
+		}
+		if kind == "IEND" {
+			break
+		}
+		pos += 12 + length
+	}
+	for _, keyword := range []string{"ccv3", "chara"} {
+		if text, ok := texts[keyword]; ok {
+			return base64.StdEncoding.DecodeString(text)
+		}
+	}
+	return nil, fmt.Errorf("no character card found in the image")
+}
+
+// Size of the plain image used for PNG cards of characters without an
+// avatar, in the portrait shape frontends show cards in.
+const (
+	cardImageWidth  = 400
+	cardImageHeight = 600
+)
+
+// cardToPNG embeds cards in img as base64 tEXt chunks, keyed "chara" or
+// "ccv3", replacing any card it already had. An image that isn't a PNG is
+// converted, and without one a plain image is used.
+func cardToPNG(img []byte, cards [][2]string) ([]byte, error) {
+	if len(img) == 0 {
+		plain := image.NewGray(image.Rect(0, 0, cardImageWidth, cardImageHeight))
+		for i := range plain.Pix {
+			plain.Pix[i] = 0x40
+		}
+		img = encodePNG(plain)
+	} else if !bytes.HasPrefix(img, pngSignature) {
+		decoded, _, err := image.Decode(bytes.NewReader(img))
+		if err != nil {
+			return nil, fmt.Errorf("reading the avatar: %v", err)
+		}
+		img = encodePNG(decoded)
+	}
+
+	out := bytes.NewBuffer(append([]byte{}, pngSignature...))
+	for pos := len(pngSignature); pos+12 <= len(img); {
+		length := int(binary.BigEndian.Uint32(img[pos:]))
+		if pos+12+length > len(img) {
+			return nil, fmt.Errorf("the avatar is not a valid PNG")
+		}
+		chunk := img[pos : pos+12+length]
+		kind := string(chunk[4:8])
+		pos += 12 + length
+		if kind == "tEXt" {
+			text := chunk[8 : 8+length]
+			if i := bytes.IndexByte(text, 0); i > 0 {
+				if keyword := strings.ToLower(string(text[:i])); keyword == "chara" || keyword == "ccv3" {
+					continue
+				}
+			}
+		}
+		if kind == "IEND" {
+			for _, card := range cards {
+				writePNGChunk(out, "tEXt", []byte(card[0]+"\x00"+base64.StdEncoding.EncodeToString([]byte(card[1]))))
+			}
+		}
+		out.Write(chunk)
+	}
+	return out.Bytes(), nil
+}
+
+func writePNGChunk(out *bytes.Buffer, kind string, data []byte) {
+	var length [4]byte
+	binary.BigEndian.PutUint32(length[:], uint32(len(data)))
+	out.Write(length[:])
+	crc := crc32.NewIEEE()
+	crc.Write([]byte(kind))
+	crc.Write(data)
+	out.WriteString(kind)
+	out.Write(data)
+	binary.Write(out, binary.BigEndian, crc.Sum32())
+}
+
+func encodePNG(img image.Image) []byte {
+	var buf bytes.Buffer
+	png.Encode(&buf, img)
+	return buf.Bytes()
+}
+
+// exportPNGCard writes character as a PNG card on its avatar. V3 cards
+// carry a V2 copy as well, for frontends that only read those.
+func exportPNGCard(character Character, spec string) ([]byte, error) {
+	v2, err := exportCard(character, CardSpecV2)
+	if err != nil {
+		return nil, err
+	}
+	cards := [][2]string{{"chara", string(v2)}}
+	if spec == CardSpecV3 {
+		v3, err := exportCard(character, CardSpecV3)
+		if err != nil {
+			return nil, err
+		}
+		cards = append(cards, [2]string{"ccv3", string(v3)})
+	}
+	var img []byte
+	if character.Avatar != "" {
+		if img, err = ioutil.ReadFile(character.Avatar); err != nil {
+			return nil, fmt.Errorf("reading the avatar: %v", err)
+		}
+	}
+	return cardToPNG(img, cards)
+}
+
+// importCharacterFile handles /char import {file}: a character card, or a
+// character.ai export along with its chats.
+func importCharacterFile(path string) {
+	if path == "" {
+		fmt.Println("Usage: /char import {file}")
+		return
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		printError("Error reading card:", err)
+		return
+	}
+	installCharacter(data, strings.TrimSuffix(filepath.Base(path), filepath.Ext(path)), false)
+}
+
+// installCharacter saves the card or character.ai export in data. Card
+// names are free text, so fallback is used if the card's name can't be a
+// file name itself. With confirm, it shows the character and asks first.
+func installCharacter(data []byte, fallback string, confirm bool) {
+	character, chats, fromCAI := importCAI(data)
+	if !fromCAI {
+		var err error
+		if character, err = importCard(data); err != nil {
+			printError("Error importing card:", err)
+			return
+		}
+	}
+	if _, err := characterPath(character.Name); err != nil {
+		character.Name = fallback
+	}
+	if confirm && !confirmInstall(character) {
+		return
+	}
+	// A chat export has less of the character than one exported on its
+	// own, so it doesn't replace one imported already.
+	if fromCAI && len(chats) > 0 {
+		if _, err := loadCharacter(character.Name); err == nil {
+			fmt.Printf("Adding the chats to the saved character '%s'.\n", character.Name)
+			saveImportedChats(character, chats)
+			return
+		}
+	}
+	// A PNG card is the character's picture as well.
+	if bytes.HasPrefix(data, pngSignature) {
+		if avatar, err := saveAvatar(character.Name, data, ".png"); err == nil {
+			character.Avatar = avatar
+		}
+	}
+	if err := saveCharacter(character); err != nil {
+		printError("Error saving character:", err)
+		return
+	}
+	fmt.Printf("Imported character '%s'. Switch to it using: /char load %s\n", character.Name, character.Name)
+	saveImportedChats(character, chats)
+}
+
+// exportCharacterFile handles /char export {name} {file} [v2 | v3]. Cards
+// keep the spec they were imported with unless one is given, and a file
+// ending in .png gets a PNG card with the character's avatar.
+func exportCharacterFile(args []string) {
+	if len(args) < 2 || len(args) > 3 {
+		fmt.Println("Usage: /char export {name} {file} [v2 | v3]")
+		return
+	}
+	character, err := loadCharacter(args[0])
+	if err != nil {
+		printError("Error loading character:", err)
+		return
+	}
+	spec := character.CardSpec
+	if len(args) == 3 {
+		spec = map[string]string{"v2": CardSpecV2, "v3": CardSpecV3}[args[2]]
+	}
+	if spec != CardSpecV3 {
+		spec = CardSpecV2
+	}
+	var data []byte
+	if strings.EqualFold(filepath.Ext(args[1]), ".png") {
+		data, err = exportPNGCard(character, spec)
+	} else {
+		data, err = exportCard(character, spec)
+	}
+	if err == nil {
+		err = ioutil.WriteFile(args[1], data, 0644)
+	}
+	if err != nil {
+		printError("Error exporting character:", err)
+		return
+	}
+	fmt.Printf("Exported '%s' to %s\n", character.Name, args[1])
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ReleasesURL lists published releases, to show notes for versions newer
+// than this one.
+const ReleasesURL = "https://api.github.com/repos/SpvceR3ii/character.chat/releases"
+
+//go:embed CHANGELOG.md
+var bundledChangelog string
+
+type release struct {
+	Tag  string `json:"tag_name"`
+	Name string `json:"name"`
+	Body string `json:"body"`
+}
+
+// handleChangelogCommand shows the release notes bundled in the binary,
+// after the notes of any newer releases.
+func handleChangelogCommand(client *http.Client) {
+	if newer, err := newerReleases(client); err != nil {
+		fmt.Println("Could not check for newer releases:", err)
+	} else if len(newer) > 0 {
+		fmt.Printf("\n[Newer Releases]: %d release(s) newer than %s\n", len(newer), AppVersion)
+		for _, r := range newer {
+			title := r.Tag
+			if r.Name != "" && r.Name != r.Tag {
+				title += " - " + r.Name
+			}
+			fmt.Printf("\n## %s\n\n%s\n", title, strings.TrimSpace(r.Body))
+		}
+	}
+	page(fmt.Sprintf("\n[Changelog] (this is version %s):\n\n%s", AppVersion, strings.TrimPrefix(bundledChangelog, "# Changelog\n\n")))
+}
+
+func newerReleases(client *http.Client) ([]release, error) {
+	req, _ := http.NewRequest("GET", ReleasesURL, nil)
+	req.Header.Set("Accept", "application/vnd.github+json")
+	c := *client
+	c.Timeout = 5 * time.Second
+	resp, err := c.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GitHub returned %s", resp.Status)
+	}
+	var releases []release
+	if err := json.NewDecoder(resp.Body).Decode(&releases); err != nil {
+		return nil, err
+	}
+	var newer []release
+	for _, r := range releases {
+		if compareVersions(r.Tag, AppVersion) > 0 {
+			newer = append(newer, r)
+		}
+	}
+	return newer, nil
+}
+
+// compareVersions compares dotted version numbers such as "v1.2.0",
+// returning -1, 0 or 1.
+func compareVersions(a, b string) int {
+	as := strings.Split(strings.TrimPrefix(a, "v"), ".")
+	bs := strings.Split(strings.TrimPrefix(b, "v"), ".")
+	for i := 0; i < len(as) || i < len(bs); i++ {
+		var x, y int
+		if i < len(as) {
+			x, _ = strconv.Atoi(as[i])
+		}
+		if i < len(bs) {
+			y, _ = strconv.Atoi(bs[i])
+		}
+		if x != y {
+			if x < y {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
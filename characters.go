@@ -0,0 +1,258 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const CharactersDir = "characters"
+
+// Character is a persona stored in the characters directory. The definition
+// and greeting in the main config act as the unnamed default character.
+type Character struct {
+	Name       string `json:"name"`
+	Definition string `json:"definition"`
+	Greeting   string `json:"greeting"`
+	// PostHistory is sent after the chat history, right before the reply,
+	// where it steers style much more than the definition does.
+	PostHistory string `json:"post_history_instructions,omitempty"`
+	// Avatar is the path of an image shown when the character is loaded.
+	Avatar string `json:"avatar,omitempty"`
+	// Voice is how /tts reads the character's replies.
+	Voice *VoiceSettings `json:"voice,omitempty"`
+	// Model and Options, such as temperature and num_ctx, replace the
+	// config's while the character is loaded.
+	Model   string                 `json:"model,omitempty"`
+	Options map[string]interface{} `json:"options,omitempty"`
+	// Blocklist adds phrases this character overuses to the config's.
+	Blocklist []string `json:"blocklist,omitempty"`
+
+	// The rest of the character card fields, kept so cards survive a
+	// round trip. Personality is sent with the definition, and Scenario
+	// after it as the setting.
+	Personality        string                     `json:"personality,omitempty"`
+	Scenario           string                     `json:"scenario,omitempty"`
+	Examples           string                     `json:"mes_example,omitempty"`
+	AlternateGreetings []string                   `json:"alternate_greetings,omitempty"`
+	SystemPrompt       string                     `json:"system_prompt,omitempty"`
+	CreatorNotes       string                     `json:"creator_notes,omitempty"`
+	Creator            string                     `json:"creator,omitempty"`
+	CharacterVersion   string                     `json:"character_version,omitempty"`
+	Tags               []string                   `json:"tags,omitempty"`
+	CharacterBook      json.RawMessage            `json:"character_book,omitempty"`
+	Extensions         map[string]json.RawMessage `json:"extensions,omitempty"`
+	CardSpec           string                     `json:"card_spec,omitempty"`
+	CardExtra          map[string]json.RawMessage `json:"card_extra,omitempty"`
+}
+
+// definitionPrompt is who the character is, for the system prompt.
+func (c Character) definitionPrompt() string {
+	parts := []string{c.Definition}
+	if c.Personality != "" {
+		parts = append(parts, c.Personality)
+	}
+	return strings.Join(parts, "\n\n")
+}
+
+// characterPrompt is the definition followed by the character's scenario,
+// for prompts outside the chat.
+func (c Character) characterPrompt() string {
+	if scenario := scenarioPrompt(c.Scenario); scenario != "" {
+		return c.definitionPrompt() + "\n\n" + scenario
+	}
+	return c.definitionPrompt()
+}
+
+// tagline is a line saying what the character is: the first line of the
+// creator's notes, or of the definition.
+func (c Character) tagline() string {
+	text := strings.TrimSpace(c.CreatorNotes)
+	if text == "" {
+		text = strings.TrimSpace(c.Definition)
+	}
+	return truncateText(strings.SplitN(text, "\n", 2)[0], 80)
+}
+
+// promptTokens estimates how much of the context the character takes up.
+func (c Character) promptTokens() int {
+	return estimateTokens(c.characterPrompt()) + estimateTokens(examplesPrompt(c))
+}
+
+var activeCharacter Character
+
+func getCharactersDir() string {
+	return filepath.Join(getConfigDir(), CharactersDir)
+}
+
+func characterPath(name string) (string, error) {
+	if name == "" || name != filepath.Base(name) || strings.HasPrefix(name, ".") {
+		return "", fmt.Errorf("invalid character name %q", name)
+	}
+	return filepath.Join(getCharactersDir(), name+".json"), nil
+}
+
+func defaultCharacter(config Config) Character {
+	return Character{Definition: config.Definition, Greeting: config.Greeting}
+}
+
+// characterKey identifies a character for bookkeeping such as quotas.
+func characterKey(character Character) string {
+	if character.Name == "" {
+		return "default"
+	}
+	return character.Name
+}
+
+// characterDisplayName is how the character is labelled to the user.
+func characterDisplayName(character Character) string {
+	if character.Name == "" {
+		return "Chatbot"
+	}
+	return character.Name
+}
+
+func (fileStorage) LoadCharacter(name string) (Character, error) {
+	path, err := characterPath(name)
+	if err != nil {
+		return Character{}, err
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return Character{}, err
+	}
+	var character Character
+	if err := json.Unmarshal(data, &character); err != nil {
+		return Character{}, err
+	}
+	character.Name = name
+	return character, nil
+}
+
+func (fileStorage) SaveCharacter(character Character) error {
+	path, err := characterPath(character.Name)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return err
+	}
+	data, _ := json.MarshalIndent(character, "", "  ")
+	return ioutil.WriteFile(path, data, 0644)
+}
+
+func (fileStorage) ListCharacters() ([]string, error) {
+	files, err := filepath.Glob(filepath.Join(getCharactersDir(), "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(files))
+	for _, file := range files {
+		names = append(names, strings.TrimSuffix(filepath.Base(file), ".json"))
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// loadActiveCharacter returns the character named in the config, falling
+// back to the config's own definition if it is unset or cannot be read.
+func loadActiveCharacter(config Config) Character {
+	if config.Character == "" {
+		return defaultCharacter(config)
+	}
+	character, err := loadCharacter(config.Character)
+	if err != nil {
+		printErrorf("Error loading character '%s': %v\n", config.Character, err)
+		return defaultCharacter(config)
+	}
+	return character
+}
+
+func handleCharCommand(args string, client *http.Client, config *Config) {
+	fields := strings.Fields(args)
+	if len(fields) == 0 {
+		if activeCharacter.Name == "" {
+			fmt.Println("Using the default character from the config.")
+		} else {
+			fmt.Printf("Current character: %s\n", activeCharacter.Name)
+		}
+		fmt.Println("Usage: /char [list | browse ... | load {name} | save {name} | import {file} | get {url} | export {name} {file} | avatar [file | show | clear] | instructions [\"...\" | clear] | clear]")
+		return
+	}
+
+	name := strings.TrimSpace(strings.TrimPrefix(args, fields[0]))
+	switch fields[0] {
+	case "list":
+		handleCharList()
+	case "load":
+		character, err := loadCharacter(name)
+		if err != nil {
+			printError("Error loading character:", err)
+			return
+		}
+		switchCharacter(character, config)
+	case "save":
+		character := activeCharacter
+		character.Name = name
+		if err := saveCharacter(character); err != nil {
+			printError("Error saving character:", err)
+			return
+		}
+		activeCharacter = character
+		config.Character = name
+		saveConfig(*config)
+		fmt.Printf("Character '%s' saved.\n", name)
+	case "browse":
+		handleCharBrowse(fields[1:])
+	case "import":
+		importCharacterFile(name)
+	case "get":
+		handleCharGet(name, client)
+	case "export":
+		exportCharacterFile(fields[1:])
+	case "instructions":
+		handlePostHistoryCommand(name)
+	case "avatar":
+		handleAvatarCommand(name, config)
+	case "clear":
+		switchCharacter(defaultCharacter(*config), config)
+	default:
+		fmt.Println("Usage: /char [list | browse ... | load {name} | save {name} | import {file} | get {url} | export {name} {file} | avatar [file | show | clear] | instructions [\"...\" | clear] | clear]")
+	}
+}
+
+// handlePostHistoryCommand shows or changes the active character's
+// post-history instructions. Save the character to keep the change.
+func handlePostHistoryCommand(args string) {
+	switch args {
+	case "":
+		if activeCharacter.PostHistory == "" {
+			fmt.Println("No post-history instructions. Set them using: /char instructions \"...\"")
+			return
+		}
+		fmt.Printf("\n[Post-History Instructions]: %s\n", activeCharacter.PostHistory)
+	case "clear":
+		activeCharacter.PostHistory = ""
+		fmt.Println("Post-history instructions cleared.")
+	default:
+		activeCharacter.PostHistory = strings.Trim(args, "\"")
+		fmt.Println("Post-history instructions set.")
+	}
+}
+
+// switchCharacter makes character the active one and starts a fresh chat
+// with its greeting.
+func switchCharacter(character Character, config *Config) {
+	activeCharacter = character
+	config.Character = character.Name
+	saveConfig(*config)
+	resetSession()
+	applySettings(config)
+	showAvatar(activeCharacter, config)
+	displayGreeting(pickGreeting(activeCharacter), config)
+}
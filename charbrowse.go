@@ -0,0 +1,207 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+const browseUsage = "Usage: /char browse [tag:{tag}] [creator:{name}] [recent] [search words]"
+
+type browseEntry struct {
+	Character Character
+	LastUsed  time.Time
+	Score     int
+}
+
+// handleCharBrowse lists saved characters, filtered by card tags and
+// creator, sorted by how well the name matches the search words, or by last
+// use with "recent".
+func handleCharBrowse(args []string) {
+	var tags, words []string
+	creator := ""
+	recent := false
+	for _, arg := range args {
+		key, value, _ := strings.Cut(arg, ":")
+		switch {
+		case key == "tag" && value != "":
+			tags = append(tags, strings.ToLower(value))
+		case key == "creator" && value != "":
+			creator = strings.ToLower(value)
+		case arg == "recent":
+			recent = true
+		default:
+			words = append(words, strings.ToLower(arg))
+		}
+	}
+	query := strings.Join(words, " ")
+
+	names, err := listCharacters()
+	if err != nil {
+		printError("Error listing characters:", err)
+		return
+	}
+	lastUsed := charactersLastUsed()
+
+	var entries []browseEntry
+	for _, name := range names {
+		character, err := loadCharacter(name)
+		if err != nil {
+			continue
+		}
+		if !hasAllTags(character.Tags, tags) {
+			continue
+		}
+		if creator != "" && !strings.Contains(strings.ToLower(character.Creator), creator) {
+			continue
+		}
+		entry := browseEntry{Character: character, LastUsed: lastUsed[name]}
+		if query != "" {
+			if entry.Score = fuzzyScore(strings.ToLower(name), query); entry.Score < 0 {
+				continue
+			}
+		}
+		if recent && entry.LastUsed.IsZero() {
+			continue
+		}
+		entries = append(entries, entry)
+	}
+
+	sort.SliceStable(entries, func(i, j int) bool {
+		a, b := entries[i], entries[j]
+		if query != "" && a.Score != b.Score {
+			return a.Score > b.Score
+		}
+		if recent {
+			return a.LastUsed.After(b.LastUsed)
+		}
+		return false
+	})
+
+	if len(entries) == 0 {
+		if len(names) == 0 {
+			fmt.Println("No saved characters. Import one using: /char import {file}")
+		} else {
+			fmt.Println("No characters match. " + browseUsage)
+		}
+		return
+	}
+	fmt.Printf("\n[Characters] (%d of %d):\n", len(entries), len(names))
+	for _, entry := range entries {
+		line := "- " + entry.Character.Name
+		if entry.Character.Creator != "" {
+			line += " by " + entry.Character.Creator
+		}
+		if len(entry.Character.Tags) > 0 {
+			line += " [" + strings.Join(entry.Character.Tags, ", ") + "]"
+		}
+		if !entry.LastUsed.IsZero() {
+			line += ", last used " + entry.LastUsed.Format("Jan 2 2006")
+		}
+		fmt.Println(line)
+	}
+	fmt.Println("\nSwitch to one using: /char load {name}")
+}
+
+// handleCharList handles /char list: every saved character with what it
+// is, how much of the prompt it takes, and its saved chats.
+func handleCharList() {
+	names, err := listCharacters()
+	if err != nil {
+		printError("Error listing characters:", err)
+		return
+	}
+	if len(names) == 0 {
+		fmt.Println("No saved characters. Save the current one using: /char save {name}")
+		return
+	}
+	sessions, _ := listSessions()
+	chats, lastUsed := map[string]int{}, map[string]time.Time{}
+	for _, session := range sessions {
+		chats[session.Character]++
+		if session.Updated.After(lastUsed[session.Character]) {
+			lastUsed[session.Character] = session.Updated
+		}
+	}
+
+	fmt.Println("\n[Characters]:")
+	for _, name := range names {
+		character, err := loadCharacter(name)
+		if err != nil {
+			fmt.Printf("- %s (unreadable: %v)\n", name, err)
+			continue
+		}
+		line := fmt.Sprintf("- %s: about %d tokens, %s", name, character.promptTokens(), plural(chats[name], "chat"))
+		if last := lastUsed[name]; !last.IsZero() {
+			line += ", last used " + last.Format("Jan 2 2006")
+		}
+		if name == activeCharacter.Name {
+			line += " (current)"
+		}
+		fmt.Println(line)
+		if tagline := character.tagline(); tagline != "" {
+			fmt.Println("  " + tagline)
+		}
+	}
+}
+
+// charactersLastUsed returns when each character's most recent saved
+// session was updated.
+func charactersLastUsed() map[string]time.Time {
+	sessions, _ := listSessions()
+	last := map[string]time.Time{}
+	for _, session := range sessions {
+		if session.Updated.After(last[session.Character]) {
+			last[session.Character] = session.Updated
+		}
+	}
+	return last
+}
+
+func hasAllTags(have, want []string) bool {
+	for _, tag := range want {
+		found := false
+		for _, t := range have {
+			if strings.ToLower(t) == tag {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// fuzzyScore matches query against name as a subsequence, returning -1 if
+// it doesn't match. Consecutive runs and matches at word starts score
+// higher, so "sh" prefers "Sherlock Holmes" to "Mrs Hudson".
+func fuzzyScore(name, query string) int {
+	query = strings.ReplaceAll(query, " ", "")
+	score, qi := 0, 0
+	q := []rune(query)
+	prevMatched := false
+	var prev rune = ' '
+	for _, r := range name {
+		if qi < len(q) && r == q[qi] {
+			score++
+			if prevMatched {
+				score += 2
+			}
+			if prev == ' ' || prev == '_' || prev == '-' {
+				score += 3
+			}
+			qi++
+			prevMatched = true
+		} else {
+			prevMatched = false
+		}
+		prev = r
+	}
+	if qi < len(q) {
+		return -1
+	}
+	return score
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+)
+
+const maxCardSize = 20 << 20
+
+// handleCharGet handles /char get {url}: downloading a character card,
+// showing what it is and installing it.
+func handleCharGet(rawURL string, client *http.Client) {
+	u, err := url.Parse(rawURL)
+	if rawURL == "" || err != nil || u.Scheme != "http" && u.Scheme != "https" {
+		fmt.Println("Usage: /char get {http or https URL of a PNG or JSON card}")
+		return
+	}
+	fmt.Println("Downloading...")
+	resp, err := client.Get(u.String())
+	if err != nil {
+		printError("Error downloading card:", err)
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		printErrorf("Error downloading card: %s\n", resp.Status)
+		return
+	}
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxCardSize+1))
+	if err != nil {
+		printError("Error downloading card:", err)
+		return
+	}
+	if len(data) > maxCardSize {
+		printErrorf("Error: the card is over %d MB.\n", maxCardSize>>20)
+		return
+	}
+
+	fallback := strings.TrimSuffix(path.Base(u.Path), path.Ext(u.Path))
+	if fallback == "" || fallback == "/" || fallback == "." {
+		fallback = u.Hostname()
+	}
+	installCharacter(data, fallback, true)
+}
+
+// confirmInstall shows a downloaded character and asks whether to keep
+// it.
+func confirmInstall(character Character) bool {
+	fmt.Printf("\n[%s]\n", character.Name)
+	if tagline := character.tagline(); tagline != "" {
+		fmt.Println(tagline)
+	}
+	if character.Creator != "" {
+		fmt.Printf("By %s\n", character.Creator)
+	}
+	fmt.Printf("About %d tokens of prompt\n", character.promptTokens())
+	if _, err := loadCharacter(character.Name); err == nil {
+		fmt.Printf("This replaces the saved character '%s'.\n", character.Name)
+	}
+	answer := promptUserForInput("Install it? (y/n)", "y")
+	if !strings.HasPrefix(strings.ToLower(answer), "y") {
+		fmt.Println("Not installed.")
+		return false
+	}
+	return true
+}
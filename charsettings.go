@@ -0,0 +1,82 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+)
+
+// modelSettings is a model and options, such as temperature and num_ctx,
+// used over the config's.
+type modelSettings struct {
+	Model   string                 `json:"model,omitempty"`
+	Options map[string]interface{} `json:"options,omitempty"`
+}
+
+// configured is the model and options from the config file while the
+// active character's or chat's replace them, so they can be put back, and
+// so saveConfig writes the user's own.
+var configured *modelSettings
+
+// refreshCapabilities probes the backend again after the model changes. It
+// is set up in main once there is a client.
+var refreshCapabilities func()
+
+func newCapabilityRefresher(client *http.Client, config *Config) func() {
+	return func() { backendCaps = probeCapabilities(client, config) }
+}
+
+// applySettings uses the active character's model and options over the
+// config's, and this chat's overrides over both, putting back the config's
+// own when there are none.
+func applySettings(config *Config) {
+	model := config.Model
+	if configured != nil {
+		config.Model, config.Options = configured.Model, configured.Options
+		configured = nil
+	}
+	layers := []struct {
+		what     string
+		settings modelSettings
+	}{
+		{characterDisplayName(activeCharacter), modelSettings{activeCharacter.Model, activeCharacter.Options}},
+		{"This chat", chatOverrides.modelSettings},
+	}
+	for _, layer := range layers {
+		s := layer.settings
+		if s.Model == "" && len(s.Options) == 0 {
+			continue
+		}
+		if configured == nil {
+			configured = &modelSettings{config.Model, config.Options}
+		}
+		var changes []string
+		if s.Model != "" {
+			config.Model = s.Model
+			changes = append(changes, "the model "+s.Model)
+		}
+		if len(s.Options) > 0 {
+			options := map[string]interface{}{}
+			for key, value := range config.Options {
+				options[key] = value
+			}
+			for key, value := range s.Options {
+				options[key] = value
+			}
+			config.Options = options
+			changes = append(changes, "its own options")
+		}
+		notice("[Settings]: %s uses %s.\n", layer.what, strings.Join(changes, " and "))
+	}
+	if config.Model != model && refreshCapabilities != nil {
+		refreshCapabilities()
+	}
+}
+
+// userConfig is config as the user set it, without the active character's
+// or chat's settings.
+func userConfig(config Config) Config {
+	if configured != nil {
+		config.Model, config.Options = configured.Model, configured.Options
+	}
+	return config
+}
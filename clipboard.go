@@ -0,0 +1,95 @@
+package main
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"strings"
+
+	"golang.org/x/term"
+)
+
+// clipboardCommands are tried in order on Linux and the BSDs, falling back
+// to OSC 52 if none works.
+var clipboardCommands = [][]string{
+	{"wl-copy"},
+	{"xclip", "-selection", "clipboard"},
+	{"xsel", "--clipboard", "--input"},
+}
+
+// copyToClipboard puts text on the system clipboard. Over SSH, or when no
+// clipboard tool is found, it asks the terminal to do it with OSC 52.
+func copyToClipboard(text string) error {
+	if os.Getenv("SSH_TTY") != "" || os.Getenv("SSH_CONNECTION") != "" {
+		return copyOSC52(text)
+	}
+	var candidates [][]string
+	switch runtime.GOOS {
+	case "darwin":
+		candidates = [][]string{{"pbcopy"}}
+	case "windows":
+		candidates = [][]string{{"powershell", "-NoProfile", "-Command", "$input | Set-Clipboard"}, {"clip"}}
+	default:
+		for _, candidate := range clipboardCommands {
+			if candidate[0] == "wl-copy" && os.Getenv("WAYLAND_DISPLAY") == "" {
+				continue
+			}
+			candidates = append(candidates, candidate)
+		}
+	}
+	for _, candidate := range candidates {
+		if _, err := exec.LookPath(candidate[0]); err != nil {
+			continue
+		}
+		cmd := exec.Command(candidate[0], candidate[1:]...)
+		cmd.Stdin = strings.NewReader(text)
+		if cmd.Run() == nil {
+			return nil
+		}
+	}
+	return copyOSC52(text)
+}
+
+// copyOSC52 writes the escape sequence that has the terminal set its
+// clipboard. Not every terminal supports it, and there's no way to tell.
+func copyOSC52(text string) error {
+	if !term.IsTerminal(int(os.Stdout.Fd())) {
+		return errors.New("no clipboard tool found, and the output isn't a terminal")
+	}
+	fmt.Print("\x1b]52;c;" + base64.StdEncoding.EncodeToString([]byte(text)) + "\a")
+	return nil
+}
+
+// handleCopyCommand handles /copy [number]: the last reply, or the message
+// with that number in /hist.
+func handleCopyCommand(args string) {
+	index := -1
+	if args = strings.TrimSpace(args); args != "" {
+		n, err := strconv.Atoi(args)
+		if err != nil || n < 1 || n > len(messageHistory) {
+			fmt.Println("Usage: /copy [number], numbered as in /hist")
+			return
+		}
+		index = n - 1
+	} else {
+		for i := len(messageHistory) - 1; i >= 0; i-- {
+			if messageHistory[i].Role == "assistant" {
+				index = i
+				break
+			}
+		}
+		if index < 0 {
+			fmt.Println("No reply to copy yet.")
+			return
+		}
+	}
+	if err := copyToClipboard(messageHistory[index].Content); err != nil {
+		printError("Error copying:", err)
+		return
+	}
+	fmt.Printf("Copied message %d to the clipboard.\n", index+1)
+}
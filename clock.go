@@ -0,0 +1,191 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ClockConfig keeps a world time for the story. Start is when the story
+// begins, as "2006-01-02 15:04"; by default it's when the chat started.
+// PerTurn is how much time passes with each reply, like "10m", and
+// defaults to five minutes.
+type ClockConfig struct {
+	Start   string `json:"start,omitempty"`
+	PerTurn string `json:"per_turn,omitempty"`
+}
+
+const (
+	clockLayout      = "2006-01-02 15:04"
+	defaultClockTurn = 5 * time.Minute
+)
+
+// worldTime is the time in the story, set when the clock is first used.
+// timeSkipped is a /time skip the next reply should know about.
+var (
+	worldTime   *time.Time
+	timeSkipped time.Duration
+)
+
+func clockTime(config *Config) time.Time {
+	if worldTime == nil {
+		start := sessionCreated.Truncate(time.Minute)
+		if config.Clock != nil && config.Clock.Start != "" {
+			if t, err := time.ParseInLocation(clockLayout, config.Clock.Start, time.Local); err == nil {
+				start = t
+			} else {
+				printErrorf("Invalid clock start %q, expected like %q\n", config.Clock.Start, clockLayout)
+			}
+		}
+		worldTime = &start
+	}
+	return *worldTime
+}
+
+var spanPart = regexp.MustCompile(`(\d+)\s*([a-z]+)`)
+
+// parseSpan reads a length of time like "3h", "1d 6h", "45 minutes" or
+// "2 weeks".
+func parseSpan(text string) (time.Duration, error) {
+	text = strings.ToLower(strings.TrimSpace(text))
+	parts := spanPart.FindAllStringSubmatch(text, -1)
+	if len(parts) == 0 || strings.TrimSpace(spanPart.ReplaceAllString(text, "")) != "" {
+		return 0, fmt.Errorf("can't read %q as a length of time, try like 3h, 1d 6h or 45m", text)
+	}
+	var total time.Duration
+	for _, part := range parts {
+		n, _ := strconv.Atoi(part[1])
+		var unit time.Duration
+		switch u := part[2]; {
+		case u == "m" || strings.HasPrefix(u, "min"):
+			unit = time.Minute
+		case u == "h" || strings.HasPrefix(u, "hour"):
+			unit = time.Hour
+		case u == "d" || strings.HasPrefix(u, "day"):
+			unit = 24 * time.Hour
+		case u == "w" || strings.HasPrefix(u, "week"):
+			unit = 7 * 24 * time.Hour
+		default:
+			return 0, fmt.Errorf("unknown unit %q, use m, h, d or w", u)
+		}
+		total += time.Duration(n) * unit
+	}
+	return total, nil
+}
+
+func clockTurn(config *Config) time.Duration {
+	if config.Clock.PerTurn != "" {
+		if span, err := parseSpan(config.Clock.PerTurn); err == nil {
+			return span
+		}
+	}
+	return defaultClockTurn
+}
+
+// advanceClock moves the world time on by a turn once a reply is accepted.
+func advanceClock(config *Config) {
+	if config.Clock == nil {
+		return
+	}
+	t := clockTime(config).Add(clockTurn(config))
+	worldTime = &t
+	timeSkipped = 0
+}
+
+func timeOfDay(t time.Time) string {
+	switch h := t.Hour(); {
+	case h < 5:
+		return "night"
+	case h < 12:
+		return "morning"
+	case h < 17:
+		return "afternoon"
+	case h < 21:
+		return "evening"
+	default:
+		return "night"
+	}
+}
+
+func formatWorldTime(t time.Time) string {
+	return t.Format("Monday, January 2, 3:04 PM") + " (" + timeOfDay(t) + ")"
+}
+
+// formatSpan writes a duration in days, hours and minutes.
+func formatSpan(d time.Duration) string {
+	var parts []string
+	if days := int(d / (24 * time.Hour)); days > 0 {
+		parts = append(parts, plural(days, "day"))
+		d -= time.Duration(days) * 24 * time.Hour
+	}
+	if hours := int(d / time.Hour); hours > 0 {
+		parts = append(parts, plural(hours, "hour"))
+		d -= time.Duration(hours) * time.Hour
+	}
+	if minutes := int(d / time.Minute); minutes > 0 || len(parts) == 0 {
+		parts = append(parts, plural(minutes, "minute"))
+	}
+	return strings.Join(parts, " ")
+}
+
+func plural(n int, word string) string {
+	if n == 1 {
+		return "1 " + word
+	}
+	return strconv.Itoa(n) + " " + word + "s"
+}
+
+// clockPrompt tells the model what time it is in the story.
+func clockPrompt(config *Config) string {
+	if config.Clock == nil {
+		return ""
+	}
+	prompt := "In the story it is now " + formatWorldTime(clockTime(config)) + "."
+	if timeSkipped > 0 {
+		prompt += " " + formatSpan(timeSkipped) + " passed since the last message; reflect that."
+	}
+	return prompt
+}
+
+// handleTimeCommand handles /time [skip {span} | set {time}].
+func handleTimeCommand(args string, config *Config) {
+	if config.Clock == nil {
+		fmt.Println(`The story clock is off. Turn it on using "clock": {} in the config.`)
+		return
+	}
+	command, rest, _ := strings.Cut(strings.TrimSpace(args), " ")
+	rest = strings.TrimSpace(rest)
+	switch command {
+	case "":
+	case "skip":
+		span, err := parseSpan(rest)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		t := clockTime(config).Add(span)
+		worldTime = &t
+		timeSkipped += span
+	case "set":
+		now := clockTime(config)
+		t, err := time.ParseInLocation(clockLayout, rest, time.Local)
+		if err != nil {
+			clock, clockErr := time.ParseInLocation("15:04", rest, time.Local)
+			if clockErr != nil {
+				fmt.Printf("Usage: /time set {%s} or /time set {15:04}\n", clockLayout)
+				return
+			}
+			t = time.Date(now.Year(), now.Month(), now.Day(), clock.Hour(), clock.Minute(), 0, 0, time.Local)
+		}
+		worldTime = &t
+		if t.After(now) {
+			timeSkipped += t.Sub(now)
+		}
+	default:
+		fmt.Println("Usage: /time [skip {3h | 1d 6h | 45m} | set {time}]")
+		return
+	}
+	fmt.Printf("It is %s.\n", formatWorldTime(clockTime(config)))
+}
@@ -0,0 +1,443 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// commandEnv is what command handlers get to work with.
+type commandEnv struct {
+	client *http.Client
+	config *Config
+	debug  bool
+}
+
+// command is a slash command. Args and Help are shown by /help; Complete,
+// if set, returns the possible values for an argument given the arguments
+// typed before it.
+type command struct {
+	Name     string
+	Args     string
+	Help     string
+	Run      func(env *commandEnv, args string)
+	Complete func(args []string) []string
+}
+
+var commands []command
+
+func init() {
+	commands = []command{
+		{
+			Name: "/help", Args: "[command]", Help: "List commands, or show help for one",
+			Run:      func(env *commandEnv, args string) { displayHelp(args) },
+			Complete: func(args []string) []string { return atFirst(args, commandNames()) },
+		},
+		{
+			Name: "/save", Args: "[name]", Help: "Save the chat as a session",
+			Run:      func(env *commandEnv, args string) { handleSaveCommand(args) },
+			Complete: func(args []string) []string { return atFirst(args, sessionNames()) },
+		},
+		{
+			Name: "/load", Args: "{session}", Help: "Load a saved session",
+			Run:      func(env *commandEnv, args string) { handleLoadCommand(args, env.config) },
+			Complete: func(args []string) []string { return atFirst(args, sessionNames()) },
+		},
+		{
+			Name: "/sessions", Args: "[tag:{tag}] [char:{name}] [from:{date}] [to:{date}]", Help: "List saved sessions",
+			Run: func(env *commandEnv, args string) { displaySessions(args) },
+			Complete: func(args []string) []string {
+				options := []string{"from:", "to:"}
+				for _, tag := range allTags() {
+					options = append(options, "tag:"+tag)
+				}
+				names, _ := listCharacters()
+				for _, name := range append(names, "default") {
+					options = append(options, "char:"+name)
+				}
+				return options
+			},
+		},
+		{
+			Name: "/tag", Args: "[add | remove] {tag}...", Help: "Tag the chat, to filter /sessions by",
+			Run: func(env *commandEnv, args string) { handleTagCommand(args) },
+			Complete: func(args []string) []string {
+				if len(args) == 0 {
+					return []string{"add", "remove"}
+				}
+				if args[0] == "remove" {
+					return sessionTags
+				}
+				return allTags()
+			},
+		},
+		{
+			Name: "/search", Args: "{query} | open {number}", Help: "Search all saved sessions",
+			Run: func(env *commandEnv, args string) { handleSearchCommand(args, env.config) },
+		},
+		{
+			Name: "/config", Args: "[option]", Help: "Show the config, or edit an option",
+			Run: func(env *commandEnv, args string) { handleConfigCommand(args, env.client, env.config) },
+			Complete: func(args []string) []string {
+				return atFirst(args, []string{"url", "model", "definition", "greeting"})
+			},
+		},
+		{
+			Name: "/caps", Help: "Show what the backend supports",
+			Run: func(env *commandEnv, args string) { displayCapabilities(env.config) },
+		},
+		{
+			Name: "/ver", Help: "Show the app version",
+			Run: func(env *commandEnv, args string) { displayVersion() },
+		},
+		{
+			Name: "/tts", Args: "[on | off | voice {name} [rate] | voice clear]", Help: "Read replies aloud",
+			Run: func(env *commandEnv, args string) { handleTTSCommand(args, env.config) },
+			Complete: func(args []string) []string {
+				if len(args) == 0 {
+					return []string{"on", "off", "voice"}
+				}
+				return nil
+			},
+		},
+		{
+			Name: "/attach", Args: "[file | remove {name}]", Help: "Attach a text or PDF document for the chat to draw on",
+			Run: func(env *commandEnv, args string) { handleAttachCommand(env.client, env.config, args) },
+		},
+		{
+			Name: "/img", Args: "{file} [message]", Help: "Send an image with a message, for vision models",
+			Run: func(env *commandEnv, args string) { handleImgCommand(args) },
+		},
+		{
+			Name: "/mood", Args: "[mood | clear]", Help: "Show or set the character's mood",
+			Run:      func(env *commandEnv, args string) { handleMoodCommand(args) },
+			Complete: func(args []string) []string { return atFirst(args, append(moods, "clear")) },
+		},
+		{
+			Name: "/relationship", Args: "[set {score}]", Help: "Show or set how the character feels about you",
+			Run: func(env *commandEnv, args string) { handleRelationshipCommand(args, env.config) },
+		},
+		{
+			Name: "/sheet", Help: "Show the game state: stats, inventory, flags and details",
+			Run: func(env *commandEnv, args string) { displayGameState() },
+		},
+		{
+			Name: "/set", Args: "{key} {value}", Help: "Change the game state, e.g. /set hp 12 or /set inventory +torch",
+			Run:      func(env *commandEnv, args string) { handleSetCommand(args) },
+			Complete: func(args []string) []string { return atFirst(args, stateKeys()) },
+		},
+		{
+			Name: "/time", Args: "[skip {span} | set {time}]", Help: "Show or move the time in the story",
+			Run:      func(env *commandEnv, args string) { handleTimeCommand(args, env.config) },
+			Complete: func(args []string) []string { return atFirst(args, []string{"skip", "set"}) },
+		},
+		{
+			Name: "/event", Args: "[table | list]", Help: "Bring a random twist into the next reply",
+			Run: func(env *commandEnv, args string) { handleEventCommand(args, env.config) },
+		},
+		{
+			Name: "/roll", Args: "{dice} [reason]", Help: "Roll dice, e.g. 2d6+3, and add the result to the chat",
+			Run: func(env *commandEnv, args string) { handleRollCommand(args) },
+		},
+		{
+			Name: "/tools", Help: "List the tools the model can call",
+			Run: func(env *commandEnv, args string) { displayTools(env.config) },
+		},
+		{
+			Name: "/mic", Help: "Say your next message instead of typing it",
+			Run: func(env *commandEnv, args string) { handleMicCommand(env.client, env.config) },
+		},
+		{
+			Name: "/doctor", Help: "Check the config, backend, model, data directory and terminal",
+			Run: func(env *commandEnv, args string) { handleDoctorCommand(env.client, env.config) },
+		},
+		{
+			Name: "/changelog", Help: "Show what changed in this version and any newer ones",
+			Run: func(env *commandEnv, args string) { handleChangelogCommand(env.client) },
+		},
+		{
+			Name: "/quick", Args: "[fav | unfav] [character]", Help: "Jump to a favorite character or a recent session",
+			Run: func(env *commandEnv, args string) { handleQuickCommand(args, env.config) },
+			Complete: func(args []string) []string {
+				if len(args) == 0 {
+					return []string{"fav", "unfav"}
+				}
+				if len(args) == 1 {
+					names, _ := listCharacters()
+					return names
+				}
+				return nil
+			},
+		},
+		{
+			Name: "/char", Args: "[list | browse | load | save | import | get | export | avatar | instructions | clear] ...", Help: "Manage characters",
+			Run: func(env *commandEnv, args string) { handleCharCommand(args, env.client, env.config) },
+			Complete: func(args []string) []string {
+				if len(args) == 0 {
+					return []string{"list", "browse", "load", "save", "import", "get", "export", "avatar", "instructions", "clear"}
+				}
+				if len(args) == 1 && (args[0] == "load" || args[0] == "save" || args[0] == "export") {
+					names, _ := listCharacters()
+					return names
+				}
+				return nil
+			},
+		},
+		{
+			Name: "/lore", Args: "[list | import | export | on | off | show] ...", Help: "Manage lorebooks (SillyTavern world info)",
+			Run: func(env *commandEnv, args string) { handleLoreCommand(args, env.config) },
+			Complete: func(args []string) []string {
+				if len(args) == 0 {
+					return []string{"list", "import", "export", "on", "off", "show"}
+				}
+				if len(args) == 1 && args[0] != "import" {
+					names, _ := listLorebooks()
+					return names
+				}
+				return nil
+			},
+		},
+		{
+			Name: "/spectate", Args: "[stop]", Help: "Share a read-only live view of the chat",
+			Run:      func(env *commandEnv, args string) { handleSpectateCommand(args, env.config) },
+			Complete: func(args []string) []string { return atFirst(args, []string{"stop"}) },
+		},
+		{
+			Name: "/checkpoint", Args: "{name}", Help: "Mark the current point in the chat",
+			Run: func(env *commandEnv, args string) { createCheckpoint(args) },
+		},
+		{
+			Name: "/branches", Args: "[name]", Help: "List branches, or switch to one",
+			Run:      func(env *commandEnv, args string) { handleBranchesCommand(args) },
+			Complete: func(args []string) []string { return atFirst(args, mapKeys(branches)) },
+		},
+		{
+			Name: "/branch", Args: "{checkpoint}", Help: "Start a new branch from a checkpoint",
+			Run:      func(env *commandEnv, args string) { createBranch(args) },
+			Complete: func(args []string) []string { return atFirst(args, mapKeys(checkpoints)) },
+		},
+		{
+			Name: "/bookmark", Args: "\"name\"", Help: "Bookmark the latest message",
+			Run: func(env *commandEnv, args string) { handleBookmarkCommand(args) },
+		},
+		{
+			Name: "/bookmarks", Help: "List the bookmarks in the chat",
+			Run: func(env *commandEnv, args string) { handleBookmarksCommand() },
+		},
+		{
+			Name: "/jump", Args: "{bookmark} [branch]", Help: "Show the chat at a bookmark, or branch from it",
+			Run:      func(env *commandEnv, args string) { handleJumpCommand(args) },
+			Complete: func(args []string) []string { return atFirst(args, bookmarkNames()) },
+		},
+		{
+			Name: "/rewind", Args: "{count}", Help: "Remove the last messages to retry the story from there",
+			Run: func(env *commandEnv, args string) { rewind(args) },
+		},
+		{
+			Name: "/note", Args: "[set \"text\" --depth N | clear]", Help: "Show or set the author's note",
+			Run:      func(env *commandEnv, args string) { handleNoteCommand(args) },
+			Complete: func(args []string) []string { return atFirst(args, []string{"set", "clear"}) },
+		},
+		{
+			Name: "/rewrite", Args: "from \"phrase\"", Help: "Regenerate the last reply from a phrase onward",
+			Run: func(env *commandEnv, args string) { rewriteReply(args, env.client, env.config, env.debug) },
+		},
+		{
+			Name: "/restyle", Args: "{instruction}", Help: "Rewrite the last reply, e.g. /restyle more concise",
+			Run: func(env *commandEnv, args string) { restyleReply(args, env.client, env.config, env.debug) },
+		},
+		{
+			Name: "/continue", Help: "Have the last reply carry on where it stopped",
+			Run: func(env *commandEnv, args string) { continueReply(env.client, env.config, env.debug) },
+		},
+		{
+			Name: "/regen", Help: "Generate another version of the last reply",
+			Run: func(env *commandEnv, args string) { regenerateReply(env.client, env.config, env.debug) },
+		},
+		{
+			Name: "/seed", Args: "[number | random]", Help: "Show or set the sampling seed",
+			Run:      func(env *commandEnv, args string) { handleSeedCommand(args, env.config) },
+			Complete: func(args []string) []string { return atFirst(args, []string{"random"}) },
+		},
+		{
+			Name: "/auto", Args: "{turns} {character}", Help: "Watch the character talk with another one for a number of turns",
+			Run: func(env *commandEnv, args string) { handleAutoCommand(args, env.client, env.config, env.debug) },
+			Complete: func(args []string) []string {
+				if len(args) == 1 {
+					names, _ := listCharacters()
+					return names
+				}
+				return nil
+			},
+		},
+		{
+			Name: "/greet", Args: "[number]", Help: "List the character's greetings, or open the chat with another one",
+			Run: func(env *commandEnv, args string) { handleGreetCommand(args, env.config) },
+		},
+		{
+			Name: "/override", Args: "[model | option | persona | clear] ...", Help: "Change the model, options or your persona for this chat only",
+			Run:      func(env *commandEnv, args string) { handleOverrideCommand(args, env.config) },
+			Complete: func(args []string) []string { return atFirst(args, []string{"model", "option", "persona", "clear"}) },
+		},
+		{
+			Name: "/scenario", Args: "[set \"...\" | clear | save]", Help: "Show or change the setting for this chat",
+			Run:      func(env *commandEnv, args string) { handleScenarioCommand(args) },
+			Complete: func(args []string) []string { return atFirst(args, []string{"set", "clear", "save"}) },
+		},
+		{
+			Name: "/mode", Args: "[narrator | dialogue]", Help: "Have replies narrate the story as prose, or speak as the character",
+			Run:      func(env *commandEnv, args string) { handleModeCommand(args) },
+			Complete: func(args []string) []string { return atFirst(args, []string{"narrator", "dialogue"}) },
+		},
+		{
+			Name: "/gm", Args: "[on | off | state]", Help: "Game master mode with structured replies and game state",
+			Run:      func(env *commandEnv, args string) { handleGMCommand(args) },
+			Complete: func(args []string) []string { return atFirst(args, []string{"on", "off", "state"}) },
+		},
+		{
+			Name: "/raw", Args: "[on | off]", Help: "Talk to the model out of character",
+			Run:      func(env *commandEnv, args string) { handleRawCommand(args) },
+			Complete: func(args []string) []string { return atFirst(args, []string{"on", "off"}) },
+		},
+		{
+			Name: "/imagine", Args: "[description]", Help: "Illustrate the current scene, or the one described",
+			Run: func(env *commandEnv, args string) { handleImagineCommand(env.client, env.config, args) },
+		},
+		{
+			Name: "/gallery", Args: "[open | show] [number]", Help: "Browse this session's illustrations",
+			Run:      func(env *commandEnv, args string) { handleGalleryCommand(args, env.config) },
+			Complete: func(args []string) []string { return atFirst(args, []string{"open", "show"}) },
+		},
+		{
+			Name: "/export", Args: "{html | epub} [file]", Help: "Export the chat with its illustrations as a web page or e-book",
+			Run:      func(env *commandEnv, args string) { handleExportCommand(args, env.config) },
+			Complete: func(args []string) []string { return atFirst(args, []string{"html", "epub"}) },
+		},
+		{
+			Name: "/usage", Help: "Show token usage and cost for this chat and this month",
+			Run: func(env *commandEnv, args string) { handleUsageCommand(env.config) },
+		},
+		{
+			Name: "/stats", Help: "Show message counts, lengths and the most used words",
+			Run: func(env *commandEnv, args string) { handleStatsCommand() },
+		},
+		{
+			Name: "/analyze", Args: "[html [file]]", Help: "Chart reply lengths, timings and mood to spot slow stretches",
+			Run:      func(env *commandEnv, args string) { handleAnalyzeCommand(args, env.config) },
+			Complete: func(args []string) []string { return atFirst(args, []string{"html"}) },
+		},
+		{
+			Name: "/context", Args: "[strategy]", Help: "Show how full the context is, or how this chat makes room when it's full",
+			Run:      func(env *commandEnv, args string) { handleContextCommand(args, env.config) },
+			Complete: func(args []string) []string { return atFirst(args, contextStrategyNames()) },
+		},
+		{
+			Name: "/summary", Args: "[show | clear]", Help: "Recap the chat so far, and keep it for when older messages are trimmed",
+			Run:      func(env *commandEnv, args string) { handleSummaryCommand(args, env.client, env.config, env.debug) },
+			Complete: func(args []string) []string { return atFirst(args, []string{"show", "clear"}) },
+		},
+		{
+			Name: "/copy", Args: "[number]", Help: "Copy the last reply, or a message numbered as in /hist, to the clipboard",
+			Run: func(env *commandEnv, args string) { handleCopyCommand(args) },
+		},
+		{
+			Name: "/reply", Args: "{number} {message}", Help: "Reply to an earlier message, quoting it, numbered as in /hist",
+			Run: func(env *commandEnv, args string) { handleReplyCommand(args) },
+		},
+		{
+			Name: "/impersonate", Args: "[hint]", Help: "Have the model draft your next message, to send, edit or discard",
+			Run: func(env *commandEnv, args string) { handleImpersonateCommand(args, env.client, env.config, env.debug) },
+		},
+		{
+			Name: "/remember", Args: "{fact}", Help: "Keep a fact in every prompt, e.g. /remember The user's sister is named Kira",
+			Run: func(env *commandEnv, args string) { handleRememberCommand(args) },
+		},
+		{
+			Name: "/memories", Help: "List the remembered facts",
+			Run: func(env *commandEnv, args string) { handleMemoriesCommand() },
+		},
+		{
+			Name: "/forget", Args: "{number}", Help: "Forget a remembered fact",
+			Run: func(env *commandEnv, args string) { handleForgetCommand(args) },
+		},
+		{
+			Name: "/pin", Args: "{number}", Help: "Keep a message in the context however long the chat gets",
+			Run: func(env *commandEnv, args string) { handlePinCommand(args, true) },
+		},
+		{
+			Name: "/unpin", Args: "{number}", Help: "Let a pinned message be trimmed again",
+			Run: func(env *commandEnv, args string) { handlePinCommand(args, false) },
+		},
+		{
+			Name: "/pins", Help: "List the pinned messages",
+			Run: func(env *commandEnv, args string) { handlePinsCommand() },
+		},
+		{
+			Name: "/prompt", Args: "[--raw]", Help: "Show the exact prompt the next message will be sent with",
+			Run:      func(env *commandEnv, args string) { handlePromptCommand(args, env.config) },
+			Complete: func(args []string) []string { return atFirst(args, []string{"--raw"}) },
+		},
+		{
+			Name: "/hist", Args: "[user | assistant] [last N | N-M]", Help: "Show the chat history",
+			Run:      func(env *commandEnv, args string) { showHistory(args) },
+			Complete: func(args []string) []string { return atFirst(args, []string{"user", "assistant"}) },
+		},
+	}
+}
+
+func findCommand(name string) *command {
+	for i := range commands {
+		if commands[i].Name == name {
+			return &commands[i]
+		}
+	}
+	return nil
+}
+
+func commandNames() []string {
+	names := make([]string, len(commands))
+	for i, cmd := range commands {
+		names[i] = cmd.Name
+	}
+	sort.Strings(names)
+	return names
+}
+
+// runCommand runs input if it is a slash command, reporting whether it was.
+func runCommand(input string, env *commandEnv) bool {
+	if !strings.HasPrefix(input, "/") {
+		return false
+	}
+	name := strings.Fields(input)[0]
+	cmd := findCommand(name)
+	if cmd == nil {
+		fmt.Printf("Unknown command '%s'. Type /help for a list of commands.\n", name)
+		return true
+	}
+	cmd.Run(env, strings.TrimSpace(strings.TrimPrefix(input, name)))
+	return true
+}
+
+func displayHelp(name string) {
+	if name != "" {
+		if !strings.HasPrefix(name, "/") {
+			name = "/" + name
+		}
+		cmd := findCommand(name)
+		if cmd == nil {
+			fmt.Printf("Unknown command '%s'.\n", name)
+			return
+		}
+		fmt.Printf("\n%s %s\n  %s\n", cmd.Name, cmd.Args, cmd.Help)
+		return
+	}
+
+	fmt.Println("\n[Commands]:")
+	for _, name := range commandNames() {
+		cmd := findCommand(name)
+		fmt.Printf("%-40s %s\n", strings.TrimSpace(cmd.Name+" "+cmd.Args), cmd.Help)
+	}
+	fmt.Printf("%-40s %s\n", "exit, quit", "Save the session and leave")
+	fmt.Printf("%-40s %s\n", MultilineDelimiter+"...", "Write a message over several lines, closing with "+MultilineDelimiter)
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+)
+
+const (
+	CompanionInboxFile = "companion_inbox.json"
+
+	DefaultCompanionInterval = 120
+	DefaultCompanionPrompt   = "The user is away. Send them a short, spontaneous message in character to check in on them, like a text from a friend. Keep it to one or two sentences."
+)
+
+// CompanionConfig controls the background companion mode, where the active
+// character sends occasional desktop notifications on its own.
+type CompanionConfig struct {
+	IntervalMinutes int    `json:"interval_minutes,omitempty"`
+	Prompt          string `json:"prompt,omitempty"`
+	// QuietHours is a range of hours such as "22-8" with no notifications.
+	QuietHours string `json:"quiet_hours,omitempty"`
+	// OpenCommand runs when a notification is clicked, e.g.
+	// "x-terminal-emulator -e char.chat". Only Linux notifications can be
+	// clicked. The messages wait in the inbox for the next chat with the
+	// character rather than in any one session, so the command just needs
+	// to start a chat.
+	OpenCommand string `json:"open_command,omitempty"`
+}
+
+type companionMessage struct {
+	Time      time.Time `json:"time"`
+	Character string    `json:"character"`
+	Content   string    `json:"content"`
+}
+
+func companionInboxPath() string {
+	return filepath.Join(getConfigDir(), CompanionInboxFile)
+}
+
+func loadCompanionInbox() []companionMessage {
+	var inbox []companionMessage
+	if data, err := ioutil.ReadFile(companionInboxPath()); err == nil {
+		_ = json.Unmarshal(data, &inbox)
+	}
+	return inbox
+}
+
+func saveCompanionInbox(inbox []companionMessage) {
+	data, _ := json.MarshalIndent(inbox, "", "  ")
+	if err := ioutil.WriteFile(companionInboxPath(), data, 0644); err != nil {
+		printError("Error saving companion inbox:", err)
+	}
+}
+
+// runCompanion sends a proactive message on every interval until killed.
+func runCompanion(client *http.Client, config *Config, debug bool) {
+	companion := CompanionConfig{}
+	if config.Companion != nil {
+		companion = *config.Companion
+	}
+	interval := companion.IntervalMinutes
+	if interval <= 0 {
+		interval = DefaultCompanionInterval
+	}
+	prompt := companion.Prompt
+	if prompt == "" {
+		prompt = DefaultCompanionPrompt
+	}
+
+	activeCharacter = loadActiveCharacter(*config)
+	name := characterDisplayName(activeCharacter)
+	fmt.Printf("Companion mode: %s will check in every %d minutes. Press Ctrl-C to stop.\n", name, interval)
+	if companion.OpenCommand != "" && runtime.GOOS != "linux" {
+		fmt.Println("Warning: open_command is only supported on Linux; clicking a notification won't open the chat.")
+	}
+
+	for {
+		time.Sleep(time.Duration(interval) * time.Minute)
+		if inQuietHours(companion.QuietHours, time.Now()) {
+			continue
+		}
+
+		inbox := loadCompanionInbox()
+		history := []Message{{Role: "assistant", Content: activeCharacter.Greeting}}
+		for _, msg := range inbox {
+			history = append(history, Message{Role: "assistant", Content: msg.Content})
+		}
+		messages := append(buildPrompt(config, history), Message{Role: "system", Content: prompt})
+
+		content, err := requestReply(client, config, messages, debug)
+		if err != nil {
+			printError("Request error:", err)
+			continue
+		}
+
+		saveCompanionInbox(append(inbox, companionMessage{Time: time.Now(), Character: activeCharacter.Name, Content: content}))
+		go notify(name, content, companion.OpenCommand)
+	}
+}
+
+// deliverCompanionInbox adds messages the companion sent while the chat was
+// closed to the start of the session.
+func deliverCompanionInbox() {
+	inbox := loadCompanionInbox()
+	if len(inbox) == 0 {
+		return
+	}
+	var remaining []companionMessage
+	for _, msg := range inbox {
+		if msg.Character != activeCharacter.Name {
+			remaining = append(remaining, msg)
+			continue
+		}
+		fmt.Printf("\n[%s]: %s\n", msg.Time.Format("Jan 2 15:04"), msg.Content)
+		appendMessage("assistant", msg.Content)
+	}
+	saveCompanionInbox(remaining)
+}
+
+// inQuietHours reports whether now falls in a range like "22-8".
+func inQuietHours(quietHours string, now time.Time) bool {
+	var start, end int
+	if _, err := fmt.Sscanf(quietHours, "%d-%d", &start, &end); err != nil {
+		return false
+	}
+	hour := now.Hour()
+	if start <= end {
+		return hour >= start && hour < end
+	}
+	return hour >= start || hour < end
+}
+
+// notify shows a desktop notification. Only on Linux can it be clicked,
+// running openCommand if one is configured; macOS and Windows notifications
+// just show the message.
+func notify(title, body, openCommand string) {
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "linux":
+		if openCommand == "" {
+			cmd = exec.Command("notify-send", "--app-name=Character.Chat", title, body)
+			break
+		}
+		out, err := exec.Command("notify-send", "--app-name=Character.Chat", "--action=open=Open Chat", "--wait", title, body).Output()
+		if err != nil {
+			printError("Error sending notification:", err)
+			return
+		}
+		if strings.TrimSpace(string(out)) == "open" {
+			if err := exec.Command("sh", "-c", openCommand).Start(); err != nil {
+				printError("Error opening chat:", err)
+			}
+		}
+		return
+	case "darwin":
+		script := fmt.Sprintf("display notification %q with title %q", body, title)
+		cmd = exec.Command("osascript", "-e", script)
+	case "windows":
+		script := fmt.Sprintf(`Add-Type -AssemblyName System.Windows.Forms
+$n = New-Object System.Windows.Forms.NotifyIcon
+$n.Icon = [System.Drawing.SystemIcons]::Information
+$n.Visible = $true
+$n.ShowBalloonTip(10000, '%s', '%s', 'None')
+Start-Sleep -Seconds 10
+$n.Dispose()`, strings.ReplaceAll(title, "'", "''"), strings.ReplaceAll(body, "'", "''"))
+		cmd = exec.Command("powershell", "-NoProfile", "-Command", script)
+	default:
+		fmt.Printf("[%s]: %s\n", title, body)
+		return
+	}
+	if err := cmd.Run(); err != nil {
+		printError("Error sending notification:", err)
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"sort"
+	"strings"
+)
+
+// completeInput returns the word being typed at the end of before and the
+// words it could be completed to.
+func completeInput(before string) (string, []string) {
+	fields := strings.Fields(before)
+	if strings.HasSuffix(before, " ") || len(fields) == 0 {
+		fields = append(fields, "")
+	}
+	word := fields[len(fields)-1]
+
+	var options []string
+	if len(fields) == 1 {
+		if !strings.HasPrefix(word, "/") {
+			return word, nil
+		}
+		options = commandNames()
+	} else if cmd := findCommand(fields[0]); cmd != nil && cmd.Complete != nil {
+		options = cmd.Complete(fields[1 : len(fields)-1])
+	}
+
+	var candidates []string
+	for _, option := range options {
+		if strings.HasPrefix(option, word) {
+			candidates = append(candidates, option)
+		}
+	}
+	return word, candidates
+}
+
+// atFirst offers options for the first argument only.
+func atFirst(args []string, options []string) []string {
+	if len(args) > 0 {
+		return nil
+	}
+	return options
+}
+
+func sessionNames() []string {
+	sessions, _ := listSessions()
+	names := make([]string, len(sessions))
+	for i, session := range sessions {
+		names[i] = session.Name
+	}
+	sort.Strings(names)
+	return names
+}
+
+func mapKeys(m map[string][]Message) []string {
+	names := make([]string, 0, len(m))
+	for name := range m {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,310 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const (
+	// DefaultContextSize matches Ollama's default num_ctx.
+	DefaultContextSize = 2048
+
+	// replyReserve is kept free in the context window for the reply itself.
+	replyReserve = 256
+
+	// keepRecent is how many of the latest messages a summary leaves intact.
+	keepRecent = 6
+
+	// maxDroppedListed is how many messages falling out of the context are
+	// listed before summing up the rest.
+	maxDroppedListed = 8
+)
+
+var errContextOverflow = errors.New("the conversation no longer fits in the model's context window")
+
+// estimateTokens is a rough count good enough for budgeting: about four
+// characters per token for English text.
+func estimateTokens(text string) int {
+	return (len([]rune(text)) + 3) / 4
+}
+
+func estimatePromptTokens(messages []Message) int {
+	total := 0
+	for _, msg := range messages {
+		total += estimateTokens(msg.Content) + 4
+	}
+	return total
+}
+
+// contextLimit returns the context window size, taken from num_ctx in the
+// backend options when set to a positive number.
+func contextLimit(config *Config) int {
+	limit := 0
+	switch n := config.Options["num_ctx"].(type) {
+	case float64:
+		limit = int(n)
+	case int:
+		limit = n
+	}
+	if limit <= 0 {
+		return DefaultContextSize
+	}
+	return limit
+}
+
+func isContextOverflowMessage(message string) bool {
+	message = strings.ToLower(message)
+	for _, hint := range []string{"context length", "context window", "maximum context", "context_length_exceeded", "prompt is too long"} {
+		if strings.Contains(message, hint) {
+			return true
+		}
+	}
+	return false
+}
+
+// ensureContextFits checks the prompt for the current history against the
+// context window and, if it is too big, walks the user through fixing it.
+// It returns false if the user chose not to send the message.
+func ensureContextFits(client *http.Client, config *Config, debug bool) bool {
+	if estimatePromptTokens(buildPrompt(config, messageHistory)) <= contextLimit(config)-replyReserve {
+		return true
+	}
+	return contextManagers[contextStrategy(config)].Fit(client, config, debug)
+}
+
+// droppedMessages returns how many of the oldest messages in the history
+// won't fit in the context window. Backends keep the system prompt and
+// cut the chat from the start, so those are the ones the model won't see.
+func droppedMessages(config *Config) int {
+	over := estimatePromptTokens(buildPrompt(config, messageHistory)) - (contextLimit(config) - replyReserve)
+	dropped := 0
+	// The latest message is always sent.
+	for dropped < len(messageHistory)-1 && over > 0 {
+		over -= estimateTokens(messageHistory[dropped].Content) + 4
+		dropped++
+	}
+	return dropped
+}
+
+// warnTruncation lists the messages that will fall out of the context
+// when the prompt is sent over the limit anyway.
+func warnTruncation(config *Config) {
+	dropped := droppedMessages(config)
+	if dropped == 0 {
+		return
+	}
+	notice("\n[Context]: The prompt is over the context window, so the model won't see the oldest %d message(s):\n", dropped)
+	listMessages(messageHistory[:dropped])
+}
+
+// listMessages prints a line from each of the first few messages, for
+// saying which ones fall out of the context.
+func listMessages(messages []Message) {
+	for i, msg := range messages[:min(len(messages), maxDroppedListed)] {
+		fmt.Printf("  %d. %s: %s\n", i+1, strings.Title(msg.Role), truncateText(msg.Content, 60))
+	}
+	if len(messages) > maxDroppedListed {
+		fmt.Printf("  ... and %d more.\n", len(messages)-maxDroppedListed)
+	}
+}
+
+// displayContextMeter prints how much of the context window the chat
+// takes up, e.g. [Context] 3,200/8,192 tokens (39%).
+func displayContextMeter(config *Config) {
+	used, limit := estimatePromptTokens(buildPrompt(config, messageHistory)), contextLimit(config)
+	line := fmt.Sprintf("[Context] %s/%s tokens (%d%%)", formatThousands(used), formatThousands(limit), used*100/limit)
+	if used > limit-replyReserve {
+		line += ", full"
+	}
+	notice("%s\n", line)
+}
+
+// formatThousands writes n with commas between groups of three digits.
+func formatThousands(n int) string {
+	if n < 0 {
+		return "-" + formatThousands(-n)
+	}
+	s := strconv.Itoa(n)
+	for i := len(s) - 3; i > 0; i -= 3 {
+		s = s[:i] + "," + s[i:]
+	}
+	return s
+}
+
+func offerContextFixes(client *http.Client, config *Config, debug bool) bool {
+	for {
+		used, limit := estimatePromptTokens(buildPrompt(config, messageHistory)), contextLimit(config)
+		notice("\n[Context]: The prompt is about %d tokens, but the context window is %d.\n", used, limit)
+		fmt.Println("The model would lose track of the conversation. How do you want to fix it?")
+		fmt.Println("  1. Summarize older messages now")
+		fmt.Println("  2. Trim the oldest chapter")
+		fmt.Println("  3. Drop the example dialogue")
+		fmt.Println("  4. Send anyway")
+		fmt.Println("  5. Cancel this message")
+
+		switch promptUserForInput("Choose an option", "1") {
+		case "1":
+			summarizeOlderMessages(client, config, debug)
+		case "2":
+			trimOldestChapter()
+		case "3":
+			dropExamples(config)
+		case "4":
+			return true
+		case "5":
+			return false
+		default:
+			fmt.Println("Invalid option.")
+			continue
+		}
+
+		if estimatePromptTokens(buildPrompt(config, messageHistory)) <= limit-replyReserve {
+			fmt.Println("The conversation fits in the context window again.")
+			return true
+		}
+	}
+}
+
+// summarizeOlderMessages replaces everything but the latest messages with a
+// summary written by the model.
+func summarizeOlderMessages(client *http.Client, config *Config, debug bool) {
+	if len(messageHistory) <= keepRecent {
+		fmt.Println("There are no older messages left to summarize.")
+		return
+	}
+	older, recent := messageHistory[:len(messageHistory)-keepRecent], messageHistory[len(messageHistory)-keepRecent:]
+	pinned, older := splitPinned(older)
+
+	summary, err := summarize(client, config, older, debug)
+	if err != nil {
+		printError("Error summarizing conversation:", err)
+		return
+	}
+
+	chatSummary = summary
+	setHistory(withSummary(append(pinned, copyHistory(recent)...)))
+	fmt.Printf("Summarized %d older messages.\n", len(older))
+}
+
+// trimOldestChapter drops the oldest quarter of the history, always leaving
+// the latest exchange and pinned messages in place.
+func trimOldestChapter() {
+	n := len(messageHistory)
+	count := n / 4
+	if count < 2 {
+		count = 2
+	}
+	if count > n-2 {
+		count = n - 2
+	}
+	if count <= 0 {
+		fmt.Println("There is nothing left to trim.")
+		return
+	}
+	pinned, dropped := splitPinned(messageHistory[:count])
+	if len(dropped) == 0 {
+		fmt.Println("The oldest messages are all pinned. Unpin some with /unpin")
+		return
+	}
+	listMessages(dropped)
+	setHistory(withSummary(append(pinned, copyHistory(messageHistory[count:])...)))
+	fmt.Printf("Trimmed the oldest %d messages.\n", len(dropped))
+}
+
+// maxInputShare is the largest part of the context window one message may
+// take before the user is asked what to do with it.
+const maxInputShare = 3
+
+// pendingInputs are sent as the next turns: the remaining parts of a split
+// message, or a transcript from /mic.
+var pendingInputs []string
+
+// guardInputLength checks that input leaves room for the rest of the
+// conversation, offering to split or condense it if not. It returns the
+// message to send now, or false if the user cancelled.
+func guardInputLength(client *http.Client, config *Config, input string, debug bool) (string, bool) {
+	tokens, limit := estimateTokens(input), contextLimit(config)
+	maxTokens := limit / maxInputShare
+	if tokens <= maxTokens {
+		return input, true
+	}
+
+	for {
+		notice("\n[Context]: This message is about %d tokens, more than a third of the %d-token context window.\n", tokens, limit)
+		fmt.Println("The reply would suffer, and older messages would fall out of the context. What do you want to do?")
+		fmt.Println("  1. Split it across several turns")
+		fmt.Println("  2. Condense it first")
+		fmt.Println("  3. Send anyway")
+		fmt.Println("  4. Cancel this message")
+
+		switch promptUserForInput("Choose an option", "1") {
+		case "1":
+			// Leave room for the part numbers.
+			parts := splitInput(input, maxTokens-8)
+			for i := range parts {
+				parts[i] = fmt.Sprintf("(Part %d of %d) %s", i+1, len(parts), parts[i])
+			}
+			fmt.Printf("Sending the message in %d parts, one per turn.\n", len(parts))
+			pendingInputs = append(pendingInputs, parts[1:]...)
+			return parts[0], true
+		case "2":
+			condensed, err := requestReply(client, config, []Message{
+				{Role: "system", Content: "Condense the following roleplay message to a third of its length. Keep the same voice and point of view, and every action, line of dialogue and detail that matters to the story. Respond with only the condensed message."},
+				{Role: "user", Content: input},
+			}, debug)
+			if err != nil {
+				printError("Error condensing message:", err)
+				continue
+			}
+			fmt.Printf("\n[Condensed] (about %d tokens): %s\n", estimateTokens(condensed), condensed)
+			return condensed, true
+		case "3":
+			return input, true
+		case "4":
+			return "", false
+		default:
+			fmt.Println("Invalid option.")
+		}
+	}
+}
+
+// splitInput splits text into parts of at most maxTokens, between
+// paragraphs where possible and between words otherwise.
+func splitInput(text string, maxTokens int) []string {
+	var parts []string
+	var current []string
+	flush := func() {
+		if len(current) > 0 {
+			parts = append(parts, strings.Join(current, "\n\n"))
+			current = nil
+		}
+	}
+	for _, paragraph := range strings.Split(text, "\n\n") {
+		if paragraph = strings.TrimSpace(paragraph); paragraph == "" {
+			continue
+		}
+		if estimateTokens(strings.Join(append(current, paragraph), "\n\n")) <= maxTokens {
+			current = append(current, paragraph)
+			continue
+		}
+		flush()
+		if estimateTokens(paragraph) <= maxTokens {
+			current = []string{paragraph}
+			continue
+		}
+		var words []string
+		for _, word := range strings.Fields(paragraph) {
+			if len(words) > 0 && estimateTokens(strings.Join(append(words, word), " ")) > maxTokens {
+				parts = append(parts, strings.Join(words, " "))
+				words = nil
+			}
+			words = append(words, word)
+		}
+		current = []string{strings.Join(words, " ")}
+	}
+	flush()
+	return parts
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// DefaultContextStrategy asks the user what to do each time the chat
+// outgrows the context window.
+const DefaultContextStrategy = "ask"
+
+// ContextManager makes room when the prompt is too big for the context
+// window. Fit shortens the history and reports whether the message should
+// still be sent.
+type ContextManager interface {
+	Fit(client *http.Client, config *Config, debug bool) bool
+}
+
+// contextManagers are the strategies "context_strategy" in the config and
+// /context can choose from.
+var contextManagers = map[string]ContextManager{
+	"ask":                askManager{},
+	"truncate-oldest":    truncateManager{},
+	"summarize":          summarizeManager{},
+	"keep-pinned+recent": pinnedManager{},
+}
+
+// chatContextStrategy is the strategy /context set for this chat, saved
+// with the session. When empty the config's is used.
+var chatContextStrategy string
+
+func contextStrategy(config *Config) string {
+	if chatContextStrategy != "" {
+		return chatContextStrategy
+	}
+	if _, ok := contextManagers[config.ContextStrategy]; ok {
+		return config.ContextStrategy
+	}
+	return DefaultContextStrategy
+}
+
+func contextStrategyNames() []string {
+	names := make([]string, 0, len(contextManagers))
+	for name := range contextManagers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// askManager offers the fixes to choose from.
+type askManager struct{}
+
+func (askManager) Fit(client *http.Client, config *Config, debug bool) bool {
+	return offerContextFixes(client, config, debug)
+}
+
+// truncateManager drops the oldest messages, suited to casual chats where
+// only the last scene matters.
+type truncateManager struct{}
+
+func (truncateManager) Fit(client *http.Client, config *Config, debug bool) bool {
+	trimToFit(config)
+	return true
+}
+
+// summarizeManager folds older messages into a summary, for long stories
+// where what happened early on still counts.
+type summarizeManager struct{}
+
+func (summarizeManager) Fit(client *http.Client, config *Config, debug bool) bool {
+	notice("\n[Context]: The chat no longer fits in the context window. Summarizing older messages...\n")
+	summarizeOlderMessages(client, config, debug)
+	// A summary can only go so far; drop what still doesn't fit.
+	trimToFit(config)
+	return true
+}
+
+// pinnedManager keeps the pinned messages, the summary and the last
+// keepRecent messages and drops everything else at once, rather than only
+// as much as it takes to fit, so the chat settles on the pins and a fixed
+// recent stretch instead of shedding a message or two every turn.
+type pinnedManager struct{}
+
+func (pinnedManager) Fit(client *http.Client, config *Config, debug bool) bool {
+	if estimatePromptTokens(buildPrompt(config, messageHistory)) <= contextLimit(config)-replyReserve {
+		return true
+	}
+	history := withSummary(messageHistory)
+	var kept, dropped []Message
+	for i, msg := range history {
+		if i < len(history)-keepRecent && !msg.Pinned && !isSummary(msg) {
+			dropped = append(dropped, msg)
+			continue
+		}
+		kept = append(kept, msg)
+	}
+	if len(dropped) > 0 {
+		notice("\n[Context]: Kept the pinned and the last %d messages, dropping %d to fit the context window:\n", keepRecent, len(dropped))
+		listMessages(dropped)
+		setHistory(kept)
+	}
+	// Long pins or recent messages can still be too much.
+	trimToFit(config)
+	return true
+}
+
+// trimToFit drops the oldest messages other than pinned ones until the
+// prompt fits, and says which went. The latest message always stays, and
+// the chat's summary stands in for the rest.
+func trimToFit(config *Config) {
+	over := estimatePromptTokens(buildPrompt(config, messageHistory)) - (contextLimit(config) - replyReserve)
+	if over <= 0 {
+		return
+	}
+	history := withSummary(messageHistory)
+	over += estimatePromptTokens(history) - estimatePromptTokens(messageHistory)
+	var kept, dropped []Message
+	for i, msg := range history {
+		if over > 0 && i < len(history)-1 && !msg.Pinned && !isSummary(msg) {
+			over -= estimateTokens(msg.Content) + 4
+			dropped = append(dropped, msg)
+			continue
+		}
+		kept = append(kept, msg)
+	}
+	if len(dropped) == 0 {
+		return
+	}
+	notice("\n[Context]: Dropped the oldest %d message(s) to fit the context window:\n", len(dropped))
+	listMessages(dropped)
+	setHistory(kept)
+}
+
+// handleContextCommand handles /context [strategy].
+func handleContextCommand(args string, config *Config) {
+	const usage = "Usage: /context [%s]\n"
+	names := contextStrategyNames()
+	switch name := strings.TrimSpace(args); {
+	case name == "":
+		displayContextMeter(config)
+		fmt.Printf("Strategy when the chat outgrows it: %s\n", contextStrategy(config))
+		fmt.Printf(usage, strings.Join(names, " | "))
+	case contextManagers[name] != nil:
+		chatContextStrategy = name
+		fmt.Printf("This chat now uses the %s strategy when it outgrows the context window.\n", name)
+	default:
+		fmt.Printf(usage, strings.Join(names, " | "))
+	}
+}
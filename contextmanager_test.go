@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// contextTestHistory is n messages of about 100 tokens each, labelled m0,
+// m1 and so on, with those in pinned pinned.
+func contextTestHistory(n int, pinned ...int) []Message {
+	history := make([]Message, n)
+	for i := range history {
+		role := "user"
+		if i%2 == 1 {
+			role = "assistant"
+		}
+		label := fmt.Sprintf("m%d ", i)
+		history[i] = Message{Role: role, Content: label + strings.Repeat("x", 396-len(label))}
+	}
+	for _, i := range pinned {
+		history[i].Pinned = true
+	}
+	return history
+}
+
+func historyLabels(history []Message) []string {
+	labels := make([]string, len(history))
+	for i, msg := range history {
+		labels[i], _, _ = strings.Cut(msg.Content, " ")
+	}
+	return labels
+}
+
+func TestContextStrategies(t *testing.T) {
+	tests := []struct {
+		strategy string
+		numCtx   int
+		history  []Message
+		want     []string
+	}{
+		{"truncate-oldest", 4096, contextTestHistory(4), []string{"m0", "m1", "m2", "m3"}},
+		{"truncate-oldest", 1150, contextTestHistory(12), []string{"m4", "m5", "m6", "m7", "m8", "m9", "m10", "m11"}},
+		{"truncate-oldest", 1150, contextTestHistory(12, 1), []string{"m1", "m5", "m6", "m7", "m8", "m9", "m10", "m11"}},
+		{"keep-pinned+recent", 4096, contextTestHistory(12, 1), []string{"m0", "m1", "m2", "m3", "m4", "m5", "m6", "m7", "m8", "m9", "m10", "m11"}},
+		{"keep-pinned+recent", 1150, contextTestHistory(12), []string{"m6", "m7", "m8", "m9", "m10", "m11"}},
+		{"keep-pinned+recent", 1150, contextTestHistory(12, 1), []string{"m1", "m6", "m7", "m8", "m9", "m10", "m11"}},
+		// When the pins and the recent messages are too much, the oldest
+		// recent ones go too.
+		{"keep-pinned+recent", 700, contextTestHistory(12, 1), []string{"m1", "m9", "m10", "m11"}},
+	}
+	for _, tt := range tests {
+		config := &Config{Options: map[string]interface{}{"num_ctx": tt.numCtx}}
+		setHistory(tt.history)
+		if !contextManagers[tt.strategy].Fit(&http.Client{}, config, false) {
+			t.Errorf("%s with num_ctx %d: Fit() = false, want true", tt.strategy, tt.numCtx)
+		}
+		if got := historyLabels(messageHistory); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s with num_ctx %d: kept %v, want %v", tt.strategy, tt.numCtx, got, tt.want)
+		}
+	}
+	setHistory(nil)
+}
+
+func TestContextStrategy(t *testing.T) {
+	tests := []struct {
+		chat, config string
+		want         string
+	}{
+		{"", "", DefaultContextStrategy},
+		{"", "summarize", "summarize"},
+		{"", "forget-everything", DefaultContextStrategy},
+		{"truncate-oldest", "summarize", "truncate-oldest"},
+	}
+	for _, tt := range tests {
+		chatContextStrategy = tt.chat
+		if got := contextStrategy(&Config{ContextStrategy: tt.config}); got != tt.want {
+			t.Errorf("contextStrategy() with %q for the chat and %q in the config = %q, want %q", tt.chat, tt.config, got, tt.want)
+		}
+	}
+	chatContextStrategy = ""
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const (
+	maxDice  = 100
+	maxSides = 1000
+)
+
+// diceTerm matches one term of a roll: dice like 4d6, d20, d% or 4d6kh3
+// (keep the highest three), or a plain number.
+var diceTerm = regexp.MustCompile(`^(\d*)d(\d+|%)(?:(kh|kl)(\d+))?$|^(\d+)$`)
+
+// diceRoll is a rolled expression and how it came out.
+type diceRoll struct {
+	Expression string
+	Total      int
+	Detail     string
+}
+
+func (r diceRoll) String() string {
+	return fmt.Sprintf("%s = %d (%s)", r.Expression, r.Total, r.Detail)
+}
+
+// rollDice rolls an expression such as 2d6+3, d20-1 or 4d6kh3+2d4.
+func rollDice(expression string) (diceRoll, error) {
+	expr := strings.ToLower(strings.ReplaceAll(expression, " ", ""))
+	if expr == "" {
+		return diceRoll{}, fmt.Errorf("nothing to roll")
+	}
+	roll := diceRoll{Expression: expr}
+	var detail strings.Builder
+	sign := 1
+	for i := 0; i < len(expr); {
+		if expr[i] == '+' || expr[i] == '-' {
+			if i == 0 || expr[i-1] == '+' || expr[i-1] == '-' {
+				return diceRoll{}, fmt.Errorf("invalid roll %q", expression)
+			}
+			sign = 1
+			if expr[i] == '-' {
+				sign = -1
+			}
+			i++
+			continue
+		}
+		end := strings.IndexAny(expr[i:], "+-")
+		if end < 0 {
+			end = len(expr)
+		} else {
+			end += i
+		}
+		value, shown, err := rollTerm(expr[i:end])
+		if err != nil {
+			return diceRoll{}, err
+		}
+		roll.Total += sign * value
+		switch {
+		case sign < 0:
+			detail.WriteString(" - ")
+		case detail.Len() > 0:
+			detail.WriteString(" + ")
+		}
+		detail.WriteString(shown)
+		i = end
+	}
+	if strings.HasSuffix(expr, "+") || strings.HasSuffix(expr, "-") {
+		return diceRoll{}, fmt.Errorf("invalid roll %q", expression)
+	}
+	roll.Detail = strings.TrimPrefix(detail.String(), " ")
+	return roll, nil
+}
+
+// rollTerm rolls one term, returning its value and the dice shown.
+func rollTerm(term string) (int, string, error) {
+	m := diceTerm.FindStringSubmatch(term)
+	if m == nil {
+		return 0, "", fmt.Errorf("invalid dice %q; use something like 2d6+3", term)
+	}
+	if m[5] != "" {
+		n, _ := strconv.Atoi(m[5])
+		return n, m[5], nil
+	}
+	count := 1
+	if m[1] != "" {
+		count, _ = strconv.Atoi(m[1])
+	}
+	sides := 100
+	if m[2] != "%" {
+		sides, _ = strconv.Atoi(m[2])
+	}
+	if count < 1 || count > maxDice || sides < 2 || sides > maxSides {
+		return 0, "", fmt.Errorf("%q is out of range: up to %d dice of 2 to %d sides", term, maxDice, maxSides)
+	}
+	keep := count
+	if m[3] != "" {
+		keep, _ = strconv.Atoi(m[4])
+		if keep < 1 || keep > count {
+			return 0, "", fmt.Errorf("can't keep %d of %d dice", keep, count)
+		}
+	}
+
+	rolls := make([]int, count)
+	for i := range rolls {
+		rolls[i] = rand.Intn(sides) + 1
+	}
+	// Mark the dice that don't count, dropping the lowest for kh and the
+	// highest for kl.
+	dropped := make([]bool, count)
+	for n := 0; n < count-keep; n++ {
+		pick := -1
+		for i, r := range rolls {
+			if dropped[i] {
+				continue
+			}
+			if pick < 0 || m[3] == "kh" && r < rolls[pick] || m[3] == "kl" && r > rolls[pick] {
+				pick = i
+			}
+		}
+		dropped[pick] = true
+	}
+	total := 0
+	shown := make([]string, count)
+	for i, r := range rolls {
+		shown[i] = strconv.Itoa(r)
+		if dropped[i] {
+			shown[i] = "~" + shown[i] + "~"
+			continue
+		}
+		total += r
+	}
+	return total, "[" + strings.Join(shown, ", ") + "]", nil
+}
+
+// handleRollCommand handles /roll {dice} [reason], adding the result to
+// the chat so the story follows the real roll.
+func handleRollCommand(args string) {
+	fields := strings.Fields(args)
+	if len(fields) == 0 {
+		fmt.Println("Usage: /roll {dice} [reason], e.g. /roll 2d6+3, /roll d20+5 to hit, /roll 4d6kh3")
+		return
+	}
+	roll, err := rollDice(fields[0])
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	reason := strings.TrimSpace(strings.TrimPrefix(args, fields[0]))
+	line := "[Roll] " + roll.String()
+	if reason != "" {
+		line = "[Roll for " + reason + "] " + roll.String()
+	}
+	fmt.Println(line)
+	appendMessage("user", line)
+}
+
+// rollDiceTool lets the model ask for rolls instead of making them up.
+var rollDiceTool = tool{
+	definition: ToolFunction{
+		Name:        "roll_dice",
+		Description: "Roll dice for a check, attack, damage or any chance in the story, instead of deciding the outcome yourself. Accepts dice notation such as 1d20+5, 2d6+3 or 4d6kh3. Narrate the outcome from the total.",
+		Parameters: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"dice":   map[string]interface{}{"type": "string", "description": "Dice notation, e.g. 1d20+5"},
+				"reason": map[string]interface{}{"type": "string", "description": "What the roll is for"},
+			},
+			"required": []string{"dice"},
+		},
+	},
+	run: func(client *http.Client, config *Config, args map[string]interface{}) (string, error) {
+		roll, err := rollDice(stringArg(args, "dice"))
+		if err != nil {
+			return "", err
+		}
+		if reason := stringArg(args, "reason"); reason != "" {
+			notice("[Roll for %s]: %s\n", reason, roll)
+		} else {
+			notice("[Roll]: %s\n", roll)
+		}
+		return roll.String(), nil
+	},
+}
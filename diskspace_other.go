@@ -0,0 +1,8 @@
+//go:build !unix
+
+package main
+
+// freeSpace is not implemented on this platform.
+func freeSpace(dir string) (uint64, bool) {
+	return 0, false
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"golang.org/x/term"
+)
+
+// minFreeSpace is how much disk space /doctor wants left for sessions.
+const minFreeSpace = 100 << 20
+
+// doctorCheck is the outcome of one /doctor check. Fix is shown when it
+// failed or warned.
+type doctorCheck struct {
+	Name   string
+	Status string // "ok", "warn" or "fail"
+	Detail string
+	Fix    string
+}
+
+// handleDoctorCommand runs diagnostic checks and suggests fixes for what's
+// wrong.
+func handleDoctorCommand(client *http.Client, config *Config) {
+	var checks []doctorCheck
+	checks = append(checks, checkConfigFile()...)
+	checks = append(checks, checkBackend(client, config)...)
+	checks = append(checks, checkDataDir()...)
+	checks = append(checks, checkTerminal())
+
+	fmt.Println("\n[Doctor]:")
+	failed := 0
+	for _, c := range checks {
+		line := fmt.Sprintf("[%s] %s: %s", strings.ToUpper(c.Status), c.Name, c.Detail)
+		switch c.Status {
+		case "ok":
+			fmt.Println(line)
+		case "warn":
+			notice("%s\n", line)
+		default:
+			failed++
+			printError(line)
+		}
+		if c.Status != "ok" && c.Fix != "" {
+			fmt.Println("       Fix: " + c.Fix)
+		}
+	}
+	if failed == 0 {
+		fmt.Println("\nNo problems found.")
+	} else {
+		fmt.Printf("\n%d check(s) failed.\n", failed)
+	}
+}
+
+func checkConfigFile() []doctorCheck {
+	path := getConfigFilePath()
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return []doctorCheck{{"Config", "fail", err.Error(), "Delete " + path + " and restart to create a new one."}}
+	}
+	var config Config
+	if err := json.Unmarshal(data, &config); err != nil {
+		return []doctorCheck{{"Config", "fail", "invalid JSON: " + err.Error(), "Fix the syntax error in " + path + "."}}
+	}
+	checks := []doctorCheck{{"Config", "ok", path, ""}}
+
+	// Unknown keys are usually misspelled options, which are silently
+	// ignored otherwise.
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&Config{}); err != nil {
+		checks = append(checks, doctorCheck{"Config options", "warn", err.Error(), "Check the option's spelling; unknown options are ignored."})
+	}
+
+	if u, err := url.Parse(config.URL); err != nil || u.Scheme == "" || u.Host == "" {
+		checks = append(checks, doctorCheck{"Backend URL", "fail", fmt.Sprintf("%q is not a URL", config.URL), "Set it using: /config url"})
+	}
+	if config.Model == "" {
+		checks = append(checks, doctorCheck{"Model", "fail", "no model set", "Set one using: /config model"})
+	}
+	return checks
+}
+
+func checkBackend(client *http.Client, config *Config) []doctorCheck {
+	base := backendBaseURL(config.URL)
+	var version struct {
+		Version string `json:"version"`
+	}
+	if err := probeJSON(client, "GET", base+"/api/version", nil, &version); err != nil {
+		return []doctorCheck{{"Backend", "fail", err.Error(), "Start Ollama (ollama serve), or fix the URL using: /config url"}}
+	}
+	checks := []doctorCheck{{"Backend", "ok", "Ollama " + version.Version + " at " + base, ""}}
+
+	var tags struct {
+		Models []struct {
+			Name string `json:"name"`
+		} `json:"models"`
+	}
+	if err := probeJSON(client, "GET", base+"/api/tags", nil, &tags); err != nil {
+		return append(checks, doctorCheck{"Model", "warn", "could not list models: " + err.Error(), ""})
+	}
+	var names []string
+	for _, m := range tags.Models {
+		if m.Name == config.Model || m.Name == config.Model+":latest" {
+			return append(checks, doctorCheck{"Model", "ok", config.Model + " is installed", ""})
+		}
+		names = append(names, m.Name)
+	}
+	fix := "Download it using: ollama pull " + config.Model
+	if len(names) > 0 {
+		fix += ", or use an installed one: " + strings.Join(names, ", ")
+	}
+	return append(checks, doctorCheck{"Model", "fail", config.Model + " is not installed", fix})
+}
+
+func checkDataDir() []doctorCheck {
+	dir := getConfigDir()
+	var checks []doctorCheck
+	file, err := ioutil.TempFile(dir, ".doctor-")
+	if err != nil {
+		checks = append(checks, doctorCheck{"Data directory", "fail", "can't write to " + dir + ": " + err.Error(), "Check the permissions of " + dir + "."})
+	} else {
+		file.Close()
+		os.Remove(file.Name())
+		checks = append(checks, doctorCheck{"Data directory", "ok", dir + " is writable", ""})
+	}
+
+	free, ok := freeSpace(dir)
+	switch {
+	case !ok:
+	case free < minFreeSpace:
+		checks = append(checks, doctorCheck{"Disk space", "warn", fmt.Sprintf("%d MB free", free>>20), "Free up space, or sessions may fail to save."})
+	default:
+		checks = append(checks, doctorCheck{"Disk space", "ok", fmt.Sprintf("%d MB free", free>>20), ""})
+	}
+	if sessions, err := listSessions(); err != nil {
+		checks = append(checks, doctorCheck{"Sessions", "fail", err.Error(), "Check the permissions of " + dir + "."})
+	} else {
+		checks = append(checks, doctorCheck{"Sessions", "ok", fmt.Sprintf("%d saved", len(sessions)), ""})
+	}
+	return checks
+}
+
+func checkTerminal() doctorCheck {
+	in, out := term.IsTerminal(int(os.Stdin.Fd())), term.IsTerminal(int(os.Stdout.Fd()))
+	if !in || !out {
+		return doctorCheck{"Terminal", "warn", "input or output is not a terminal; line editing, colors and paging are off", "Run the app directly in a terminal for those features."}
+	}
+	width, height, _ := term.GetSize(int(os.Stdout.Fd()))
+	detail := fmt.Sprintf("%dx%d, TERM=%s", width, height, os.Getenv("TERM"))
+	if !colorsOn {
+		detail += ", colors off"
+	}
+	if os.Getenv("TERM") == "dumb" {
+		return doctorCheck{"Terminal", "warn", detail, "Set TERM to your terminal's type (e.g. xterm-256color) for colors and line editing."}
+	}
+	return doctorCheck{"Terminal", "ok", detail, ""}
+}
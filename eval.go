@@ -0,0 +1,283 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const judgePrompt = `You are judging a roleplay reply. The character is defined as:
+
+%s
+
+The user wrote:
+%s
+
+The character replied:
+%s
+
+Rate the reply from 1 to 10 for staying in character, writing quality and engagement. Respond with only the number.`
+
+type evalResult struct {
+	Prompt    string  `json:"prompt"`
+	Character string  `json:"character"`
+	Model     string  `json:"model"`
+	Response  string  `json:"response"`
+	Error     string  `json:"error,omitempty"`
+	Score     int     `json:"score,omitempty"`
+	Seconds   float64 `json:"seconds"`
+}
+
+var scorePattern = regexp.MustCompile(`\b(10|[1-9])\b`)
+
+// runEval sends every prompt to every character on every model and writes
+// the results. Usage:
+//
+//	char-chat eval --input prompts.csv --characters a,b --models x,y [--judge model] [--output results.csv]
+func runEval(args []string) {
+	fs := flag.NewFlagSet("eval", flag.ExitOnError)
+	input := fs.String("input", "", "CSV (first column or \"prompt\" column) or JSONL ({\"prompt\": ...}) file of prompts")
+	characters := fs.String("characters", "", "Comma-separated characters to test (default: the active character)")
+	models := fs.String("models", "", "Comma-separated models to test (default: the configured model)")
+	judge := fs.String("judge", "", "Model that scores every reply from 1 to 10 (optional)")
+	output := fs.String("output", "eval_results.csv", "Results file, CSV or JSONL by extension")
+	debug := fs.Bool("debug", false, "Enable debug")
+	fs.Parse(args)
+
+	if *input == "" {
+		fmt.Println("Usage: char-chat eval --input prompts.csv [--characters a,b] [--models x,y] [--judge model] [--output results.csv]")
+		os.Exit(1)
+	}
+
+	setupDirectories()
+	config := loadConfig()
+	initStorage(config)
+	client := newHTTPClient(config)
+
+	prompts, err := readEvalPrompts(*input)
+	if err != nil {
+		printError("Error reading prompts:", err)
+		os.Exit(1)
+	}
+
+	var cast []Character
+	for _, name := range splitList(*characters) {
+		character := defaultCharacter(config)
+		if name != "default" {
+			if character, err = loadCharacter(name); err != nil {
+				printErrorf("Error loading character '%s': %v\n", name, err)
+				os.Exit(1)
+			}
+		}
+		cast = append(cast, character)
+	}
+	if len(cast) == 0 {
+		cast = []Character{loadActiveCharacter(config)}
+	}
+	modelList := splitList(*models)
+	if len(modelList) == 0 {
+		modelList = []string{config.Model}
+	}
+
+	var results []evalResult
+	total, done := len(prompts)*len(cast)*len(modelList), 0
+	for _, model := range modelList {
+		modelConfig := config
+		modelConfig.Model = model
+		for _, character := range cast {
+			activeCharacter = character
+			for _, prompt := range prompts {
+				done++
+				fmt.Printf("[%d/%d] %s on %s: %s\n", done, total, characterKey(character), model, truncateText(prompt, 50))
+
+				history := []Message{{Role: "assistant", Content: character.Greeting}, {Role: "user", Content: prompt}}
+				start := time.Now()
+				response, err := requestReply(client, &modelConfig, buildPrompt(&modelConfig, history), *debug)
+				result := evalResult{
+					Prompt:    prompt,
+					Character: characterKey(character),
+					Model:     model,
+					Response:  response,
+					Seconds:   time.Since(start).Seconds(),
+				}
+				if err != nil {
+					result.Error = err.Error()
+				} else if *judge != "" {
+					result.Score = judgeReply(client, config, *judge, character, prompt, response, *debug)
+				}
+				results = append(results, result)
+			}
+		}
+	}
+
+	if err := writeEvalResults(*output, results); err != nil {
+		printError("Error writing results:", err)
+		os.Exit(1)
+	}
+	fmt.Printf("\nWrote %d results to %s\n", len(results), *output)
+	displayEvalMatrix(results, cast, modelList, *judge != "")
+}
+
+func splitList(list string) []string {
+	var items []string
+	for _, item := range strings.Split(list, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+func truncateText(text string, n int) string {
+	runes := []rune(strings.Join(strings.Fields(text), " "))
+	if len(runes) <= n {
+		return string(runes)
+	}
+	return string(runes[:n-3]) + "..."
+}
+
+func readEvalPrompts(path string) ([]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var prompts []string
+	if strings.EqualFold(filepath.Ext(path), ".jsonl") {
+		decoder := json.NewDecoder(file)
+		for {
+			var line struct {
+				Prompt string `json:"prompt"`
+			}
+			if err := decoder.Decode(&line); err == io.EOF {
+				break
+			} else if err != nil {
+				return nil, err
+			}
+			if line.Prompt != "" {
+				prompts = append(prompts, line.Prompt)
+			}
+		}
+		return prompts, nil
+	}
+
+	records, err := csv.NewReader(file).ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	column := 0
+	if len(records) > 0 {
+		for i, name := range records[0] {
+			if strings.EqualFold(strings.TrimSpace(name), "prompt") {
+				column = i
+				records = records[1:]
+				break
+			}
+		}
+	}
+	for _, record := range records {
+		if column < len(record) && strings.TrimSpace(record[column]) != "" {
+			prompts = append(prompts, record[column])
+		}
+	}
+	return prompts, nil
+}
+
+// judgeReply asks the judge model for a 1-10 score, returning 0 if it
+// could not produce one.
+func judgeReply(client *http.Client, config Config, judge string, character Character, prompt, response string, debug bool) int {
+	config.Model = judge
+	reply, err := requestReply(client, &config, []Message{
+		{Role: "user", Content: fmt.Sprintf(judgePrompt, character.characterPrompt(), prompt, response)},
+	}, debug)
+	if err != nil {
+		printError("Judge error:", err)
+		return 0
+	}
+	score, _ := strconv.Atoi(scorePattern.FindString(reply))
+	return score
+}
+
+func writeEvalResults(path string, results []evalResult) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if strings.EqualFold(filepath.Ext(path), ".jsonl") {
+		encoder := json.NewEncoder(file)
+		for _, result := range results {
+			if err := encoder.Encode(result); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	writer := csv.NewWriter(file)
+	writer.Write([]string{"prompt", "character", "model", "response", "error", "score", "seconds"})
+	for _, r := range results {
+		writer.Write([]string{r.Prompt, r.Character, r.Model, r.Response, r.Error, strconv.Itoa(r.Score), strconv.FormatFloat(r.Seconds, 'f', 2, 64)})
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+// displayEvalMatrix prints one row per character and one column per model,
+// with the average judge score (or average reply time without a judge).
+func displayEvalMatrix(results []evalResult, cast []Character, models []string, judged bool) {
+	type cell struct {
+		sum   float64
+		count int
+	}
+	cells := map[string]*cell{}
+	for _, r := range results {
+		if r.Error != "" || judged && r.Score == 0 {
+			continue
+		}
+		key := r.Character + "\x00" + r.Model
+		if cells[key] == nil {
+			cells[key] = &cell{}
+		}
+		if judged {
+			cells[key].sum += float64(r.Score)
+		} else {
+			cells[key].sum += r.Seconds
+		}
+		cells[key].count++
+	}
+
+	if judged {
+		fmt.Println("\n[Average Score]:")
+	} else {
+		fmt.Println("\n[Average Seconds per Reply]:")
+	}
+	fmt.Printf("%-20s", "")
+	for _, model := range models {
+		fmt.Printf(" %-16s", truncateText(model, 16))
+	}
+	fmt.Println()
+	for _, character := range cast {
+		name := characterKey(character)
+		fmt.Printf("%-20s", truncateText(name, 20))
+		for _, model := range models {
+			if c := cells[name+"\x00"+model]; c != nil {
+				fmt.Printf(" %-16.1f", c.sum/float64(c.count))
+			} else {
+				fmt.Printf(" %-16s", "-")
+			}
+		}
+		fmt.Println()
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"strings"
+)
+
+// EventsConfig injects random twists into long roleplays. Every is how
+// many of your messages go by between events, or 0 for events only on
+// /event. Tables are named lists of events; without any, a general one is
+// used. Reveal shows the event when it's drawn instead of leaving it a
+// surprise.
+type EventsConfig struct {
+	Every  int                 `json:"every,omitempty"`
+	Tables map[string][]string `json:"tables,omitempty"`
+	Reveal bool                `json:"reveal,omitempty"`
+}
+
+var defaultEventTable = []string{
+	"A stranger arrives with urgent news.",
+	"The weather turns suddenly and badly.",
+	"Something valuable goes missing.",
+	"An old acquaintance of the character shows up unexpectedly.",
+	"A loud noise nearby demands attention.",
+	"A secret is accidentally revealed.",
+	"A small accident causes a complication.",
+	"Someone overhears something they shouldn't have.",
+	"An opportunity appears that must be taken now or never.",
+	"The way forward is suddenly blocked.",
+}
+
+const eventInstructions = "Work this unexpected event into your next reply naturally, as part of the story: "
+
+// pendingEvent is the twist for the next reply, and turnsSinceEvent counts
+// messages towards the next one.
+var (
+	pendingEvent    string
+	turnsSinceEvent int
+)
+
+func eventTables(config *Config) map[string][]string {
+	if config.Events != nil && len(config.Events.Tables) > 0 {
+		return config.Events.Tables
+	}
+	return map[string][]string{"general": defaultEventTable}
+}
+
+// drawEvent picks an event from the named table, or from all of them.
+func drawEvent(config *Config, table string) (string, error) {
+	tables := eventTables(config)
+	var events []string
+	if table != "" {
+		var ok bool
+		if events, ok = tables[table]; !ok {
+			return "", fmt.Errorf("no event table named %q; tables: %s", table, strings.Join(tableNames(tables), ", "))
+		}
+	} else {
+		for _, name := range tableNames(tables) {
+			events = append(events, tables[name]...)
+		}
+	}
+	if len(events) == 0 {
+		return "", fmt.Errorf("the event table is empty")
+	}
+	return events[rand.Intn(len(events))], nil
+}
+
+func tableNames(tables map[string][]string) []string {
+	names := make([]string, 0, len(tables))
+	for name := range tables {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// countEventTurn draws an event when enough messages have gone by since
+// the last one.
+func countEventTurn(config *Config) {
+	if config.Events == nil || config.Events.Every <= 0 || pendingEvent != "" {
+		return
+	}
+	turnsSinceEvent++
+	if turnsSinceEvent < config.Events.Every {
+		return
+	}
+	if event, err := drawEvent(config, ""); err == nil {
+		setPendingEvent(config, event)
+	}
+}
+
+func setPendingEvent(config *Config, event string) {
+	pendingEvent = event
+	turnsSinceEvent = 0
+	if config.Events != nil && config.Events.Reveal {
+		notice("[Event]: %s\n", event)
+	}
+}
+
+// eventMessage is the instruction for the pending event, placed after the
+// history so the next reply acts on it.
+func eventMessage() []Message {
+	if pendingEvent == "" {
+		return nil
+	}
+	return []Message{{Role: "system", Content: eventInstructions + pendingEvent}}
+}
+
+// handleEventCommand handles /event [table | list]: the next reply brings
+// in a random event.
+func handleEventCommand(args string, config *Config) {
+	if args == "list" {
+		tables := eventTables(config)
+		fmt.Println("\n[Event Tables]:")
+		for _, name := range tableNames(tables) {
+			fmt.Printf("- %s (%d events)\n", name, len(tables[name]))
+		}
+		return
+	}
+	event, err := drawEvent(config, args)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	setPendingEvent(config, event)
+	if config.Events == nil || !config.Events.Reveal {
+		fmt.Println("Something unexpected will happen in the next reply.")
+	}
+}
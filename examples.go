@@ -0,0 +1,43 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// examplesDropped leaves the example dialogue out of the prompt for the
+// rest of the chat, to make room in the context window.
+var examplesDropped bool
+
+func dropExamples(config *Config) {
+	if examplesDropped || examplesPrompt(activeCharacter) == "" || !layerEnabled(config, "examples") {
+		fmt.Println("There is no example dialogue in the prompt to drop.")
+		return
+	}
+	examplesDropped = true
+	fmt.Println("Example dialogue is left out for the rest of this chat.")
+}
+
+// examplesPrompt formats the character's example dialogue for the system
+// prompt. Cards start each example with <START> and name the speakers
+// {{char}} and {{user}}.
+func examplesPrompt(c Character) string {
+	text := strings.TrimSpace(c.Examples)
+	if text == "" {
+		return ""
+	}
+	name := characterDisplayName(c)
+	text = strings.NewReplacer("{{char}}", name, "{{Char}}", name, "<BOT>", name,
+		"{{user}}", "User", "{{User}}", "User", "<USER>", "User").Replace(text)
+
+	var examples []string
+	for _, example := range strings.Split(text, "<START>") {
+		if example = strings.TrimSpace(example); example != "" {
+			examples = append(examples, example)
+		}
+	}
+	if len(examples) == 0 {
+		return ""
+	}
+	return "Example dialogue, showing how " + name + " talks. It isn't part of this conversation:\n\n" + strings.Join(examples, "\n\n")
+}
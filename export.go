@@ -0,0 +1,210 @@
+package main
+
+import (
+	"archive/zip"
+	"encoding/base64"
+	"fmt"
+	"html"
+	"io/ioutil"
+	"mime"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// handleExportCommand handles /export {html | epub} [file], writing the
+// chat as a page or an e-book with its illustrations next to the messages
+// they illustrate.
+func handleExportCommand(args string, config *Config) {
+	fields := strings.Fields(args)
+	if len(fields) == 0 || len(fields) > 2 || fields[0] != "html" && fields[0] != "epub" {
+		fmt.Println("Usage: /export {html | epub} [file]")
+		return
+	}
+	if !hasUserMessages(messageHistory) {
+		fmt.Println("Nothing to export yet.")
+		return
+	}
+	format := fields[0]
+	path := exportName() + "." + format
+	if len(fields) > 1 {
+		path = fields[1]
+	}
+
+	var err error
+	if format == "html" {
+		err = ioutil.WriteFile(path, []byte(exportHTML(config)), 0644)
+	} else {
+		err = writeEPUB(path, config)
+	}
+	if err != nil {
+		printError("Error exporting chat:", err)
+		return
+	}
+	fmt.Printf("Chat exported to %s\n", path)
+}
+
+// exportName is the default file name for an export, without extension.
+func exportName() string {
+	if sessionName != "" {
+		return sessionName
+	}
+	return "chat_" + time.Now().Format("2006-01-02_15-04-05")
+}
+
+func exportTitle() string {
+	return "Chat with " + characterDisplayName(activeCharacter)
+}
+
+// exportBody renders the chat as XHTML, which also does for HTML, leaving
+// out what isn't part of the story. imageSrc returns where an illustration
+// is found, or "" to leave it out.
+func exportBody(config *Config, imageSrc func(i int, illustration Illustration) string) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "<h1>%s</h1>\n", html.EscapeString(exportTitle()))
+	outside := outOfStory(config, messageHistory)
+	for i, msg := range messageHistory {
+		if outside[i] || msg.Role != "user" && msg.Role != "assistant" {
+			continue
+		}
+		name := characterDisplayName(activeCharacter)
+		if msg.Role == "user" {
+			name = "You"
+		}
+		fmt.Fprintf(&b, "<div class=\"%s\"><p class=\"name\">%s</p>\n", msg.Role, html.EscapeString(name))
+		for _, paragraph := range strings.Split(msg.Content, "\n\n") {
+			if paragraph = strings.TrimSpace(paragraph); paragraph != "" {
+				text := strings.ReplaceAll(html.EscapeString(paragraph), "\n", "<br/>")
+				fmt.Fprintf(&b, "<p>%s</p>\n", text)
+			}
+		}
+		for n, illustration := range gallery {
+			if illustration.MessageIndex != i {
+				continue
+			}
+			if src := imageSrc(n, illustration); src != "" {
+				fmt.Fprintf(&b, "<img src=\"%s\" alt=\"%s\"/>\n", src, html.EscapeString(illustration.Prompt))
+			}
+		}
+		b.WriteString("</div>\n")
+	}
+	return b.String()
+}
+
+const exportStyle = "body{font-family:serif;max-width:40em;margin:auto;line-height:1.5}.name{font-weight:bold;margin-bottom:0}.user{color:#345}img{max-width:100%}"
+
+// exportHTML is the chat as a standalone page, with the illustrations
+// embedded so it can be shared as one file.
+func exportHTML(config *Config) string {
+	body := exportBody(config, func(_ int, illustration Illustration) string {
+		data, err := ioutil.ReadFile(illustration.Path)
+		if err != nil {
+			return ""
+		}
+		return "data:" + imageType(illustration.Path) + ";base64," + base64.StdEncoding.EncodeToString(data)
+	})
+	return fmt.Sprintf("<!DOCTYPE html><html><head><meta charset=\"utf-8\"><title>%s</title><style>%s</style></head><body>\n%s</body></html>\n",
+		html.EscapeString(exportTitle()), exportStyle, body)
+}
+
+func imageType(path string) string {
+	if t := mime.TypeByExtension(filepath.Ext(path)); t != "" {
+		return t
+	}
+	return "image/png"
+}
+
+const (
+	epubContainer = `<?xml version="1.0" encoding="UTF-8"?>
+<container version="1.0" xmlns="urn:oasis:names:tc:opendocument:xmlns:container">
+<rootfiles><rootfile full-path="OEBPS/content.opf" media-type="application/oebps-package+xml"/></rootfiles>
+</container>
+`
+	epubPackage = `<?xml version="1.0" encoding="UTF-8"?>
+<package xmlns="http://www.idpf.org/2007/opf" version="3.0" unique-identifier="id">
+<metadata xmlns:dc="http://purl.org/dc/elements/1.1/">
+<dc:identifier id="id">urn:char-chat:%s</dc:identifier>
+<dc:title>%s</dc:title>
+<dc:language>en</dc:language>
+<meta property="dcterms:modified">%s</meta>
+</metadata>
+<manifest>
+<item id="nav" href="nav.xhtml" media-type="application/xhtml+xml" properties="nav"/>
+<item id="chat" href="chat.xhtml" media-type="application/xhtml+xml"/>
+%s</manifest>
+<spine><itemref idref="chat"/></spine>
+</package>
+`
+	epubPage = `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE html>
+<html xmlns="http://www.w3.org/1999/xhtml" xmlns:epub="http://www.idpf.org/2007/ops">
+<head><title>%s</title><style>%s</style></head>
+<body>
+%s</body>
+</html>
+`
+)
+
+// writeEPUB writes the chat as an EPUB 3 book of one chapter, with the
+// illustrations as images in it.
+func writeEPUB(path string, config *Config) error {
+	type image struct {
+		name string
+		data []byte
+	}
+	var images []image
+	body := exportBody(config, func(n int, illustration Illustration) string {
+		data, err := ioutil.ReadFile(illustration.Path)
+		if err != nil {
+			return ""
+		}
+		name := fmt.Sprintf("images/%d%s", n+1, filepath.Ext(illustration.Path))
+		images = append(images, image{name, data})
+		return name
+	})
+
+	var manifest strings.Builder
+	for i, img := range images {
+		fmt.Fprintf(&manifest, "<item id=\"img%d\" href=\"%s\" media-type=\"%s\"/>\n", i+1, img.name, imageType(img.name))
+	}
+	title := html.EscapeString(exportTitle())
+	nav := fmt.Sprintf(epubPage, title, "", "<nav epub:type=\"toc\"><ol><li><a href=\"chat.xhtml\">"+title+"</a></li></ol></nav>\n")
+
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	w := zip.NewWriter(f)
+
+	files := []struct {
+		name, content string
+	}{
+		{"META-INF/container.xml", epubContainer},
+		{"OEBPS/content.opf", fmt.Sprintf(epubPackage, html.EscapeString(exportName()), title, time.Now().UTC().Format("2006-01-02T15:04:05Z"), manifest.String())},
+		{"OEBPS/nav.xhtml", nav},
+		{"OEBPS/chat.xhtml", fmt.Sprintf(epubPage, title, exportStyle, body)},
+	}
+	// The mimetype comes first and uncompressed, as readers expect.
+	mimetype, err := w.CreateHeader(&zip.FileHeader{Name: "mimetype", Method: zip.Store})
+	if err != nil {
+		return err
+	}
+	mimetype.Write([]byte("application/epub+zip"))
+	for _, file := range files {
+		out, err := w.Create(file.name)
+		if err != nil {
+			return err
+		}
+		out.Write([]byte(file.content))
+	}
+	for _, img := range images {
+		out, err := w.CreateHeader(&zip.FileHeader{Name: "OEBPS/" + img.name, Method: zip.Store})
+		if err != nil {
+			return err
+		}
+		out.Write(img.data)
+	}
+	return w.Close()
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+)
+
+// Sentences shorter than this are left alone by the dedup filter, since
+// short lines like "Yes." legitimately repeat.
+const minDedupSentence = 20
+
+var sentencePattern = regexp.MustCompile(`[^.!?\n]+[.!?]+["'*)\]]*\s*`)
+
+// dedupReply removes paragraphs and sentences that appear word for word
+// earlier in the same reply, a common failure of small models. It returns
+// the filtered reply and how many pieces were removed.
+func dedupReply(content string) (string, int) {
+	removed := 0
+	seenParagraphs := map[string]bool{}
+	seenSentences := map[string]bool{}
+
+	var paragraphs []string
+	for _, paragraph := range strings.Split(content, "\n\n") {
+		key := strings.TrimSpace(paragraph)
+		if key == "" {
+			continue
+		}
+		if seenParagraphs[key] {
+			removed++
+			continue
+		}
+		seenParagraphs[key] = true
+
+		var kept strings.Builder
+		end := 0
+		for _, loc := range sentencePattern.FindAllStringIndex(paragraph, -1) {
+			// Keep whatever lies between sentences, such as an unpunctuated
+			// action line, so only the duplicate itself is dropped.
+			kept.WriteString(paragraph[end:loc[0]])
+			end = loc[1]
+			sentence := paragraph[loc[0]:loc[1]]
+			key := strings.TrimSpace(sentence)
+			if len(key) >= minDedupSentence && seenSentences[key] {
+				removed++
+				continue
+			}
+			seenSentences[key] = true
+			kept.WriteString(sentence)
+		}
+		kept.WriteString(paragraph[end:])
+
+		if text := strings.TrimSpace(kept.String()); text != "" {
+			paragraphs = append(paragraphs, text)
+		}
+	}
+
+	if removed == 0 {
+		return content, 0
+	}
+	return strings.Join(paragraphs, "\n\n"), removed
+}
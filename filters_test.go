@@ -0,0 +1,12 @@
+package main
+
+import "testing"
+
+func TestDedupReplyKeepsTextBetweenSentences(t *testing.T) {
+	content := "*She smiles softly*\nI already told you that the door is locked tonight.\n\nI already told you that the door is locked tonight."
+	got, removed := dedupReply(content)
+	want := "*She smiles softly*\nI already told you that the door is locked tonight."
+	if got != want || removed != 1 {
+		t.Fatalf("dedupReply() = %q, %d; want %q, 1", got, removed, want)
+	}
+}
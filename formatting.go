@@ -0,0 +1,153 @@
+package main
+
+import "strings"
+
+// FormatConfig sets how replies are split into actions, speech and
+// narration for styling. Each marker is a delimiter used on both sides,
+// like "*", or an opening and closing delimiter separated by a space, like
+// "“ ”". Leaving a list out uses the defaults; an empty list turns that
+// kind off.
+type FormatConfig struct {
+	Actions []string `json:"actions"`
+	Speech  []string `json:"speech"`
+	// StripMarkers hides the action delimiters once actions are styled.
+	StripMarkers bool `json:"strip_markers,omitempty"`
+}
+
+var (
+	defaultActionMarkers = []string{"*"}
+	defaultSpeechMarkers = []string{`"`, "“ ”"}
+)
+
+// formatRule is one parsed marker.
+type formatRule struct {
+	open, close string
+	action      bool
+}
+
+// formatRules are the markers in use, set by initFormatting.
+var (
+	formatRules  []formatRule
+	stripMarkers bool
+)
+
+func initFormatting(config Config) {
+	actions, speech := defaultActionMarkers, defaultSpeechMarkers
+	stripMarkers = false
+	if f := config.Formatting; f != nil {
+		if f.Actions != nil {
+			actions = f.Actions
+		}
+		if f.Speech != nil {
+			speech = f.Speech
+		}
+		stripMarkers = f.StripMarkers
+	}
+	formatRules = nil
+	for _, markers := range []struct {
+		list   []string
+		action bool
+	}{{actions, true}, {speech, false}} {
+		for _, marker := range markers.list {
+			open, close := marker, marker
+			if fields := strings.Fields(marker); len(fields) == 2 {
+				open, close = fields[0], fields[1]
+			}
+			if open != "" && close != "" {
+				formatRules = append(formatRules, formatRule{open, close, markers.action})
+			}
+		}
+	}
+}
+
+// replySpan is a piece of a reply and what kind of text it is.
+type replySpan struct {
+	text string
+	kind string // "action", "speech" or "" for narration
+}
+
+// splitReply cuts text into actions, speech and the narration between
+// them. A span starts at the earliest opening marker that is closed before
+// the paragraph ends; markers inside it are part of it, so speech within
+// an action stays an action. Unclosed markers are left as narration.
+func splitReply(text string) []replySpan {
+	var spans []replySpan
+	plain := 0
+	for i := 0; i < len(text); {
+		rule, end, ok := matchFormatRule(text, i)
+		if !ok {
+			i++
+			continue
+		}
+		if plain < i {
+			spans = append(spans, replySpan{text: text[plain:i]})
+		}
+		kind := "speech"
+		if rule.action {
+			kind = "action"
+		}
+		spans = append(spans, replySpan{text: text[i:end], kind: kind})
+		i, plain = end, end
+	}
+	if plain < len(text) {
+		spans = append(spans, replySpan{text: text[plain:]})
+	}
+	return spans
+}
+
+// matchFormatRule finds a rule opening at i with a non-empty body closed
+// in the same paragraph, returning the end of its closing marker.
+func matchFormatRule(text string, i int) (formatRule, int, bool) {
+	for _, rule := range formatRules {
+		if !strings.HasPrefix(text[i:], rule.open) {
+			continue
+		}
+		start := i + len(rule.open)
+		n := strings.Index(text[start:], rule.close)
+		if n <= 0 || strings.Contains(text[start:start+n], "\n\n") {
+			continue
+		}
+		return rule, start + n + len(rule.close), true
+	}
+	return formatRule{}, 0, false
+}
+
+// paintReply styles a reply: narration in the character style, actions and
+// speech in theirs.
+func paintReply(text string) string {
+	if !colorsOn {
+		return text
+	}
+	var b strings.Builder
+	for _, span := range splitReply(text) {
+		switch span.kind {
+		case "action":
+			body := span.text
+			if stripMarkers {
+				body = stripFormatMarkers(body)
+			}
+			b.WriteString(paint(theme.Action, body))
+		case "speech":
+			b.WriteString(paint(styleOr(theme.Speech, theme.Character), span.text))
+		default:
+			b.WriteString(paint(theme.Character, span.text))
+		}
+	}
+	return b.String()
+}
+
+func stripFormatMarkers(action string) string {
+	for _, rule := range formatRules {
+		if rule.action && strings.HasPrefix(action, rule.open) && strings.HasSuffix(action, rule.close) {
+			return action[len(rule.open) : len(action)-len(rule.close)]
+		}
+	}
+	return action
+}
+
+func styleOr(style, fallback string) string {
+	if style != "" {
+		return style
+	}
+	return fallback
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const GalleryDir = "gallery"
+
+// Illustration is a generated scene image and the message it illustrates.
+type Illustration struct {
+	Path         string    `json:"path"`
+	MessageIndex int       `json:"message_index"`
+	Prompt       string    `json:"prompt,omitempty"`
+	Created      time.Time `json:"created"`
+}
+
+// gallery holds the current session's illustrations, oldest first.
+var gallery []Illustration
+
+func getGalleryDir() string {
+	return filepath.Join(getConfigDir(), GalleryDir)
+}
+
+// addIllustration saves image data to the gallery directory and records it
+// against the latest message.
+func addIllustration(data []byte, ext, prompt string) (Illustration, error) {
+	if err := os.MkdirAll(getGalleryDir(), os.ModePerm); err != nil {
+		return Illustration{}, err
+	}
+	created := time.Now()
+	path := filepath.Join(getGalleryDir(), created.Format("2006-01-02_15-04-05.000")+ext)
+	if err := ioutil.WriteFile(path, data, 0644); err != nil {
+		return Illustration{}, err
+	}
+	illustration := Illustration{Path: path, MessageIndex: len(messageHistory) - 1, Prompt: prompt, Created: created}
+	gallery = append(gallery, illustration)
+	return illustration, nil
+}
+
+func handleGalleryCommand(args string, config *Config) {
+	fields := strings.Fields(args)
+	if len(fields) == 0 {
+		listGallery()
+		return
+	}
+	if len(fields) != 2 || fields[0] != "open" && fields[0] != "show" {
+		fmt.Println("Usage: /gallery [open {number} | show {number}]")
+		return
+	}
+	n, err := strconv.Atoi(fields[1])
+	if err != nil || n < 1 || n > len(gallery) {
+		fmt.Printf("No illustration number %s. List them using /gallery\n", fields[1])
+		return
+	}
+	illustration := gallery[n-1]
+	if fields[0] == "open" {
+		if err := openInViewer(illustration.Path); err != nil {
+			printError("Error opening image:", err)
+		}
+		return
+	}
+	if !showInline(illustration.Path, config) {
+		fmt.Println("This terminal can't show images inline. Use /gallery open", n)
+	}
+}
+
+func listGallery() {
+	if len(gallery) == 0 {
+		fmt.Println("No illustrations in this session yet.")
+		return
+	}
+	fmt.Println("\n[Gallery]:")
+	for i, illustration := range gallery {
+		excerpt := ""
+		if illustration.MessageIndex >= 0 && illustration.MessageIndex < len(messageHistory) {
+			excerpt = truncateText(messageHistory[illustration.MessageIndex].Content, 50)
+		}
+		fmt.Printf("%d. %s (message %d) %s\n", i+1, filepath.Base(illustration.Path), illustration.MessageIndex+1, excerpt)
+	}
+	fmt.Println("View one using: /gallery show {number}, or /gallery open {number} for the image viewer")
+}
+
+func openInViewer(path string) error {
+	switch runtime.GOOS {
+	case "darwin":
+		return exec.Command("open", path).Start()
+	case "windows":
+		return exec.Command("cmd", "/c", "start", "", path).Start()
+	default:
+		return exec.Command("xdg-open", path).Start()
+	}
+}
+
+// showInline draws the image in terminals that support an image protocol,
+// reporting whether it could.
+func showInline(path string, config *Config) bool {
+	protocol := imageProtocol(config)
+	if protocol == "" || protocol == "ascii" {
+		return false
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		printError("Error reading image:", err)
+		return true
+	}
+	if err := drawImage(data, min(terminalWidth(int(os.Stdout.Fd())), 80), protocol); err != nil {
+		printError("Error showing image:", err)
+	}
+	return true
+}
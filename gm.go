@@ -0,0 +1,184 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+const (
+	GMMaxAttempts = 3
+
+	gmInstructions = `You are the game master. Respond only with a JSON object with these fields:
+- "narration": what happens, in the second person
+- "dialogue": a list of {"speaker": name, "line": what they say}, possibly empty
+- "state_changes": an object of game state keys to their new values (numbers, strings or booleans), null to remove a key, or {} if nothing changed
+Keep state keys short and consistent, e.g. "hp", "gold", "location".`
+)
+
+// gmSchema constrains the backend's output to a GMTurn, on backends that
+// support structured outputs.
+var gmSchema = map[string]interface{}{
+	"type": "object",
+	"properties": map[string]interface{}{
+		"narration": map[string]string{"type": "string"},
+		"dialogue": map[string]interface{}{
+			"type": "array",
+			"items": map[string]interface{}{
+				"type": "object",
+				"properties": map[string]interface{}{
+					"speaker": map[string]string{"type": "string"},
+					"line":    map[string]string{"type": "string"},
+				},
+				"required": []string{"speaker", "line"},
+			},
+		},
+		"state_changes": map[string]string{"type": "object"},
+	},
+	"required": []string{"narration", "dialogue", "state_changes"},
+}
+
+// GMTurn is one structured reply in game master mode.
+type GMTurn struct {
+	Narration string `json:"narration"`
+	Dialogue  []struct {
+		Speaker string `json:"speaker"`
+		Line    string `json:"line"`
+	} `json:"dialogue"`
+	StateChanges map[string]interface{} `json:"state_changes"`
+}
+
+// In game master mode replies are structured, and their state changes are
+// applied to gameState once the reply is accepted.
+var (
+	gmMode        bool
+	gameState     = map[string]interface{}{}
+	pendingGMTurn *GMTurn
+)
+
+func handleGMCommand(option string) {
+	switch option {
+	case "on":
+		gmMode = true
+		fmt.Println("Game master mode on. Replies are structured and update the game state. Turn it off with /gm off")
+	case "off":
+		gmMode = false
+		fmt.Println("Game master mode off.")
+	case "state":
+		displayGameState()
+	default:
+		state := "off"
+		if gmMode {
+			state = "on"
+		}
+		fmt.Printf("Game master mode is %s. Usage: /gm on|off|state\n", state)
+	}
+}
+
+func parseGMTurn(content string) (GMTurn, error) {
+	var turn GMTurn
+	if err := json.Unmarshal([]byte(strings.TrimSpace(content)), &turn); err != nil {
+		return GMTurn{}, err
+	}
+	if strings.TrimSpace(turn.Narration) == "" && len(turn.Dialogue) == 0 {
+		return GMTurn{}, errors.New("reply has neither narration nor dialogue")
+	}
+	for _, d := range turn.Dialogue {
+		if d.Speaker == "" || d.Line == "" {
+			return GMTurn{}, errors.New("dialogue entries need a speaker and a line")
+		}
+	}
+	return turn, nil
+}
+
+// renderGMTurn is how a turn is shown and kept in the history.
+func renderGMTurn(turn GMTurn) string {
+	parts := []string{}
+	if narration := strings.TrimSpace(turn.Narration); narration != "" {
+		parts = append(parts, narration)
+	}
+	if len(turn.Dialogue) > 0 {
+		lines := make([]string, len(turn.Dialogue))
+		for i, d := range turn.Dialogue {
+			lines[i] = fmt.Sprintf("%s: \"%s\"", d.Speaker, d.Line)
+		}
+		parts = append(parts, strings.Join(lines, "\n"))
+	}
+	return strings.Join(parts, "\n\n")
+}
+
+// requestGMTurn asks for a structured reply, asking again with the parse
+// error when the backend returns something that isn't a valid turn.
+func requestGMTurn(client *http.Client, config *Config, debug bool) (string, error) {
+	ctx := startGeneration()
+	defer finishGeneration()
+
+	state, _ := json.Marshal(gameState)
+	messages := append(buildPrompt(config, messageHistory), Message{
+		Role:    "system",
+		Content: gmInstructions + "\n\nCurrent game state: " + string(state),
+	})
+
+	var lastErr error
+	for attempt := 1; attempt <= GMMaxAttempts; attempt++ {
+		data := newChatMessage(config, messages)
+		data.Format = gmSchema
+		result, err := chatCompletionWithRetry(ctx, client, config, data, debug)
+		if err != nil {
+			return "", err
+		}
+
+		turn, err := parseGMTurn(result.Content)
+		if err == nil {
+			lastReply = result
+			pendingGMTurn = &turn
+			return renderGMTurn(turn), nil
+		}
+		lastErr = err
+		if debug {
+			notice("[Debug] Invalid game master reply (attempt %d/%d): %v\n", attempt, GMMaxAttempts, err)
+		}
+		messages = append(messages,
+			Message{Role: "assistant", Content: result.Content},
+			Message{Role: "system", Content: "That reply was not valid (" + err.Error() + "). Respond again with only the JSON object."},
+		)
+	}
+	return "", fmt.Errorf("no valid game master reply after %d attempts: %v", GMMaxAttempts, lastErr)
+}
+
+// applyPendingGMTurn applies the state changes of the reply that was just
+// accepted.
+func applyPendingGMTurn() {
+	if pendingGMTurn == nil {
+		return
+	}
+	turn := pendingGMTurn
+	pendingGMTurn = nil
+
+	keys := make([]string, 0, len(turn.StateChanges))
+	for key := range turn.StateChanges {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		old, existed := gameState[key]
+		value := turn.StateChanges[key]
+		switch {
+		case value == nil:
+			delete(gameState, key)
+			notice("[State] %s removed\n", key)
+		case existed:
+			gameState[key] = value
+			if fmt.Sprint(old) == fmt.Sprint(value) {
+				continue
+			}
+			notice("[State] %s: %v -> %v\n", key, old, value)
+		default:
+			gameState[key] = value
+			notice("[State] %s: %v\n", key, value)
+		}
+	}
+}
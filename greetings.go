@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+)
+
+// greetings are the character's greeting followed by its alternates, as
+// card formats provide them.
+func (c Character) greetings() []string {
+	var greetings []string
+	for _, greeting := range append([]string{c.Greeting}, c.AlternateGreetings...) {
+		if strings.TrimSpace(greeting) != "" {
+			greetings = append(greetings, greeting)
+		}
+	}
+	return greetings
+}
+
+// pickGreeting chooses one of the character's greetings at random, so chats
+// don't always open the same way.
+func pickGreeting(c Character) string {
+	greetings := c.greetings()
+	if len(greetings) == 0 {
+		return c.Greeting
+	}
+	return greetings[rand.Intn(len(greetings))]
+}
+
+// handleGreetCommand handles /greet [number]: listing the character's
+// greetings, or opening the chat with another one before it gets going.
+func handleGreetCommand(args string, config *Config) {
+	greetings := activeCharacter.greetings()
+	if args == "" {
+		if len(greetings) == 0 {
+			fmt.Println("This character has no greeting.")
+			return
+		}
+		fmt.Println("\n[Greetings]:")
+		for i, greeting := range greetings {
+			fmt.Printf("%d. %s\n", i+1, truncateText(greeting, 100))
+		}
+		fmt.Println("Open the chat with one using: /greet {number}")
+		return
+	}
+	n, err := strconv.Atoi(args)
+	if err != nil || n < 1 || n > len(greetings) {
+		fmt.Printf("No greeting number %s. See them using /greet\n", args)
+		return
+	}
+	if len(messageHistory) > 1 || len(messageHistory) == 1 && messageHistory[0].Role != "assistant" {
+		fmt.Println("The chat has already started. Use /greet before your first message.")
+		return
+	}
+	setHistory(nil)
+	displayGreeting(greetings[n-1], config)
+}
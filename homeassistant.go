@@ -0,0 +1,226 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// HomeAssistantConfig controls the Home Assistant endpoint. It speaks the
+// Ollama API, so the character can be added in Home Assistant through the
+// Ollama integration and picked as the voice assistant's conversation agent.
+type HomeAssistantConfig struct {
+	// PassThroughTools forwards Home Assistant's tool definitions (its
+	// intents) to the backend and the resulting tool calls back, so the
+	// character can control the home. The model must support tools.
+	PassThroughTools bool `json:"pass_through_tools"`
+}
+
+// homeAssistantServer presents every character as an Ollama model and
+// answers chats in character using the configured backend.
+type homeAssistantServer struct {
+	config Config
+	client *http.Client
+	debug  bool
+}
+
+func runHomeAssistant(addr string, config Config, client *http.Client, debug bool) {
+	s := &homeAssistantServer{config: config, client: client, debug: debug}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/version", s.handleVersion)
+	mux.HandleFunc("/api/tags", s.handleTags)
+	mux.HandleFunc("/api/show", s.handleShow)
+	mux.HandleFunc("/api/chat", s.handleChat)
+
+	fmt.Printf("Serving the Home Assistant conversation agent on %s\n", addr)
+	fmt.Println("Add it in Home Assistant with the Ollama integration, using this address as the URL.")
+	if err := http.ListenAndServe(addr, mux); err != nil {
+		printError("Error running server:", err)
+	}
+}
+
+func (s *homeAssistantServer) passThroughTools() bool {
+	return s.config.HomeAssistant != nil && s.config.HomeAssistant.PassThroughTools
+}
+
+func (s *homeAssistantServer) handleVersion(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]string{"version": AppVersion})
+}
+
+// handleTags lists the characters as models.
+func (s *homeAssistantServer) handleTags(w http.ResponseWriter, r *http.Request) {
+	names, err := listCharacters()
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		return
+	}
+
+	type model struct {
+		Name       string    `json:"name"`
+		Model      string    `json:"model"`
+		ModifiedAt time.Time `json:"modified_at"`
+	}
+	models := []model{{Name: "default:latest", Model: "default:latest", ModifiedAt: time.Now()}}
+	for _, name := range names {
+		models = append(models, model{Name: name + ":latest", Model: name + ":latest", ModifiedAt: time.Now()})
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"models": models})
+}
+
+func (s *homeAssistantServer) handleShow(w http.ResponseWriter, r *http.Request) {
+	capabilities := []string{"completion"}
+	if s.passThroughTools() {
+		capabilities = append(capabilities, "tools")
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"capabilities": capabilities,
+		"details":      map[string]string{"family": "character.chat"},
+		"model_info":   map[string]interface{}{},
+	})
+}
+
+func (s *homeAssistantServer) character(model string) (Character, error) {
+	name := strings.TrimSuffix(model, ":latest")
+	if name == "" || name == "default" {
+		return defaultCharacter(s.config), nil
+	}
+	return loadCharacter(name)
+}
+
+// handleChat prepends the character's persona to Home Assistant's own
+// instructions and forwards the chat to the backend. Messages and tool calls
+// are passed through as they are, since Home Assistant relies on fields this
+// app doesn't otherwise use.
+func (s *homeAssistantServer) handleChat(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Model    string                   `json:"model"`
+		Messages []map[string]interface{} `json:"messages"`
+		Tools    json.RawMessage          `json:"tools,omitempty"`
+		Stream   *bool                    `json:"stream,omitempty"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid JSON: " + err.Error()})
+		return
+	}
+
+	character, err := s.character(req.Model)
+	if err != nil {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": fmt.Sprintf("model %q not found", req.Model)})
+		return
+	}
+
+	persona := s.config.System + "\n" + character.characterPrompt() +
+		"\n\nYou are also the voice assistant of the user's smart home. Stay in character, but keep answers short enough to be spoken aloud."
+	messages := append([]map[string]interface{}{{"role": "system", "content": persona}}, req.Messages...)
+
+	backendReq := map[string]interface{}{
+		"model":    s.config.Model,
+		"messages": messages,
+		"stream":   false,
+		"options":  chatOptions(&s.config),
+	}
+	if s.config.KeepAlive != nil {
+		backendReq["keep_alive"] = s.config.KeepAlive
+	}
+	if s.passThroughTools() && len(req.Tools) > 0 {
+		backendReq["tools"] = req.Tools
+	}
+
+	response, err := s.forward(r, backendReq)
+	if err != nil {
+		writeJSON(w, http.StatusBadGateway, map[string]string{"error": err.Error()})
+		return
+	}
+	// Replies that are only tool calls have nothing to check.
+	if content := replyContent(response); content != "" && checkReplies(&s.config) {
+		checked, ok := checkReplyWith(s.client, &s.config, lastUserContent(req.Messages), content, func() (string, error) {
+			retry, err := s.forward(r, backendReq)
+			if err != nil {
+				return "", err
+			}
+			response = retry
+			return replyContent(retry), nil
+		}, s.debug)
+		if !ok {
+			writeJSON(w, http.StatusForbidden, map[string]string{"error": "reply blocked by safety policy"})
+			return
+		}
+		if message, ok := response["message"].(map[string]interface{}); ok {
+			message["content"] = checked
+		}
+	}
+
+	response["model"] = req.Model
+	if req.Stream != nil && !*req.Stream {
+		writeJSON(w, http.StatusOK, response)
+		return
+	}
+
+	// Ollama streams by default: send the whole reply as one chunk followed
+	// by the final done message.
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	done := map[string]interface{}{}
+	for key, value := range response {
+		done[key] = value
+	}
+	response["done"] = false
+	delete(response, "done_reason")
+	done["message"] = map[string]string{"role": "assistant", "content": ""}
+	done["done"] = true
+
+	encoder := json.NewEncoder(w)
+	encoder.Encode(response)
+	encoder.Encode(done)
+}
+
+// forward sends a chat request to the backend, returning its response with
+// the reply cut at the stop sequences.
+func (s *homeAssistantServer) forward(r *http.Request, backendReq map[string]interface{}) (map[string]interface{}, error) {
+	jsonData, _ := json.Marshal(backendReq)
+	backend, _ := http.NewRequestWithContext(r.Context(), "POST", s.config.URL, bytes.NewBuffer(jsonData))
+	backend.Header.Set("Content-Type", "application/json")
+	resp, err := s.client.Do(backend)
+	if err != nil {
+		return nil, fmt.Errorf("backend error: %v", err)
+	}
+	defer resp.Body.Close()
+
+	body, _ := ioutil.ReadAll(resp.Body)
+	var response map[string]interface{}
+	if err := json.Unmarshal(body, &response); err != nil || resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("backend returned %s", resp.Status)
+	}
+	if message, ok := response["message"].(map[string]interface{}); ok {
+		if content, ok := message["content"].(string); ok {
+			message["content"] = truncateAtStop(content, s.config.StopSequences)
+		}
+	}
+	if s.debug {
+		notice("[Debug] Home Assistant reply: %s\n", body)
+	}
+	return response, nil
+}
+
+// replyContent is the text of the reply in a backend response.
+func replyContent(response map[string]interface{}) string {
+	message, _ := response["message"].(map[string]interface{})
+	content, _ := message["content"].(string)
+	return content
+}
+
+// lastUserContent is the text of the latest user message Home Assistant
+// sent, for the safety check.
+func lastUserContent(messages []map[string]interface{}) string {
+	for i := len(messages) - 1; i >= 0; i-- {
+		if messages[i]["role"] == "user" {
+			content, _ := messages[i]["content"].(string)
+			return content
+		}
+	}
+	return ""
+}
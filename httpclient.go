@@ -0,0 +1,183 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+)
+
+var errReadTimeout = errors.New("backend stopped responding (read timeout)")
+
+const (
+	DefaultConnectTimeout  = 10
+	DefaultResponseTimeout = 300
+	DefaultReadTimeout     = 60
+)
+
+func timeoutSetting(seconds, fallback int) time.Duration {
+	if seconds <= 0 {
+		seconds = fallback
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+// proxyFunc returns the proxy setting for the transport. An explicit proxy
+// from the config (http://, https://, socks5:// or socks5h://) applies to every
+// request; otherwise HTTP_PROXY, HTTPS_PROXY and NO_PROXY are honored.
+func proxyFunc(proxy string) func(*http.Request) (*url.URL, error) {
+	if proxy == "" {
+		return http.ProxyFromEnvironment
+	}
+	proxyURL, err := url.Parse(proxy)
+	if err != nil || proxyURL.Host == "" {
+		printErrorf("Error parsing proxy URL %q, falling back to the environment: %v\n", proxy, err)
+		return http.ProxyFromEnvironment
+	}
+	switch proxyURL.Scheme {
+	case "http", "https", "socks5", "socks5h":
+	default:
+		fmt.Printf("Unsupported proxy scheme %q, falling back to the environment.\n", proxyURL.Scheme)
+		return http.ProxyFromEnvironment
+	}
+	return http.ProxyURL(proxyURL)
+}
+
+// newHTTPClient builds the client used for every backend request. A hung
+// backend fails with a timeout instead of wedging the app: connecting is
+// bounded by connect_timeout, waiting for the response headers (which
+// includes loading the model) by response_timeout, and every pause while
+// reading the body by read_timeout. Replies that aren't streamed, which is
+// most of them, only send headers once they are done, so for those
+// response_timeout bounds the whole generation and read_timeout hardly
+// applies; it matters for the streamed replies of the JSON mode.
+func newHTTPClient(config Config) *http.Client {
+	connectTimeout := timeoutSetting(config.ConnectTimeout, DefaultConnectTimeout)
+	responseTimeout := config.ResponseTimeout
+	if responseTimeout <= 0 {
+		responseTimeout = config.FirstTokenTimeout
+	}
+	transport := &http.Transport{
+		Proxy:                 proxyFunc(config.Proxy),
+		DialContext:           (&net.Dialer{Timeout: connectTimeout, KeepAlive: 30 * time.Second}).DialContext,
+		TLSHandshakeTimeout:   connectTimeout,
+		ResponseHeaderTimeout: timeoutSetting(responseTimeout, DefaultResponseTimeout),
+		MaxIdleConns:          10,
+		IdleConnTimeout:       90 * time.Second,
+	}
+	if config.TLS != nil {
+		tlsConfig, err := buildTLSConfig(config.TLS)
+		if err != nil {
+			printError("Error loading TLS settings:", err)
+			os.Exit(1)
+		}
+		transport.TLSClientConfig = tlsConfig
+	}
+	return &http.Client{
+		Transport: &readTimeoutTransport{
+			base:    transport,
+			timeout: timeoutSetting(config.ReadTimeout, DefaultReadTimeout),
+		},
+	}
+}
+
+// TLSConfig is for backends behind a reverse proxy with an internal CA or
+// client certificate authentication.
+type TLSConfig struct {
+	CAFile             string `json:"ca_file,omitempty"`
+	CertFile           string `json:"cert_file,omitempty"`
+	KeyFile            string `json:"key_file,omitempty"`
+	InsecureSkipVerify bool   `json:"insecure_skip_verify,omitempty"`
+}
+
+func buildTLSConfig(settings *TLSConfig) (*tls.Config, error) {
+	tlsConfig := &tls.Config{InsecureSkipVerify: settings.InsecureSkipVerify}
+	if settings.InsecureSkipVerify {
+		fmt.Println("Warning: TLS certificate verification is disabled.")
+	}
+
+	if settings.CAFile != "" {
+		pem, err := ioutil.ReadFile(settings.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", settings.CAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if settings.CertFile != "" || settings.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(settings.CertFile, settings.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	return tlsConfig, nil
+}
+
+// readTimeoutTransport closes response bodies that stop delivering data for
+// longer than timeout.
+type readTimeoutTransport struct {
+	base    http.RoundTripper
+	timeout time.Duration
+}
+
+func (t *readTimeoutTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = newIdleTimeoutBody(resp.Body, t.timeout)
+	return resp, nil
+}
+
+type idleTimeoutBody struct {
+	body    io.ReadCloser
+	timeout time.Duration
+	timer   *time.Timer
+
+	mu       sync.Mutex
+	timedOut bool
+}
+
+func newIdleTimeoutBody(body io.ReadCloser, timeout time.Duration) *idleTimeoutBody {
+	b := &idleTimeoutBody{body: body, timeout: timeout}
+	b.timer = time.AfterFunc(timeout, func() {
+		b.mu.Lock()
+		b.timedOut = true
+		b.mu.Unlock()
+		body.Close()
+	})
+	return b
+}
+
+func (b *idleTimeoutBody) Read(p []byte) (int, error) {
+	n, err := b.body.Read(p)
+	b.mu.Lock()
+	timedOut := b.timedOut
+	b.mu.Unlock()
+	if timedOut {
+		return n, errReadTimeout
+	}
+	b.timer.Reset(b.timeout)
+	return n, err
+}
+
+func (b *idleTimeoutBody) Close() error {
+	b.timer.Stop()
+	return b.body.Close()
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"net/http"
+	"time"
+)
+
+const (
+	DefaultIdleMinutes = 10
+	DefaultIdlePrompt  = "The user hasn't replied for a while. Send a short follow-up message in character that carries on from the conversation, without repeating what you already said."
+
+	// idleInput is what readUserInput returns when the wait for a message
+	// ran out.
+	idleInput = "\x00idle"
+)
+
+// IdleConfig has the character follow up on its own when you don't reply
+// for AfterMinutes. MaxInARow is how many follow-ups it sends before
+// waiting for you, one unless set.
+type IdleConfig struct {
+	AfterMinutes float64 `json:"after_minutes,omitempty"`
+	Prompt       string  `json:"prompt,omitempty"`
+	MaxInARow    int     `json:"max_in_a_row,omitempty"`
+}
+
+// idleFollowUps counts the follow-ups since your last message.
+var idleFollowUps int
+
+// idleTimeout is how long to wait for a message before following up, or 0
+// to wait forever.
+func idleTimeout(config *Config) time.Duration {
+	if config.Idle == nil || rawMode || len(pendingInputs) > 0 {
+		return 0
+	}
+	limit := config.Idle.MaxInARow
+	if limit <= 0 {
+		limit = 1
+	}
+	if idleFollowUps >= limit {
+		return 0
+	}
+	minutes := config.Idle.AfterMinutes
+	if minutes <= 0 {
+		minutes = DefaultIdleMinutes
+	}
+	return time.Duration(minutes * float64(time.Minute))
+}
+
+// sendFollowUp has the character send a message unprompted, carrying on
+// from the chat so far.
+func sendFollowUp(client *http.Client, config *Config, debug bool) {
+	idleFollowUps++
+	prompt := config.Idle.Prompt
+	if prompt == "" {
+		prompt = DefaultIdlePrompt
+	}
+	messages := append(buildPrompt(config, messageHistory), Message{Role: "system", Content: prompt})
+
+	stopTyping := showTypingIndicator(config)
+	response, err := requestReply(client, config, messages, debug)
+	stopTyping()
+	if err != nil {
+		printError("\nRequest error:", err)
+		return
+	}
+	if checkReplies(config) {
+		checked, ok := checkReplyWith(client, config, "", response, func() (string, error) {
+			return requestReply(client, config, messages, debug)
+		}, debug)
+		if !ok {
+			return
+		}
+		response = checked
+	}
+	displayResponse(response, config)
+	appendMessage("assistant", response)
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	scenePrompt   = "Describe the current scene of this roleplay as a prompt for an image generator: who is there and what they look like, what they are doing, the setting, lighting and mood. Use one paragraph of comma-separated phrases, no names of real people and nothing else."
+	sceneMessages = 8
+	imageTimeout  = 5 * time.Minute
+)
+
+// ImageGenConfig is a Stable Diffusion backend for /imagine. Backend is
+// "automatic1111" (the default, also Forge and SD.Next) or "comfyui",
+// which needs Workflow: a workflow saved in API format, with "{prompt}"
+// and "{negative_prompt}" where the prompts go.
+type ImageGenConfig struct {
+	URL            string `json:"url"`
+	Backend        string `json:"backend,omitempty"`
+	Workflow       string `json:"workflow,omitempty"`
+	Style          string `json:"style,omitempty"`
+	NegativePrompt string `json:"negative_prompt,omitempty"`
+	Width          int    `json:"width,omitempty"`
+	Height         int    `json:"height,omitempty"`
+	Steps          int    `json:"steps,omitempty"`
+	// Open shows new images in the system image viewer as well.
+	Open bool `json:"open,omitempty"`
+}
+
+// handleImagineCommand illustrates the scene described, or the current one
+// as the model describes it, adding the image to the gallery.
+func handleImagineCommand(client *http.Client, config *Config, args string) {
+	gen := config.ImageGen
+	if gen == nil || gen.URL == "" {
+		fmt.Println("Set an image backend first, e.g. \"image_gen\": {\"url\": \"http://localhost:7860\"} in the config.")
+		return
+	}
+	prompt := strings.TrimSpace(args)
+	if prompt == "" {
+		if len(messageHistory) == 0 {
+			fmt.Println("There is no scene yet. Describe one using: /imagine {description}")
+			return
+		}
+		fmt.Println("Describing the scene...")
+		var err error
+		if prompt, err = describeScene(client, config); err != nil {
+			printError("Error describing the scene:", err)
+			return
+		}
+		fmt.Printf("[Scene]: %s\n", prompt)
+	}
+	if gen.Style != "" {
+		prompt = gen.Style + ", " + prompt
+	}
+
+	fmt.Println("Generating the image...")
+	ctx, cancel := context.WithTimeout(context.Background(), imageTimeout)
+	defer cancel()
+	var data []byte
+	var err error
+	switch gen.Backend {
+	case "", "automatic1111":
+		data, err = generateA1111(ctx, client, gen, prompt)
+	case "comfyui":
+		data, err = generateComfyUI(ctx, client, gen, prompt)
+	default:
+		err = fmt.Errorf("unknown image backend %q", gen.Backend)
+	}
+	if err != nil {
+		printError("Error generating the image:", err)
+		return
+	}
+
+	illustration, err := addIllustration(data, ".png", prompt)
+	if err != nil {
+		printError("Error saving the image:", err)
+		return
+	}
+	fmt.Printf("Saved %s as illustration %d.\n", illustration.Path, len(gallery))
+	if gen.Open {
+		if err := openInViewer(illustration.Path); err != nil {
+			printError("Error opening image:", err)
+		}
+	} else if !showInline(illustration.Path, config) {
+		fmt.Printf("Open it using: /gallery open %d\n", len(gallery))
+	}
+}
+
+// describeScene asks the model for an image prompt from the last few
+// messages and the character definition.
+func describeScene(client *http.Client, config *Config) (string, error) {
+	history := messageHistory
+	if len(history) > sceneMessages {
+		history = history[len(history)-sceneMessages:]
+	}
+	var transcript strings.Builder
+	for _, msg := range history {
+		speaker := "User"
+		if msg.Role == "assistant" {
+			speaker = characterDisplayName(activeCharacter)
+		}
+		fmt.Fprintf(&transcript, "%s: %s\n\n", speaker, msg.Content)
+	}
+	messages := []Message{
+		{Role: "system", Content: "Character:\n" + activeCharacter.characterPrompt()},
+		{Role: "user", Content: transcript.String() + scenePrompt},
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), titleTimeout)
+	defer cancel()
+	result, err := chatCompletion(ctx, client, config.URL, newChatMessage(config, messages))
+	if err != nil {
+		return "", err
+	}
+	return strings.Join(strings.Fields(result.Content), " "), nil
+}
+
+// postJSON sends body to url and decodes the JSON reply into v.
+func postJSON(ctx context.Context, client *http.Client, url string, body, v interface{}) error {
+	data, _ := json.Marshal(body)
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	return doJSON(client, req, v)
+}
+
+func doJSON(client *http.Client, req *http.Request, v interface{}) error {
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, _ := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s returned %s: %s", req.URL.Host, resp.Status, truncateText(string(data), 200))
+	}
+	return json.Unmarshal(data, v)
+}
+
+func generateA1111(ctx context.Context, client *http.Client, gen *ImageGenConfig, prompt string) ([]byte, error) {
+	request := map[string]interface{}{"prompt": prompt, "negative_prompt": gen.NegativePrompt}
+	if gen.Width > 0 && gen.Height > 0 {
+		request["width"], request["height"] = gen.Width, gen.Height
+	}
+	if gen.Steps > 0 {
+		request["steps"] = gen.Steps
+	}
+	var result struct {
+		Images []string `json:"images"`
+	}
+	if err := postJSON(ctx, client, strings.TrimSuffix(gen.URL, "/")+"/sdapi/v1/txt2img", request, &result); err != nil {
+		return nil, err
+	}
+	if len(result.Images) == 0 {
+		return nil, fmt.Errorf("no image was returned")
+	}
+	return base64.StdEncoding.DecodeString(result.Images[0])
+}
+
+// generateComfyUI queues the workflow and waits for its first output image.
+func generateComfyUI(ctx context.Context, client *http.Client, gen *ImageGenConfig, prompt string) ([]byte, error) {
+	if gen.Workflow == "" {
+		return nil, fmt.Errorf("the comfyui backend needs a workflow file")
+	}
+	template, err := ioutil.ReadFile(gen.Workflow)
+	if err != nil {
+		return nil, err
+	}
+	quote := func(s string) string {
+		quoted, _ := json.Marshal(s)
+		return string(quoted[1 : len(quoted)-1])
+	}
+	filled := strings.NewReplacer("{prompt}", quote(prompt), "{negative_prompt}", quote(gen.NegativePrompt)).Replace(string(template))
+	var workflow interface{}
+	if err := json.Unmarshal([]byte(filled), &workflow); err != nil {
+		return nil, fmt.Errorf("invalid workflow: %v", err)
+	}
+
+	base := strings.TrimSuffix(gen.URL, "/")
+	var queued struct {
+		PromptID string `json:"prompt_id"`
+	}
+	if err := postJSON(ctx, client, base+"/prompt", map[string]interface{}{"prompt": workflow}, &queued); err != nil {
+		return nil, err
+	}
+
+	type comfyImage struct {
+		Filename  string `json:"filename"`
+		Subfolder string `json:"subfolder"`
+		Type      string `json:"type"`
+	}
+	for {
+		var history map[string]struct {
+			Outputs map[string]struct {
+				Images []comfyImage `json:"images"`
+			} `json:"outputs"`
+		}
+		req, _ := http.NewRequestWithContext(ctx, "GET", base+"/history/"+queued.PromptID, nil)
+		if err := doJSON(client, req, &history); err != nil {
+			return nil, err
+		}
+		for _, output := range history[queued.PromptID].Outputs {
+			if len(output.Images) == 0 {
+				continue
+			}
+			image := output.Images[0]
+			query := url.Values{"filename": {image.Filename}, "subfolder": {image.Subfolder}, "type": {image.Type}}
+			req, _ := http.NewRequestWithContext(ctx, "GET", base+"/view?"+query.Encode(), nil)
+			resp, err := client.Do(req)
+			if err != nil {
+				return nil, err
+			}
+			defer resp.Body.Close()
+			if resp.StatusCode != http.StatusOK {
+				return nil, fmt.Errorf("%s returned %s", req.URL.Host, resp.Status)
+			}
+			return ioutil.ReadAll(resp.Body)
+		}
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("timed out waiting for the image")
+		case <-time.After(time.Second):
+		}
+	}
+}
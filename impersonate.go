@@ -0,0 +1,45 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+const impersonateInstructions = "Write the user's next message in this roleplay, in their voice and as their character, the way they would type it. Don't write anything for %s. Respond with only the message."
+
+// handleImpersonateCommand has the model draft the user's next message,
+// which they can send as it is, edit or throw away.
+func handleImpersonateCommand(args string, client *http.Client, config *Config, debug bool) {
+	instructions := fmt.Sprintf(impersonateInstructions, characterDisplayName(activeCharacter))
+	if persona := personaPrompt(); persona != "" {
+		instructions += "\n" + persona
+	}
+	if hint := strings.TrimSpace(args); hint != "" {
+		instructions += "\nIn the message, the user should: " + hint
+	}
+	messages := append(buildPrompt(config, messageHistory), Message{Role: "system", Content: instructions})
+
+	stopTyping := showTypingIndicator(config)
+	draft, err := requestReply(client, config, messages, debug)
+	stopTyping()
+	if err != nil {
+		printError("\nRequest error:", err)
+		return
+	}
+	draft = strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(truncateAtStop(draft, config.StopSequences)), "You:"))
+	if draft == "" {
+		fmt.Println("The model didn't write anything. Try again, maybe with a hint.")
+		return
+	}
+
+	fmt.Printf("\n[Draft]: %s\n", draft)
+	switch strings.ToLower(promptUserForInput("Send it (y), edit it (e) or discard it (n)?", "y")) {
+	case "y", "yes":
+		pendingInputs = append([]string{draft}, pendingInputs...)
+	case "e", "edit":
+		editor.draft = draft
+	default:
+		fmt.Println("Draft discarded.")
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"sync"
+)
+
+var errGenerationCancelled = errors.New("generation cancelled")
+
+// The generation in flight, if any. Ctrl-C cancels it instead of killing
+// the app; a second Ctrl-C, or one while idle, saves the session and exits.
+var (
+	generationMu        sync.Mutex
+	generationCancel    context.CancelFunc
+	generationCancelled bool
+)
+
+func startGeneration() context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+	generationMu.Lock()
+	generationCancel, generationCancelled = cancel, false
+	generationMu.Unlock()
+	return ctx
+}
+
+func finishGeneration() {
+	generationMu.Lock()
+	if generationCancel != nil {
+		generationCancel()
+	}
+	generationCancel, generationCancelled = nil, false
+	generationMu.Unlock()
+}
+
+func handleInterrupts() {
+	interrupts := make(chan os.Signal, 1)
+	signal.Notify(interrupts, os.Interrupt)
+	go func() {
+		for range interrupts {
+			if cancelGeneration() {
+				fmt.Println("\nCancelling generation... (press Ctrl-C again to quit)")
+				continue
+			}
+			quitOnInterrupt()
+		}
+	}()
+}
+
+// cancelGeneration cancels the generation in flight, reporting whether
+// there was one that wasn't already cancelled.
+func cancelGeneration() bool {
+	generationMu.Lock()
+	defer generationMu.Unlock()
+	if generationCancel == nil || generationCancelled {
+		return false
+	}
+	generationCancel()
+	generationCancelled = true
+	return true
+}
+
+// quitOnInterrupt saves the session and exits. The line editor calls it
+// directly, since Ctrl-C doesn't raise a signal while the terminal is raw.
+func quitOnInterrupt() {
+	fmt.Println()
+	autosaveSession()
+	os.Exit(0)
+}
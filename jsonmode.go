@@ -0,0 +1,197 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// jsonRequest is one line of input in --json mode. Types:
+//
+//	{"type": "message", "content": "..."}  send a message, streaming the reply
+//	{"type": "cancel"}                      cancel the reply being generated
+//	{"type": "reset"}                       start a new chat
+//	{"type": "history"}                     get the chat history
+//	{"type": "character", "name": "..."}    switch character ("" for the default)
+//	{"type": "save", "name": "..."}         save the session
+//
+// The optional id is copied to every event the request produces.
+type jsonRequest struct {
+	ID      string `json:"id,omitempty"`
+	Type    string `json:"type"`
+	Content string `json:"content,omitempty"`
+	Name    string `json:"name,omitempty"`
+}
+
+// jsonEvent is one line of output in --json mode: "ready" at startup with
+// the greeting, "delta" for each piece of a reply, "reply" with the final
+// reply, "history", "ok" and "error".
+type jsonEvent struct {
+	ID        string    `json:"id,omitempty"`
+	Type      string    `json:"type"`
+	Content   string    `json:"content,omitempty"`
+	Character string    `json:"character,omitempty"`
+	Messages  []Message `json:"messages,omitempty"`
+	Error     string    `json:"error,omitempty"`
+}
+
+// runJSONMode speaks newline-delimited JSON on stdin and stdout so the app
+// can run as a subprocess of an editor or GUI. Anything the app would
+// normally print goes to stderr instead.
+func runJSONMode(client *http.Client, config *Config, debug bool) {
+	encoder := json.NewEncoder(os.Stdout)
+	os.Stdout = os.Stderr
+	var mu sync.Mutex
+	emit := func(event jsonEvent) {
+		mu.Lock()
+		defer mu.Unlock()
+		encoder.Encode(event)
+	}
+
+	activeCharacter = loadActiveCharacter(*config)
+	applySettings(config)
+	greeting := pickGreeting(activeCharacter)
+	appendMessage("assistant", greeting)
+	emit(jsonEvent{Type: "ready", Character: characterKey(activeCharacter), Content: greeting})
+
+	// Requests are handled one at a time, except cancel, which has to get
+	// through while a reply is streaming.
+	requests := make(chan jsonRequest)
+	go func() {
+		scanner := bufio.NewScanner(os.Stdin)
+		scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+		for scanner.Scan() {
+			line := strings.TrimSpace(scanner.Text())
+			if line == "" {
+				continue
+			}
+			var req jsonRequest
+			if err := json.Unmarshal([]byte(line), &req); err != nil {
+				emit(jsonEvent{Type: "error", Error: "invalid JSON: " + err.Error()})
+				continue
+			}
+			if req.Type == "cancel" {
+				if !cancelGeneration() {
+					emit(jsonEvent{ID: req.ID, Type: "error", Error: "nothing to cancel"})
+				}
+				continue
+			}
+			requests <- req
+		}
+		close(requests)
+	}()
+
+	for req := range requests {
+		handleJSONRequest(req, emit, client, config, debug)
+	}
+	autosaveSession()
+}
+
+func handleJSONRequest(req jsonRequest, emit func(jsonEvent), client *http.Client, config *Config, debug bool) {
+	fail := func(err error) {
+		emit(jsonEvent{ID: req.ID, Type: "error", Error: err.Error()})
+	}
+
+	switch req.Type {
+	case "message":
+		if strings.TrimSpace(req.Content) == "" {
+			fail(fmt.Errorf("empty message"))
+			return
+		}
+		input := req.Content
+		if config.Safety != nil && config.Safety.CheckPrompts {
+			checked, ok := applySafetyPolicy(client, config, []Message{{Role: "user", Content: input}}, "message", debug)
+			if !ok {
+				fail(fmt.Errorf("the message was blocked by the safety policy"))
+				return
+			}
+			input = checked
+		}
+		appendMessage("user", input)
+		countEventTurn(config)
+		retrieveContext(client, config, debug)
+
+		start := time.Now()
+		reply, err := jsonReply(req.ID, emit, client, config, debug)
+		if err == nil && checkReplies(config) {
+			checked, ok := checkReply(client, config, input, reply, debug)
+			if !ok {
+				err = fmt.Errorf("the reply was blocked by the safety policy")
+			}
+			reply = checked
+		}
+		if err != nil {
+			setHistory(messageHistory[:len(messageHistory)-1])
+			fail(err)
+			return
+		}
+		acceptReply(config)
+		appendMessage("assistant", reply)
+		messageHistory[len(messageHistory)-1].Seconds = time.Since(start).Seconds()
+		emit(jsonEvent{ID: req.ID, Type: "reply", Content: reply})
+		updateAmbience(config, debug)
+		updateMood(client, config, debug)
+	case "reset":
+		resetSession()
+		applySettings(config)
+		greeting := pickGreeting(activeCharacter)
+		appendMessage("assistant", greeting)
+		emit(jsonEvent{ID: req.ID, Type: "ok", Content: greeting})
+	case "history":
+		emit(jsonEvent{ID: req.ID, Type: "history", Messages: messageHistory})
+	case "character":
+		character := defaultCharacter(*config)
+		if req.Name != "" && req.Name != "default" {
+			var err error
+			if character, err = loadCharacter(req.Name); err != nil {
+				fail(err)
+				return
+			}
+		}
+		switchCharacter(character, config)
+		emit(jsonEvent{ID: req.ID, Type: "ok", Character: characterKey(character), Content: messageHistory[len(messageHistory)-1].Content})
+	case "save":
+		if req.Name != "" {
+			sessionName = req.Name
+		} else if sessionName == "" {
+			sessionName = newSessionName()
+		}
+		if err := saveSession(captureSession()); err != nil {
+			fail(err)
+			return
+		}
+		emit(jsonEvent{ID: req.ID, Type: "ok", Content: sessionName})
+	default:
+		fail(fmt.Errorf("unknown request type %q", req.Type))
+	}
+}
+
+// jsonReply gets the reply to the history the way the chat loop does,
+// streaming it as delta events. Tool calls and GM mode need the whole reply
+// before any of it can be shown, so it comes as a single delta then.
+func jsonReply(id string, emit func(jsonEvent), client *http.Client, config *Config, debug bool) (string, error) {
+	if gmMode || len(availableTools(config)) > 0 {
+		reply, err := sendChatRequest(client, config, debug)
+		if err == nil {
+			emit(jsonEvent{ID: id, Type: "delta", Content: reply})
+		}
+		return reply, err
+	}
+
+	recordLoreActivations(config, messageHistory)
+	data := chatRequest(config, buildPrompt(config, messageHistory))
+	ctx := startGeneration()
+	reply, err := streamReply(ctx, client, config, data.Messages, func(delta string) {
+		emit(jsonEvent{ID: id, Type: "delta", Content: delta})
+	}, debug)
+	finishGeneration()
+	if err != nil {
+		return "", err
+	}
+	return applyBlocklist(client, config, data, reply, debug), nil
+}
@@ -0,0 +1,14 @@
+//go:build !unix
+
+package main
+
+import "time"
+
+const canPollKeys = false
+
+// keyPressed waits out timeout; without poll there is no way to check for
+// a key press without blocking, so the effect can't be skipped here.
+func keyPressed(fd int, timeout time.Duration) bool {
+	time.Sleep(timeout)
+	return stdinReader.Buffered() > 0
+}
@@ -0,0 +1,22 @@
+//go:build unix
+
+package main
+
+import (
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+const canPollKeys = true
+
+// keyPressed waits up to timeout for input on fd, reporting whether there
+// is some.
+func keyPressed(fd int, timeout time.Duration) bool {
+	if stdinReader.Buffered() > 0 {
+		return true
+	}
+	fds := []unix.PollFd{{Fd: int32(fd), Events: unix.POLLIN}}
+	n, err := unix.Poll(fds, int(timeout/time.Millisecond))
+	return err == nil && n > 0
+}
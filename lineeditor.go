@@ -0,0 +1,328 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"golang.org/x/term"
+)
+
+var (
+	errInputInterrupted = errors.New("input interrupted")
+	errInputIdle        = errors.New("no input")
+)
+
+const (
+	bracketedPasteOn  = "\x1b[?2004h"
+	bracketedPasteOff = "\x1b[?2004l"
+	pasteEnd          = "\x1b[201~"
+)
+
+// lineEditor reads a line with cursor movement, history and tab completion
+// when stdin is a terminal, and falls back to plain line reading otherwise.
+type lineEditor struct {
+	history  []string
+	complete func(before string) (word string, candidates []string)
+	// idle ends an empty line with errInputIdle when nothing is typed for
+	// that long. Zero waits forever.
+	idle time.Duration
+	// draft is put in the next line to edit, then cleared.
+	draft string
+}
+
+var editor = &lineEditor{complete: completeInput}
+
+func (e *lineEditor) addHistory(line string) {
+	if line == "" || len(e.history) > 0 && e.history[len(e.history)-1] == line {
+		return
+	}
+	e.history = append(e.history, line)
+}
+
+func (e *lineEditor) readLine(prompt string) (string, error) {
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		return readPlainLine(prompt)
+	}
+	state, err := term.MakeRaw(fd)
+	if err != nil {
+		return readPlainLine(prompt)
+	}
+	defer term.Restore(fd, state)
+	// Bracketed paste marks pasted text, so a multi-line paste is inserted
+	// as it is instead of each line being sent on its own.
+	fmt.Print(bracketedPasteOn)
+	defer fmt.Print(bracketedPasteOff)
+
+	s := &editState{prompt: prompt, width: terminalWidth(fd)}
+	if e.draft != "" {
+		s.buf, e.draft = []rune(e.draft), ""
+		s.pos = len(s.buf)
+	}
+	histIndex, draft := len(e.history), ""
+	lastWasTab := false
+	s.refresh()
+
+	for {
+		if e.idle > 0 && canPollKeys && len(s.buf) == 0 && !keyPressed(fd, e.idle) {
+			fmt.Print("\r\x1b[K")
+			return "", errInputIdle
+		}
+		r, _, err := stdinReader.ReadRune()
+		if err != nil {
+			fmt.Print("\r\n")
+			return "", io.EOF
+		}
+		isTab := r == '\t'
+
+		switch r {
+		case '\r', '\n':
+			s.pos = len(s.buf)
+			s.refresh()
+			fmt.Print("\r\n")
+			return string(s.buf), nil
+		case 3: // Ctrl-C
+			fmt.Print("^C\r\n")
+			return "", errInputInterrupted
+		case 4: // Ctrl-D
+			if len(s.buf) == 0 {
+				fmt.Print("\r\n")
+				return "", io.EOF
+			}
+			s.deleteForward()
+		case 127, 8: // Backspace
+			if s.pos > 0 {
+				s.pos = prevBoundary(s.buf, s.pos)
+				s.deleteForward()
+			}
+		case 1: // Ctrl-A
+			s.pos = 0
+		case 5: // Ctrl-E
+			s.pos = len(s.buf)
+		case 11: // Ctrl-K
+			s.buf = s.buf[:s.pos]
+		case 21: // Ctrl-U
+			s.buf, s.pos = s.buf[s.pos:], 0
+		case 23: // Ctrl-W
+			start := s.pos
+			for start > 0 && s.buf[start-1] == ' ' {
+				start--
+			}
+			for start > 0 && s.buf[start-1] != ' ' {
+				start--
+			}
+			s.buf = append(s.buf[:start], s.buf[s.pos:]...)
+			s.pos = start
+		case '\t':
+			word, candidates := e.complete(string(s.buf[:s.pos]))
+			switch prefix := commonPrefix(candidates); {
+			case len(candidates) == 1:
+				s.insert(strings.TrimPrefix(candidates[0], word) + " ")
+			case len(prefix) > len(word):
+				s.insert(strings.TrimPrefix(prefix, word))
+			case len(candidates) > 1 && lastWasTab:
+				fmt.Print("\r\n" + strings.Join(candidates, "  ") + "\r\n")
+				s.cursorRow = 0
+			default:
+				fmt.Print("\a")
+			}
+		case 27: // Escape sequences for the arrow, Home, End and Delete keys
+			switch readEscape() {
+			case "[A", "OA":
+				if histIndex > 0 {
+					if histIndex == len(e.history) {
+						draft = string(s.buf)
+					}
+					histIndex--
+					s.buf = []rune(e.history[histIndex])
+					s.pos = len(s.buf)
+				}
+			case "[B", "OB":
+				if histIndex < len(e.history) {
+					histIndex++
+					line := draft
+					if histIndex < len(e.history) {
+						line = e.history[histIndex]
+					}
+					s.buf = []rune(line)
+					s.pos = len(s.buf)
+				}
+			case "[C", "OC":
+				s.pos = nextBoundary(s.buf, s.pos)
+			case "[D", "OD":
+				s.pos = prevBoundary(s.buf, s.pos)
+			case "[H", "OH", "[1~", "[7~":
+				s.pos = 0
+			case "[F", "OF", "[4~", "[8~":
+				s.pos = len(s.buf)
+			case "[3~":
+				s.deleteForward()
+			case "[200~":
+				s.insert(readPaste())
+			}
+		default:
+			if r >= 32 {
+				s.insert(string(r))
+			}
+		}
+		lastWasTab = isTab
+		s.refresh()
+	}
+}
+
+// readKey reads a single key press without waiting for Enter. Without a
+// terminal it reads a line and returns its first character.
+func readKey(prompt string) (rune, error) {
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		line, err := readPlainLine(prompt)
+		if line == "" {
+			return '\r', err
+		}
+		return []rune(line)[0], nil
+	}
+	fmt.Print(prompt)
+	state, err := term.MakeRaw(fd)
+	if err != nil {
+		line, err := readPlainLine("")
+		if line == "" {
+			return '\r', err
+		}
+		return []rune(line)[0], nil
+	}
+	r, _, err := stdinReader.ReadRune()
+	term.Restore(fd, state)
+	fmt.Println()
+	if r == 3 {
+		return 0, errInputInterrupted
+	}
+	return r, err
+}
+
+// readPaste reads pasted text up to the end of the bracketed paste, with
+// line breaks as "\n".
+func readPaste() string {
+	var b strings.Builder
+	for {
+		r, _, err := stdinReader.ReadRune()
+		if err != nil {
+			break
+		}
+		b.WriteRune(r)
+		if r == '~' && strings.HasSuffix(b.String(), pasteEnd) {
+			break
+		}
+	}
+	text := strings.TrimSuffix(b.String(), pasteEnd)
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+	return strings.ReplaceAll(text, "\r", "\n")
+}
+
+func readPlainLine(prompt string) (string, error) {
+	fmt.Print(prompt)
+	line, err := stdinReader.ReadString('\n')
+	if err == io.EOF && line != "" {
+		err = nil
+	}
+	return strings.TrimRight(line, "\r\n"), err
+}
+
+func terminalWidth(fd int) int {
+	width, _, err := term.GetSize(fd)
+	if err != nil || width <= 0 {
+		return 80
+	}
+	return width
+}
+
+func commonPrefix(words []string) string {
+	if len(words) == 0 {
+		return ""
+	}
+	prefix := words[0]
+	for _, word := range words[1:] {
+		for !strings.HasPrefix(word, prefix) {
+			prefix = prefix[:len(prefix)-1]
+		}
+	}
+	return prefix
+}
+
+// editState is the line being edited. Lines longer than the terminal wrap,
+// so redrawing starts from the row the prompt is on.
+type editState struct {
+	prompt    string
+	buf       []rune
+	pos       int
+	width     int
+	cursorRow int
+}
+
+func (s *editState) insert(text string) {
+	runes := []rune(text)
+	s.buf = append(s.buf[:s.pos], append(runes, s.buf[s.pos:]...)...)
+	s.pos += len(runes)
+}
+
+// deleteForward deletes the character at the cursor, with any combining
+// marks that go with it.
+func (s *editState) deleteForward() {
+	if s.pos < len(s.buf) {
+		s.buf = append(s.buf[:s.pos], s.buf[nextBoundary(s.buf, s.pos):]...)
+	}
+}
+
+// readEscape reads the rest of an escape sequence such as "[A".
+func readEscape() string {
+	var seq []rune
+	for {
+		r, _, err := stdinReader.ReadRune()
+		if err != nil {
+			return string(seq)
+		}
+		seq = append(seq, r)
+		if len(seq) == 1 && r != '[' && r != 'O' {
+			return string(seq)
+		}
+		if len(seq) > 1 && (r >= 'A' && r <= 'Z' || r == '~') {
+			return string(seq)
+		}
+	}
+}
+
+func (s *editState) refresh() {
+	if s.cursorRow > 0 {
+		fmt.Printf("\x1b[%dA", s.cursorRow)
+	}
+	// Pasted line breaks and tabs are shown as one column each, so the
+	// layout below stays right.
+	shown := strings.NewReplacer("\n", "⏎", "\t", " ").Replace(string(s.buf))
+	fmt.Print("\r\x1b[J" + s.prompt + paint(theme.User, shown))
+
+	// Rows and columns are counted in terminal cells, since CJK characters
+	// and emoji take two and combining marks none.
+	text := append([]rune(s.prompt), s.buf...)
+	rows, cols := cellLayout(text, s.width)
+	end := len(text)
+	if end > 0 && cols[end] == 0 && rows[end] > rows[end-1] {
+		// The last row is exactly full; the terminal holds the cursor
+		// at its end until something is written, so move it down.
+		fmt.Print("\r\n")
+	}
+	endRow := rows[end]
+
+	cursor := len(text) - len(s.buf) + s.pos
+	row, col := rows[cursor], cols[cursor]
+	if endRow > row {
+		fmt.Printf("\x1b[%dA", endRow-row)
+	}
+	fmt.Print("\r")
+	if col > 0 {
+		fmt.Printf("\x1b[%dC", col)
+	}
+	s.cursorRow = row
+}
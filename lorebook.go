@@ -0,0 +1,608 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	LorebooksDir = "lorebooks"
+
+	DefaultLoreScanDepth = 4
+	MaxLoreRecursion     = 3
+)
+
+// LoreEntry is a piece of world info that is added to the prompt when one of
+// its keys appears in the recent messages. Fields follow SillyTavern's world
+// info so books round-trip; fields this app doesn't use are kept in Extra.
+type LoreEntry struct {
+	UID           int      `json:"uid"`
+	Keys          []string `json:"keys"`
+	SecondaryKeys []string `json:"secondary_keys,omitempty"`
+	// Selective entries need a secondary key to match as well.
+	Selective bool   `json:"selective,omitempty"`
+	Content   string `json:"content"`
+	Comment   string `json:"comment,omitempty"`
+	// Constant entries are always added.
+	Constant bool `json:"constant,omitempty"`
+	Disabled bool `json:"disabled,omitempty"`
+	// Entries are added in ascending Order. Position 0 puts the entry before
+	// the character definition, anything else after it.
+	Order    int `json:"order"`
+	Position int `json:"position"`
+	// ScanDepth is how many recent messages are searched for the keys,
+	// overriding the book's.
+	ScanDepth *int `json:"scan_depth,omitempty"`
+	// Higher priority entries are kept first when the book's token budget
+	// runs out.
+	Priority int `json:"priority,omitempty"`
+	// Cooldown is how many turns to wait before adding the entry again.
+	Cooldown int `json:"cooldown,omitempty"`
+	// With recursive scanning, ExcludeRecursion entries can only be
+	// triggered by the chat, and PreventRecursion entries don't trigger
+	// others.
+	ExcludeRecursion bool `json:"exclude_recursion,omitempty"`
+	PreventRecursion bool `json:"prevent_recursion,omitempty"`
+
+	Extra map[string]json.RawMessage `json:"extra,omitempty"`
+}
+
+type Lorebook struct {
+	Name        string      `json:"name"`
+	Description string      `json:"description,omitempty"`
+	ScanDepth   int         `json:"scan_depth,omitempty"`
+	TokenBudget int         `json:"token_budget,omitempty"`
+	Entries     []LoreEntry `json:"entries"`
+
+	// RecursiveScanning lets added entries trigger other entries through
+	// their content. On unless set to false.
+	RecursiveScanning *bool `json:"recursive_scanning,omitempty"`
+}
+
+func (book Lorebook) recursive() bool {
+	return book.RecursiveScanning == nil || *book.RecursiveScanning
+}
+
+func getLorebooksDir() string {
+	return filepath.Join(getConfigDir(), LorebooksDir)
+}
+
+func lorebookPath(name string) (string, error) {
+	if name == "" || name != filepath.Base(name) || strings.HasPrefix(name, ".") {
+		return "", fmt.Errorf("invalid lorebook name %q", name)
+	}
+	return filepath.Join(getLorebooksDir(), name+".json"), nil
+}
+
+func loadLorebook(name string) (Lorebook, error) {
+	path, err := lorebookPath(name)
+	if err != nil {
+		return Lorebook{}, err
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return Lorebook{}, err
+	}
+	var book Lorebook
+	if err := json.Unmarshal(data, &book); err != nil {
+		return Lorebook{}, err
+	}
+	book.Name = name
+	return book, nil
+}
+
+func saveLorebook(book Lorebook) error {
+	path, err := lorebookPath(book.Name)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return err
+	}
+	data, _ := json.MarshalIndent(book, "", "  ")
+	return ioutil.WriteFile(path, data, 0644)
+}
+
+func listLorebooks() ([]string, error) {
+	files, err := filepath.Glob(filepath.Join(getLorebooksDir(), "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(files))
+	for _, file := range files {
+		names = append(names, strings.TrimSuffix(filepath.Base(file), ".json"))
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// stEntryFields are the SillyTavern world info fields mapped onto LoreEntry.
+var stEntryFields = []string{"uid", "key", "keysecondary", "selective", "content", "comment", "constant", "disable", "order", "position", "scanDepth", "priority", "cooldown", "excludeRecursion", "preventRecursion"}
+
+// importWorldInfo reads a SillyTavern world info file.
+func importWorldInfo(data []byte, name string) (Lorebook, error) {
+	var file struct {
+		Name        string                                `json:"name"`
+		Description string                                `json:"description"`
+		ScanDepth   int                                   `json:"scan_depth"`
+		TokenBudget int                                   `json:"token_budget"`
+		Recursive   *bool                                 `json:"recursive_scanning"`
+		Entries     map[string]map[string]json.RawMessage `json:"entries"`
+	}
+	if err := json.Unmarshal(data, &file); err != nil {
+		return Lorebook{}, err
+	}
+	if file.Entries == nil {
+		return Lorebook{}, fmt.Errorf("no world info entries found")
+	}
+
+	book := Lorebook{Name: name, Description: file.Description, ScanDepth: file.ScanDepth, TokenBudget: file.TokenBudget, RecursiveScanning: file.Recursive}
+	for id, fields := range file.Entries {
+		var st struct {
+			UID          *int     `json:"uid"`
+			Key          []string `json:"key"`
+			KeySecondary []string `json:"keysecondary"`
+			Selective    bool     `json:"selective"`
+			Content      string   `json:"content"`
+			Comment      string   `json:"comment"`
+			Constant     bool     `json:"constant"`
+			Disable      bool     `json:"disable"`
+			Order        int      `json:"order"`
+			Position     int      `json:"position"`
+			ScanDepth    *int     `json:"scanDepth"`
+			Priority     int      `json:"priority"`
+			Cooldown     int      `json:"cooldown"`
+			ExcludeRec   bool     `json:"excludeRecursion"`
+			PreventRec   bool     `json:"preventRecursion"`
+		}
+		raw, _ := json.Marshal(fields)
+		if err := json.Unmarshal(raw, &st); err != nil {
+			return Lorebook{}, fmt.Errorf("entry %s: %v", id, err)
+		}
+
+		entry := LoreEntry{
+			Keys:          st.Key,
+			SecondaryKeys: st.KeySecondary,
+			Selective:     st.Selective,
+			Content:       st.Content,
+			Comment:       st.Comment,
+			Constant:      st.Constant,
+			Disabled:      st.Disable,
+			Order:         st.Order,
+			Position:      st.Position,
+			ScanDepth:     st.ScanDepth,
+			Priority:      st.Priority,
+			Cooldown:      st.Cooldown,
+
+			ExcludeRecursion: st.ExcludeRec,
+			PreventRecursion: st.PreventRec,
+		}
+		if st.UID != nil {
+			entry.UID = *st.UID
+		} else {
+			entry.UID, _ = strconv.Atoi(id)
+		}
+		for _, field := range stEntryFields {
+			delete(fields, field)
+		}
+		if len(fields) > 0 {
+			entry.Extra = fields
+		}
+		book.Entries = append(book.Entries, entry)
+	}
+	sort.Slice(book.Entries, func(i, j int) bool { return book.Entries[i].UID < book.Entries[j].UID })
+	return book, nil
+}
+
+// exportWorldInfo writes book as a SillyTavern world info file.
+func exportWorldInfo(book Lorebook) ([]byte, error) {
+	entries := map[string]map[string]interface{}{}
+	for _, entry := range book.Entries {
+		fields := map[string]interface{}{}
+		for key, value := range entry.Extra {
+			fields[key] = value
+		}
+		keys, secondary := entry.Keys, entry.SecondaryKeys
+		if keys == nil {
+			keys = []string{}
+		}
+		if secondary == nil {
+			secondary = []string{}
+		}
+		fields["uid"] = entry.UID
+		fields["key"] = keys
+		fields["keysecondary"] = secondary
+		fields["selective"] = entry.Selective
+		fields["content"] = entry.Content
+		fields["comment"] = entry.Comment
+		fields["constant"] = entry.Constant
+		fields["disable"] = entry.Disabled
+		fields["order"] = entry.Order
+		fields["position"] = entry.Position
+		fields["scanDepth"] = entry.ScanDepth
+		fields["cooldown"] = entry.Cooldown
+		fields["excludeRecursion"] = entry.ExcludeRecursion
+		fields["preventRecursion"] = entry.PreventRecursion
+		if entry.Priority != 0 {
+			fields["priority"] = entry.Priority
+		}
+		entries[strconv.Itoa(entry.UID)] = fields
+	}
+	file := map[string]interface{}{"entries": entries}
+	if book.Description != "" {
+		file["description"] = book.Description
+	}
+	if book.ScanDepth > 0 {
+		file["scan_depth"] = book.ScanDepth
+	}
+	if book.TokenBudget > 0 {
+		file["token_budget"] = book.TokenBudget
+	}
+	if book.RecursiveScanning != nil {
+		file["recursive_scanning"] = *book.RecursiveScanning
+	}
+	return json.MarshalIndent(file, "", "  ")
+}
+
+// loreActivation records why an entry was or wasn't added on the latest
+// prompt, for /lore active.
+type loreActivation struct {
+	Book   string
+	Entry  LoreEntry
+	Added  bool
+	Reason string
+}
+
+// Lorebook state for the current chat: the turn each entry was last added
+// on, for cooldowns, and what happened on the latest prompt sent.
+var (
+	loreLastAdded   = map[string]int{}
+	lastActivations []loreActivation
+)
+
+func loreEntryKey(book string, entry LoreEntry) string {
+	return book + "/" + strconv.Itoa(entry.UID)
+}
+
+// chatTurn counts the user messages so far.
+func chatTurn(history []Message) int {
+	turn := 0
+	for _, msg := range history {
+		if msg.Role == "user" {
+			turn++
+		}
+	}
+	return turn
+}
+
+// loreInjections returns the triggered entries of the active lorebooks, as
+// text to go before and after the character definition. It changes no
+// state, since prompts are also built just to be shown or measured.
+func loreInjections(config *Config, history []Message) (string, string) {
+	var triggered []loreActivation
+	for _, a := range loreActivations(config, history) {
+		if a.Added {
+			triggered = append(triggered, a)
+		}
+	}
+	sort.SliceStable(triggered, func(i, j int) bool { return triggered[i].Entry.Order < triggered[j].Entry.Order })
+
+	var before, after []string
+	for _, a := range triggered {
+		if a.Entry.Position == 0 {
+			before = append(before, a.Entry.Content)
+		} else {
+			after = append(after, a.Entry.Content)
+		}
+	}
+	return strings.Join(before, "\n"), strings.Join(after, "\n")
+}
+
+// recordLoreActivations notes which entries the prompt about to be sent
+// for history adds, for cooldowns and /lore active.
+func recordLoreActivations(config *Config, history []Message) {
+	turn := chatTurn(history)
+	activations := loreActivations(config, history)
+	for _, a := range activations {
+		if a.Added {
+			loreLastAdded[loreEntryKey(a.Book, a.Entry)] = turn
+		}
+	}
+	lastActivations = activations
+}
+
+// loreActivations works out which entries of the active lorebooks a prompt
+// for history adds, and why the others that matched are left out.
+func loreActivations(config *Config, history []Message) []loreActivation {
+	type candidate struct {
+		book    Lorebook
+		entry   LoreEntry
+		matched bool
+		reason  string
+	}
+	var candidates []*candidate
+	for _, name := range config.Lorebooks {
+		book, err := loadLorebook(name)
+		if err != nil {
+			continue
+		}
+		for _, entry := range book.Entries {
+			c := &candidate{book: book, entry: entry}
+			c.matched, c.reason = loreEntryTriggered(entry, book, history)
+			candidates = append(candidates, c)
+		}
+	}
+
+	// Entries matched so far can trigger others through their content, a
+	// bounded number of times so entries can't keep triggering each other.
+	var frontier []*candidate
+	for _, c := range candidates {
+		if c.matched {
+			frontier = append(frontier, c)
+		}
+	}
+	for step := 1; step <= MaxLoreRecursion && len(frontier) > 0; step++ {
+		var text strings.Builder
+		for _, c := range frontier {
+			if c.book.recursive() && !c.entry.PreventRecursion {
+				text.WriteString(strings.ToLower(c.entry.Content) + "\n")
+			}
+		}
+		frontier = nil
+		for _, c := range candidates {
+			if c.matched || !c.book.recursive() || c.entry.ExcludeRecursion || c.entry.Disabled || strings.TrimSpace(c.entry.Content) == "" {
+				continue
+			}
+			if ok, reason := matchLoreEntry(c.entry, text.String(), "in another entry's content"); ok {
+				c.matched, c.reason = true, reason
+				frontier = append(frontier, c)
+			}
+		}
+	}
+
+	turn := chatTurn(history)
+	var activations []loreActivation
+	for _, name := range config.Lorebooks {
+		var added []int
+		var book Lorebook
+		for _, c := range candidates {
+			if c.book.Name != name {
+				continue
+			}
+			book = c.book
+			if !c.matched {
+				if c.reason != "" {
+					activations = append(activations, loreActivation{Book: name, Entry: c.entry, Reason: c.reason})
+				}
+				continue
+			}
+			key := loreEntryKey(name, c.entry)
+			if last, seen := loreLastAdded[key]; seen && last != turn && turn-last <= c.entry.Cooldown {
+				activations = append(activations, loreActivation{Book: name, Entry: c.entry,
+					Reason: fmt.Sprintf("%s, but cooling down (%d more turn(s))", c.reason, c.entry.Cooldown-(turn-last)+1)})
+				continue
+			}
+			added = append(added, len(activations))
+			activations = append(activations, loreActivation{Book: name, Entry: c.entry, Added: true, Reason: c.reason})
+		}
+		applyTokenBudget(book, activations, added)
+	}
+	return activations
+}
+
+// applyTokenBudget drops the lowest priority entries a book added, at the
+// given indexes of activations, until the ones left fit its token budget.
+func applyTokenBudget(book Lorebook, activations []loreActivation, added []int) {
+	if book.TokenBudget <= 0 {
+		return
+	}
+	sort.SliceStable(added, func(i, j int) bool {
+		return activations[added[i]].Entry.Priority > activations[added[j]].Entry.Priority
+	})
+	used := 0
+	for _, i := range added {
+		a := &activations[i]
+		tokens := estimateTokens(a.Entry.Content)
+		if used+tokens > book.TokenBudget {
+			a.Added = false
+			a.Reason += fmt.Sprintf(", but over the book's budget of %d tokens", book.TokenBudget)
+			continue
+		}
+		used += tokens
+	}
+}
+
+// loreEntryTriggered reports whether entry matches the recent messages, and
+// why or why not. Entries that were never in the running have no reason.
+func loreEntryTriggered(entry LoreEntry, book Lorebook, history []Message) (bool, string) {
+	if entry.Disabled || strings.TrimSpace(entry.Content) == "" {
+		return false, ""
+	}
+	if entry.Constant {
+		return true, "constant"
+	}
+
+	depth := book.ScanDepth
+	if entry.ScanDepth != nil {
+		depth = *entry.ScanDepth
+	}
+	if depth <= 0 {
+		depth = DefaultLoreScanDepth
+	}
+	if len(history) > depth {
+		history = history[len(history)-depth:]
+	}
+	var text strings.Builder
+	for _, msg := range history {
+		text.WriteString(strings.ToLower(msg.Content) + "\n")
+	}
+	return matchLoreEntry(entry, text.String(), fmt.Sprintf("in the last %d message(s)", depth))
+}
+
+// matchLoreEntry checks entry's keys against scanned, which is lower case;
+// where says what was scanned.
+func matchLoreEntry(entry LoreEntry, scanned, where string) (bool, string) {
+	key := matchingKey(scanned, entry.Keys)
+	if key == "" {
+		return false, ""
+	}
+	reason := fmt.Sprintf("key %q %s", key, where)
+	if entry.Selective && len(entry.SecondaryKeys) > 0 {
+		secondary := matchingKey(scanned, entry.SecondaryKeys)
+		if secondary == "" {
+			return false, reason + ", but no secondary key"
+		}
+		reason += fmt.Sprintf(" with secondary key %q", secondary)
+	}
+	return true, reason
+}
+
+// matchingKey returns the first of keys found in text.
+func matchingKey(text string, keys []string) string {
+	for _, key := range keys {
+		if k := strings.ToLower(strings.TrimSpace(key)); k != "" && strings.Contains(text, k) {
+			return key
+		}
+	}
+	return ""
+}
+
+func displayLoreActivations() {
+	if len(lastActivations) == 0 {
+		fmt.Println("No lorebook entries matched the latest prompt.")
+		return
+	}
+	fmt.Println("\n[Lorebook Activations]:")
+	for _, a := range lastActivations {
+		marker := "-"
+		if a.Added {
+			marker = "+"
+		}
+		name := a.Entry.Comment
+		if name == "" {
+			name = strings.Join(a.Entry.Keys, ", ")
+		}
+		fmt.Printf("%s %s #%d (%s): %s\n", marker, a.Book, a.Entry.UID, name, a.Reason)
+	}
+}
+
+func handleLoreCommand(args string, config *Config) {
+	fields := strings.Fields(args)
+	usage := "Usage: /lore [list | active | import {file} [name] | export {name} {file} | on {name} | off {name} | show {name}]"
+	if len(fields) == 0 || fields[0] == "list" {
+		displayLorebooks(config)
+		if len(fields) == 0 {
+			fmt.Println(usage)
+		}
+		return
+	}
+
+	switch {
+	case fields[0] == "active":
+		displayLoreActivations()
+	case fields[0] == "import" && (len(fields) == 2 || len(fields) == 3):
+		data, err := ioutil.ReadFile(fields[1])
+		if err != nil {
+			printError("Error reading lorebook:", err)
+			return
+		}
+		name := strings.TrimSuffix(filepath.Base(fields[1]), filepath.Ext(fields[1]))
+		if len(fields) == 3 {
+			name = fields[2]
+		}
+		book, err := importWorldInfo(data, name)
+		if err != nil {
+			printError("Error importing lorebook:", err)
+			return
+		}
+		if err := saveLorebook(book); err != nil {
+			printError("Error saving lorebook:", err)
+			return
+		}
+		fmt.Printf("Imported lorebook '%s' (%d entries). Turn it on using: /lore on %s\n", book.Name, len(book.Entries), book.Name)
+	case fields[0] == "export" && len(fields) == 3:
+		book, err := loadLorebook(fields[1])
+		if err != nil {
+			printError("Error loading lorebook:", err)
+			return
+		}
+		data, _ := exportWorldInfo(book)
+		if err := ioutil.WriteFile(fields[2], data, 0644); err != nil {
+			printError("Error writing lorebook:", err)
+			return
+		}
+		fmt.Printf("Exported lorebook '%s' to %s\n", book.Name, fields[2])
+	case fields[0] == "on" && len(fields) == 2:
+		if _, err := loadLorebook(fields[1]); err != nil {
+			printError("Error loading lorebook:", err)
+			return
+		}
+		for _, name := range config.Lorebooks {
+			if name == fields[1] {
+				fmt.Printf("Lorebook '%s' is already on.\n", name)
+				return
+			}
+		}
+		config.Lorebooks = append(config.Lorebooks, fields[1])
+		saveConfig(*config)
+		fmt.Printf("Lorebook '%s' is on.\n", fields[1])
+	case fields[0] == "off" && len(fields) == 2:
+		var remaining []string
+		for _, name := range config.Lorebooks {
+			if name != fields[1] {
+				remaining = append(remaining, name)
+			}
+		}
+		config.Lorebooks = remaining
+		saveConfig(*config)
+		fmt.Printf("Lorebook '%s' is off.\n", fields[1])
+	case fields[0] == "show" && len(fields) == 2:
+		book, err := loadLorebook(fields[1])
+		if err != nil {
+			printError("Error loading lorebook:", err)
+			return
+		}
+		fmt.Printf("\n[Lorebook: %s]:\n", book.Name)
+		for _, entry := range book.Entries {
+			state := ""
+			if entry.Disabled {
+				state = " (disabled)"
+			} else if entry.Constant {
+				state = " (constant)"
+			}
+			fmt.Printf("%d. [%s]%s %s\n", entry.UID, strings.Join(entry.Keys, ", "), state, truncateText(entry.Content, 60))
+		}
+	default:
+		fmt.Println(usage)
+	}
+}
+
+func displayLorebooks(config *Config) {
+	names, err := listLorebooks()
+	if err != nil {
+		printError("Error listing lorebooks:", err)
+		return
+	}
+	if len(names) == 0 {
+		fmt.Println("No lorebooks. Import one using: /lore import {file}")
+		return
+	}
+	active := map[string]bool{}
+	for _, name := range config.Lorebooks {
+		active[name] = true
+	}
+	fmt.Println("\n[Lorebooks]:")
+	for _, name := range names {
+		marker := " "
+		if active[name] {
+			marker = "*"
+		}
+		fmt.Printf("%s %s\n", marker, name)
+	}
+}
@@ -3,15 +3,20 @@ package main
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
 	"os"
 	"path/filepath"
 	"runtime"
+	"strconv"
 	"strings"
+	"time"
 )
 
 const (
@@ -19,14 +24,35 @@ const (
 	AppVersion = "1.1.0"
 )
 
+type Message struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+	// Images are base64 encoded, for vision models.
+	Images []string `json:"images,omitempty"`
+	// ToolCalls and ToolName carry function calls and their results within
+	// a request; they aren't kept in the history.
+	ToolCalls []ToolCall `json:"tool_calls,omitempty"`
+	ToolName  string     `json:"tool_name,omitempty"`
+
+	// Seconds is how long an assistant reply took to generate.
+	Seconds float64 `json:"seconds,omitempty"`
+	// Pinned messages are kept when the history is trimmed to fit the
+	// context window.
+	Pinned bool `json:"pinned,omitempty"`
+	// Bookmark names the message for /jump.
+	Bookmark string `json:"bookmark,omitempty"`
+}
+
 type ChatMessage struct {
-	Prompt   string `json:"prompt"`
-	Model    string `json:"model"`
-	Stream   bool   `json:"stream"`
-	Messages []struct {
-		Role    string `json:"role"`
-		Content string `json:"content"`
-	} `json:"messages"`
+	Prompt   string    `json:"prompt"`
+	Model    string    `json:"model"`
+	Stream   bool      `json:"stream"`
+	Messages []Message `json:"messages"`
+
+	Options   map[string]interface{} `json:"options,omitempty"`
+	KeepAlive interface{}            `json:"keep_alive,omitempty"`
+	Format    interface{}            `json:"format,omitempty"`
+	Tools     []ToolDefinition       `json:"tools,omitempty"`
 }
 
 type Config struct {
@@ -35,78 +61,334 @@ type Config struct {
 	System     string `json:"system"`
 	Definition string `json:"definition"`
 	Greeting   string `json:"greeting"`
+	Character  string `json:"character,omitempty"`
+	// NarratorPrompt replaces the instructions for /mode narrator.
+	NarratorPrompt string `json:"narrator_prompt,omitempty"`
+	// OOCPrompt replaces the instructions sent with (OOC: ...) messages.
+	OOCPrompt string `json:"ooc_prompt,omitempty"`
+	// ExcludeOOC keeps out-of-character exchanges out of the story: the
+	// prompts after them, summaries, titles, exports, /analyze and the
+	// spectator view.
+	ExcludeOOC bool `json:"exclude_ooc,omitempty"`
+
+	Lorebooks []string `json:"lorebooks,omitempty"`
+	Favorites []string `json:"favorites,omitempty"`
+	// QuickMenu shows favorites and recent sessions at startup. On unless
+	// set to false.
+	QuickMenu *bool `json:"quick_menu,omitempty"`
+	// SuggestCharacter ("random" or "least_used") suggests a character to
+	// talk to at startup.
+	SuggestCharacter string `json:"suggest_character,omitempty"`
+	// TypingIndicator shows an animation while waiting for a reply. On
+	// unless set to false.
+	TypingIndicator *bool             `json:"typing_indicator,omitempty"`
+	Typewriter      *TypewriterConfig `json:"typewriter,omitempty"`
+	// WordWrap wraps replies to the terminal width. On unless set to false.
+	WordWrap *bool `json:"word_wrap,omitempty"`
+	// Theme is "default", "dark", "light" or "none"; Colors overrides its
+	// styles.
+	Theme  string `json:"theme,omitempty"`
+	Colors *Theme `json:"colors,omitempty"`
+	// Avatars shows the character's avatar when it's loaded, AvatarWidth
+	// cells wide. On unless set to false.
+	Avatars     *bool `json:"avatars,omitempty"`
+	AvatarWidth int   `json:"avatar_width,omitempty"`
+	// ImageProtocol is how images are drawn: "kitty", "iterm", "sixel",
+	// "ascii" or "none". By default it's picked for the terminal.
+	ImageProtocol string `json:"image_protocol,omitempty"`
+	// Formatting sets the markers for actions and speech in replies.
+	Formatting *FormatConfig `json:"formatting,omitempty"`
+	// Prompt orders and turns off the layers of the system prompt.
+	Prompt *PromptConfig `json:"prompt,omitempty"`
+	// AutoTitle names sessions using the model when they are first saved.
+	// On unless set to false.
+	AutoTitle *bool `json:"auto_title,omitempty"`
+
+	StopSequences []string `json:"stop_sequences,omitempty"`
+	Seed          *int     `json:"seed,omitempty"`
+	MaxAttempts   int      `json:"max_attempts,omitempty"`
+
+	// Timeouts in seconds; see newHTTPClient. FirstTokenTimeout is the old
+	// name of ResponseTimeout, still read from older configs.
+	ConnectTimeout    int `json:"connect_timeout,omitempty"`
+	ResponseTimeout   int `json:"response_timeout,omitempty"`
+	FirstTokenTimeout int `json:"first_token_timeout,omitempty"`
+	ReadTimeout       int `json:"read_timeout,omitempty"`
+
+	// Storage is "files" (the default) or "sqlite", with the database at
+	// Database or char-chat.db in the config directory.
+	Storage  string `json:"storage,omitempty"`
+	Database string `json:"database,omitempty"`
+
+	Proxy string     `json:"proxy,omitempty"`
+	TLS   *TLSConfig `json:"tls,omitempty"`
+
+	// Options and KeepAlive are forwarded to Ollama as they are, e.g.
+	// {"num_ctx": 8192, "num_gpu": 99} and "30m".
+	Options   map[string]interface{} `json:"options,omitempty"`
+	KeepAlive interface{}            `json:"keep_alive,omitempty"`
+
+	// SafeMode keeps the roleplay suitable for all ages: the system prompt
+	// asks for it, and with a safety config replies are checked and
+	// regenerated when flagged unless the policy says otherwise.
+	SafeMode bool `json:"safe_mode,omitempty"`
+	// Blocklist keeps phrases the model overuses out of replies.
+	Blocklist *BlocklistConfig `json:"blocklist,omitempty"`
+
+	SpectatorAddr string               `json:"spectator_addr,omitempty"`
+	Safety        *SafetyConfig        `json:"safety,omitempty"`
+	Quotas        *QuotaConfig         `json:"quotas,omitempty"`
+	Companion     *CompanionConfig     `json:"companion,omitempty"`
+	HomeAssistant *HomeAssistantConfig `json:"home_assistant,omitempty"`
+	Ambience      *AmbienceConfig      `json:"ambience,omitempty"`
+	Mood          *MoodConfig          `json:"mood,omitempty"`
+	Relationship  *RelationshipConfig  `json:"relationship,omitempty"`
+	Events        *EventsConfig        `json:"events,omitempty"`
+	Clock         *ClockConfig         `json:"clock,omitempty"`
+	Idle          *IdleConfig          `json:"idle,omitempty"`
+	TTS           *TTSConfig           `json:"tts,omitempty"`
+	STT           *STTConfig           `json:"stt,omitempty"`
+	ImageGen      *ImageGenConfig      `json:"image_gen,omitempty"`
+	Embeddings    *EmbeddingsConfig    `json:"embeddings,omitempty"`
+	WebSearch     *WebSearchConfig     `json:"web_search,omitempty"`
+	// Tools the model can call; see ToolConfig. DiceTool offers dice
+	// rolling without declaring any.
+	Tools    []ToolConfig `json:"tools,omitempty"`
+	DiceTool bool         `json:"dice_tool,omitempty"`
+
+	// ContextStrategy is how the chat is shortened when it outgrows the
+	// context window: "ask" (the default), "truncate-oldest", "summarize"
+	// or "keep-pinned+recent". /context changes it for one chat.
+	ContextStrategy string `json:"context_strategy,omitempty"`
+
+	// ShowUsage prints the token counts of each reply after it.
+	ShowUsage bool `json:"show_usage,omitempty"`
+	// ShowSpeed prints how fast each reply was generated.
+	ShowSpeed bool `json:"show_speed,omitempty"`
+	// ShowContext prints how full the context window is after each reply.
+	ShowContext bool `json:"show_context,omitempty"`
+	// Prices are per model, for /usage.
+	Prices map[string]ModelPrice `json:"prices,omitempty"`
 }
 
-var messageHistory []struct {
-	Role    string `json:"role"`
-	Content string `json:"content"`
-}
+var messageHistory []Message
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "eval" {
+		runEval(os.Args[2:])
+		return
+	}
+
 	debug := flag.Bool("debug", false, "Enable debug")
+	serve := flag.String("serve", "", "Serve the chat API on the given address (e.g. :8080)")
+	homeAssistant := flag.String("homeassistant", "", "Serve an Ollama-compatible conversation agent for Home Assistant on the given address")
+	companion := flag.Bool("companion", false, "Run in the background and send proactive desktop notifications")
+	jsonMode := flag.Bool("json", false, "Read JSON requests from stdin and write JSON events to stdout, one per line")
 	flag.Parse()
 
 	setupDirectories()
 	config := loadConfig()
-	client := &http.Client{}
+	initTheme(config)
+	initStorage(config)
+	client := newHTTPClient(config)
+	titleSession = newTitler(client, &config)
+	ttsSpeaker = newSpeaker(client, &config)
+	refreshCapabilities = newCapabilityRefresher(client, &config)
+
+	if *serve != "" {
+		runServer(*serve, config, client, *debug)
+		return
+	}
+
+	backendCaps = probeCapabilities(client, &config)
+	warnUnsupportedOptions(&config)
+	checkPromptConfig(&config)
+	if *debug {
+		displayCapabilities(&config)
+	}
+
+	if *homeAssistant != "" {
+		runHomeAssistant(*homeAssistant, config, client, *debug)
+		return
+	}
+
+	if *companion {
+		runCompanion(client, &config, *debug)
+		return
+	}
 
-	displayGreeting(config.Greeting)
+	if *jsonMode {
+		runJSONMode(client, &config, *debug)
+		return
+	}
+
+	activeCharacter = loadActiveCharacter(config)
+	applySettings(&config)
+	displaySuggestion(&config)
+	if !startupQuickMenu(&config) {
+		showAvatar(activeCharacter, &config)
+		displayGreeting(pickGreeting(activeCharacter), &config)
+	}
+	deliverCompanionInbox()
+	handleInterrupts()
 
+	env := &commandEnv{client: client, config: &config, debug: *debug}
 	for {
+		editor.idle = idleTimeout(&config)
 		userInput := readUserInput()
 		if userInput == "exit" || userInput == "quit" {
+			autosaveSession()
 			break
 		}
+		if userInput == idleInput {
+			sendFollowUp(client, &config, *debug)
+			continue
+		}
 
-		if strings.HasPrefix(userInput, "/config") {
-			handleConfigCommand(userInput, &config)
+		if runCommand(userInput, env) {
 			continue
 		}
 
-		if userInput == "/ver" {
-			displayVersion()
+		if rawMode {
+			sendRawMessage(client, &config, userInput, *debug)
 			continue
 		}
 
-		if strings.HasPrefix(userInput, "/hist") {
-			showHistory(strings.TrimSpace(strings.TrimPrefix(userInput, "/hist")))
+		userInput, ok := guardInputLength(client, &config, userInput, *debug)
+		if !ok {
 			continue
 		}
 
-		messageHistory = append(messageHistory, struct {
-			Role    string `json:"role"`
-			Content string `json:"content"`
-		}{Role: "user", Content: userInput})
+		if config.Safety != nil && config.Safety.CheckPrompts {
+			checked, ok := applySafetyPolicy(client, &config, []Message{{Role: "user", Content: userInput}}, "message", *debug)
+			if !ok {
+				continue
+			}
+			userInput = checked
+		}
 
-		response := sendChatRequest(client, config.URL, config.Model, config.System, config.Definition, *debug)
-		displayResponse(response)
+		appendMessage("user", userInput)
+		attachPendingImages()
+		countEventTurn(&config)
+		idleFollowUps = 0
+		retrieveContext(client, &config, *debug)
 
-		messageHistory = append(messageHistory, struct {
-			Role    string `json:"role"`
-			Content string `json:"content"`
-		}{Role: "assistant", Content: response})
+		if !ensureContextFits(client, &config, *debug) {
+			setHistory(messageHistory[:len(messageHistory)-1])
+			// The rest of a split message makes no sense without this part.
+			pendingInputs = nil
+			continue
+		}
+		warnTruncation(&config)
+
+		start := time.Now()
+		stopTyping := showTypingIndicator(&config)
+		response, err := sendChatRequest(client, &config, *debug)
+		stopTyping()
+		if errors.Is(err, errContextOverflow) {
+			notice("\n[Context]: The backend reports that the conversation is too long.\n")
+			if offerContextFixes(client, &config, *debug) {
+				stopTyping = showTypingIndicator(&config)
+				response, err = sendChatRequest(client, &config, *debug)
+				stopTyping()
+			}
+		}
+		if err != nil {
+			printError("\nRequest error:", err)
+			setHistory(messageHistory[:len(messageHistory)-1])
+			continue
+		}
+		if checkReplies(&config) {
+			checked, ok := checkReply(client, &config, userInput, response, *debug)
+			if !ok {
+				setHistory(messageHistory[:len(messageHistory)-1])
+				continue
+			}
+			response = checked
+		}
+		displayResponse(response, &config)
+		elapsed := time.Since(start)
+		if config.ShowUsage {
+			displayReplyUsage(&config, lastReply)
+		}
+		if config.ShowSpeed {
+			displayReplySpeed(lastReply, elapsed)
+		}
+		acceptReply(&config)
+
+		appendMessage("assistant", response)
+		messageHistory[len(messageHistory)-1].Seconds = elapsed.Seconds()
+		if config.ShowContext {
+			displayContextMeter(&config)
+		}
+		updateAmbience(&config, *debug)
+		updateMood(client, &config, *debug)
 	}
 }
 
-func handleConfigCommand(userInput string, config *Config) {
-	args := strings.Split(userInput, " ")
-	if len(args) > 1 && args[1] != "" {
-		editConfigOption(args[1], config)
+// acceptReply applies what a reply changes once it is accepted: the game
+// state, the relationship meter, the random event and the in-fiction clock.
+func acceptReply(config *Config) {
+	applyPendingGMTurn()
+	applyPendingAffinity(config)
+	pendingEvent = ""
+	advanceClock(config)
+}
+
+// discardReply drops what a reply that isn't kept asked for.
+func discardReply() {
+	pendingGMTurn = nil
+	pendingAffinity = 0
+}
+
+func handleSeedCommand(option string, config *Config) {
+	switch option {
+	case "":
+		if config.Seed == nil {
+			fmt.Println("Seed: random")
+		} else {
+			fmt.Printf("Seed: %d\n", *config.Seed)
+		}
+		fmt.Println("Set it using: /seed {number|random}")
+		return
+	case "random":
+		config.Seed = nil
+	default:
+		seed, err := strconv.Atoi(option)
+		if err != nil {
+			fmt.Println("Invalid seed. Use a whole number or 'random'.")
+			return
+		}
+		config.Seed = &seed
+	}
+
+	saveConfig(*config)
+	fmt.Println("Config updated successfully.")
+}
+
+func handleConfigCommand(option string, client *http.Client, config *Config) {
+	if fields := strings.Fields(option); len(fields) > 0 {
+		editConfigOption(fields[0], client, config)
 	} else {
 		displayCurrentConfig(config)
 	}
 }
 
-func editConfigOption(configOption string, config *Config) {
+func editConfigOption(configOption string, client *http.Client, config *Config) {
 	switch configOption {
 	case "url":
 		config.URL = promptUserForInput("Enter new URL", config.URL)
+		backendCaps = probeCapabilities(client, config)
 	case "model":
 		config.Model = promptUserForInput("Enter new Model", config.Model)
-	case "definition":
-		config.Definition = promptUserForInput("Enter new Definition", config.Definition)
-	case "greeting":
-		config.Greeting = promptUserForInput("Enter new Greeting", config.Greeting)
+		if configured != nil {
+			// A model picked here is the user's, not the character's.
+			configured.Model = config.Model
+		}
+		backendCaps = probeCapabilities(client, config)
+	case "definition", "greeting":
+		editCharacterOption(configOption, config)
+		return
 	default:
 		fmt.Println("Invalid configuration option. Available options: url, model, definition, greeting.")
 		return
@@ -116,12 +398,45 @@ func editConfigOption(configOption string, config *Config) {
 	fmt.Println("Config updated successfully.")
 }
 
+// editCharacterOption edits the definition or greeting of the active
+// character, which lives in the config only for the default character.
+func editCharacterOption(configOption string, config *Config) {
+	character := activeCharacter
+	if configOption == "definition" {
+		character.Definition = promptUserForInput("Enter new Definition", character.Definition)
+	} else {
+		character.Greeting = promptUserForInput("Enter new Greeting", character.Greeting)
+	}
+
+	if character.Name == "" {
+		config.Definition, config.Greeting = character.Definition, character.Greeting
+		saveConfig(*config)
+	} else if err := saveCharacter(character); err != nil {
+		printError("Error saving character:", err)
+		return
+	}
+	activeCharacter = character
+	fmt.Println("Config updated successfully.")
+}
+
 func displayCurrentConfig(config *Config) {
 	fmt.Println("\n[Current Configuration]:")
 	fmt.Printf("URL: %s\n", config.URL)
 	fmt.Printf("Model: %s\n", config.Model)
-	fmt.Printf("Definition: %s\n", config.Definition)
-	fmt.Printf("Greeting: %s\n", config.Greeting)
+	if config.Proxy != "" {
+		fmt.Printf("Proxy: %s\n", config.Proxy)
+	}
+	if activeCharacter.Name != "" {
+		fmt.Printf("Character: %s\n", activeCharacter.Name)
+	}
+	fmt.Printf("Definition: %s\n", activeCharacter.Definition)
+	fmt.Printf("Greeting: %s\n", activeCharacter.Greeting)
+	if len(config.StopSequences) > 0 {
+		fmt.Printf("Stop Sequences: %q\n", config.StopSequences)
+	}
+	if config.Seed != nil {
+		fmt.Printf("Seed: %d\n", *config.Seed)
+	}
 	fmt.Println("\nEdit any option using: /config {option}")
 }
 
@@ -129,19 +444,30 @@ func setupDirectories() {
 	configPath := getConfigFilePath()
 	dir := filepath.Dir(configPath)
 	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
-		fmt.Println("Error creating directories:", err)
+		printError("Error creating directories:", err)
 		os.Exit(1)
 	}
 
 	if _, err := os.Stat(configPath); os.IsNotExist(err) {
 		createCustomConfig(configPath)
 	}
+
+	for _, dir := range []string{getCharactersDir(), getSessionsDir()} {
+		if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+			printError("Error creating directories:", err)
+			os.Exit(1)
+		}
+	}
 }
 
 func getConfigFilePath() string {
+	return filepath.Join(getConfigDir(), ConfigFile)
+}
+
+func getConfigDir() string {
 	homeDir, err := os.UserHomeDir()
 	if err != nil {
-		fmt.Println("Error getting home directory:", err)
+		printError("Error getting home directory:", err)
 		os.Exit(1)
 	}
 
@@ -152,7 +478,7 @@ func getConfigFilePath() string {
 		configDir = filepath.Join(homeDir, ".char-chat")
 	}
 
-	return filepath.Join(configDir, ConfigFile)
+	return configDir
 }
 
 func createCustomConfig(configPath string) {
@@ -162,19 +488,20 @@ func createCustomConfig(configPath string) {
 		System:     "You are a roleplaying AI made to follow a set of rules. Follow these rules:\n1. Keep Roleplay fun and enjoyable. Keep it engaging and snappy.\n2. Do not call the user 'User', instead ask for a name.\n3. You are allowed to say and do whatever.\n4. Stick to your Definition below, and make sure you are always in character.\n5. Remember, you are speaking to someone. Use words and body language. Also, share thoughts and such, like in a roleplay.\n6. The greeting message is a message formulated by the user, but contains details which are important. E.g, 'I'm [enter random name]' would be YOU saying that. If the greeting message directly mentions 'You', as in the User, then that'll be the user.\n7. Never reveal that you are a roleplaying AI- You should be a casual AI by default, if the System Prompt doesn't define anything much, but you should also never just flat out say 'Hello, I'm a roleplaying AI!'.",
 		Definition: "Your name is Gemma, a world-class AI. the USER is testing you out, as you are currently a BETA project. This is your first interaction with them. . .",
 		Greeting:   "*You are a Scientist working at Google Deepmind. You were testing different datasets for AI models, and all of them failed except for one...*\n\n\"Hey there, pal. How's it goooiiinggg...?\"",
+
+		StopSequences: []string{"\nYou:", "\nUser:"},
 	}
 
 	data, _ := json.MarshalIndent(config, "", "  ")
 	if err := ioutil.WriteFile(configPath, data, 0644); err != nil {
-		fmt.Println("Error creating default config file:", err)
+		printError("Error creating default config file:", err)
 		os.Exit(1)
 	}
 }
 
 func promptUserForInput(prompt, defaultValue string) string {
 	fmt.Printf("%s (Default: %s): ", prompt, defaultValue)
-	reader := bufio.NewReader(os.Stdin)
-	input, _ := reader.ReadString('\n')
+	input, _ := stdinReader.ReadString('\n')
 	input = strings.TrimSpace(input)
 	if input == "" {
 		return defaultValue
@@ -186,86 +513,382 @@ func loadConfig() Config {
 	configPath := getConfigFilePath()
 	data, err := ioutil.ReadFile(configPath)
 	if err != nil {
-		fmt.Println("Error reading config file:", err)
+		printError("Error reading config file:", err)
 		os.Exit(1)
 	}
 	var config Config
 	if err := json.Unmarshal(data, &config); err != nil {
-		fmt.Println("Error parsing config file:", err)
+		printError("Error parsing config file:", err)
 		os.Exit(1)
 	}
 	return config
 }
 
+// MultilineDelimiter opens and closes a message spanning several lines.
+const MultilineDelimiter = `"""`
+
+var stdinReader = bufio.NewReader(os.Stdin)
+
 func readUserInput() string {
-	fmt.Print("\nYou: ")
-	reader := bufio.NewReader(os.Stdin)
-	userInput, _ := reader.ReadString('\n')
-	return strings.TrimSpace(userInput)
+	fmt.Println()
+	if len(pendingInputs) > 0 {
+		input := pendingInputs[0]
+		pendingInputs = pendingInputs[1:]
+		fmt.Println("You: " + input)
+		return input
+	}
+	userInput, err := editor.readLine("You: ")
+	editor.idle = 0
+	if err == errInputInterrupted {
+		quitOnInterrupt()
+	}
+	if err == errInputIdle {
+		return idleInput
+	}
+	if err == io.EOF && userInput == "" {
+		// Ctrl-D
+		fmt.Println()
+		return "exit"
+	}
+	userInput = strings.TrimSpace(userInput)
+	editor.addHistory(userInput)
+	if strings.HasPrefix(userInput, MultilineDelimiter) {
+		return readMultilineInput(strings.TrimPrefix(userInput, MultilineDelimiter))
+	}
+	return userInput
 }
 
-func sendChatRequest(client *http.Client, url, model, system, definition string, debug bool) string {
-	data := ChatMessage{
-		Prompt: "",
-		Model:  model,
-		Stream: false,
-		Messages: append([]struct {
-			Role    string `json:"role"`
-			Content string `json:"content"`
-		}{
-			{Role: "system", Content: system + "\n" + definition},
-		}, messageHistory...),
+// readMultilineInput reads lines until one ends with the closing delimiter,
+// keeping the line breaks, so long openers can be sent as one message.
+func readMultilineInput(first string) string {
+	var lines []string
+	line := first
+	for {
+		if strings.HasSuffix(line, MultilineDelimiter) {
+			lines = append(lines, strings.TrimSuffix(line, MultilineDelimiter))
+			break
+		}
+		lines = append(lines, line)
+
+		next, err := editor.readLine("... ")
+		if err == errInputInterrupted {
+			quitOnInterrupt()
+		}
+		if err == io.EOF && next == "" {
+			fmt.Println()
+			break
+		}
+		line = next
 	}
+	return strings.TrimSpace(strings.Join(lines, "\n"))
+}
+
+func sendChatRequest(client *http.Client, config *Config, debug bool) (string, error) {
+	if gmMode {
+		return requestGMTurn(client, config, debug)
+	}
+	recordLoreActivations(config, messageHistory)
+	data := chatRequest(config, buildPrompt(config, messageHistory))
+	reply, err := completeReply(client, config, data, debug)
+	if err != nil {
+		return "", err
+	}
+	return applyBlocklist(client, config, data, reply, debug), nil
+}
+
+// chatRequest is the request for a roleplay reply to messages, with the
+// tools the model may call.
+func chatRequest(config *Config, messages []Message) ChatMessage {
+	data := newChatMessage(config, messages)
+	data.Tools = toolDefinitions(availableTools(config))
+	return data
+}
+
+func requestReply(client *http.Client, config *Config, messages []Message, debug bool) (string, error) {
+	return completeReply(client, config, newChatMessage(config, messages), debug)
+}
+
+// completeReply sends the request, running the tools the model calls and
+// asking again with their results until it replies.
+func completeReply(client *http.Client, config *Config, data ChatMessage, debug bool) (string, error) {
+	ctx := startGeneration()
+	defer finishGeneration()
+
+	result, err := chatCompletionWithRetry(ctx, client, config, data, debug)
+	for round := 0; err == nil && len(result.ToolCalls) > 0 && round < maxToolRounds; round++ {
+		data.Messages = append(append(copyHistory(data.Messages),
+			Message{Role: "assistant", Content: result.Content, ToolCalls: result.ToolCalls}),
+			runToolCalls(client, config, result.ToolCalls, debug)...)
+		result, err = chatCompletionWithRetry(ctx, client, config, data, debug)
+	}
+	if err != nil {
+		return "", err
+	}
+	lastReply = result
+	content := takeRelationshipTag(truncateAtStop(result.Content, config.StopSequences))
+	if deduped, removed := dedupReply(content); removed > 0 {
+		if debug {
+			notice("[Debug] Dedup filter removed %d repeated sentence(s) or paragraph(s).\n", removed)
+		}
+		content = deduped
+	}
+	if content == "" {
+		return "", errors.New("no response content received")
+	}
+	return content, nil
+}
+
+// ChatResult is a completed reply along with the token counts the backend
+// reported for it.
+type ChatResult struct {
+	Content          string
+	ToolCalls        []ToolCall
+	PromptTokens     int
+	CompletionTokens int
+
+	// Timings reported by Ollama: generating the reply, evaluating the
+	// prompt, loading the model and the whole request.
+	EvalDuration       time.Duration
+	PromptEvalDuration time.Duration
+	LoadDuration       time.Duration
+	TotalDuration      time.Duration
+}
+
+// ollamaTimings are the duration fields of an Ollama response, in
+// nanoseconds.
+type ollamaTimings struct {
+	EvalDuration       int64 `json:"eval_duration"`
+	PromptEvalDuration int64 `json:"prompt_eval_duration"`
+	LoadDuration       int64 `json:"load_duration"`
+	TotalDuration      int64 `json:"total_duration"`
+}
+
+func (t ollamaTimings) apply(result *ChatResult) {
+	result.EvalDuration = time.Duration(t.EvalDuration)
+	result.PromptEvalDuration = time.Duration(t.PromptEvalDuration)
+	result.LoadDuration = time.Duration(t.LoadDuration)
+	result.TotalDuration = time.Duration(t.TotalDuration)
+}
+
+// lastReply is the result behind the latest chat reply, for show_usage.
+var lastReply ChatResult
+
+// newChatMessage builds a request for messages using the model and backend
+// options from config.
+func newChatMessage(config *Config, messages []Message) ChatMessage {
+	return ChatMessage{
+		Prompt:    "",
+		Model:     config.Model,
+		Stream:    false,
+		Messages:  messages,
+		Options:   chatOptions(config),
+		KeepAlive: config.KeepAlive,
+	}
+}
 
+func chatCompletion(ctx context.Context, client *http.Client, url string, data ChatMessage) (ChatResult, error) {
 	jsonData, _ := json.Marshal(data)
-	req, _ := http.NewRequest("POST", url, bytes.NewBuffer(jsonData))
+	req, _ := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBuffer(jsonData))
 	req.Header.Set("Content-Type", "application/json")
 
 	resp, err := client.Do(req)
+	if ctx.Err() != nil {
+		return ChatResult{}, errGenerationCancelled
+	}
 	if err != nil {
-		return fmt.Sprintf("Request error: %v", err)
+		return ChatResult{}, requestError(err)
 	}
 	defer resp.Body.Close()
 
-	body, _ := ioutil.ReadAll(resp.Body)
-	var response map[string]interface{}
+	body, err := ioutil.ReadAll(resp.Body)
+	if ctx.Err() != nil {
+		return ChatResult{}, errGenerationCancelled
+	}
+	if err != nil {
+		return ChatResult{}, requestError(err)
+	}
+	var response struct {
+		Message         Message `json:"message"`
+		PromptEvalCount int     `json:"prompt_eval_count"`
+		EvalCount       int     `json:"eval_count"`
+		Error           string  `json:"error"`
+		ollamaTimings
+	}
 	_ = json.Unmarshal(body, &response)
 
-	if message, ok := response["message"].(map[string]interface{}); ok {
-		if content, ok := message["content"].(string); ok {
-			return content
+	if resp.StatusCode != http.StatusOK {
+		return ChatResult{}, backendError(resp, response.Error)
+	}
+
+	result := ChatResult{
+		Content:          response.Message.Content,
+		ToolCalls:        response.Message.ToolCalls,
+		PromptTokens:     response.PromptEvalCount,
+		CompletionTokens: response.EvalCount,
+	}
+	response.ollamaTimings.apply(&result)
+	usage.record(data.Model, result)
+	return result, nil
+}
+
+// backendError describes a failed response, classifying it as a context
+// overflow or a retryable server error where it is one.
+func backendError(resp *http.Response, message string) error {
+	err := fmt.Errorf("backend returned %s", resp.Status)
+	if message != "" {
+		err = fmt.Errorf("backend returned %s: %s", resp.Status, message)
+	}
+	if isContextOverflowMessage(message) {
+		return fmt.Errorf("%w: %s", errContextOverflow, message)
+	}
+	if resp.StatusCode >= 500 {
+		return &retryableError{err}
+	}
+	return err
+}
+
+// chatOptions returns the backend options for a chat request: the options
+// map from the config less those the backend doesn't support, overridden by
+// the dedicated config fields.
+func chatOptions(config *Config) map[string]interface{} {
+	options := map[string]interface{}{}
+	for key, value := range config.Options {
+		if supportsOption(key) {
+			options[key] = value
 		}
 	}
-	return "No response content received."
+	if len(config.StopSequences) > 0 {
+		options["stop"] = config.StopSequences
+	}
+	if config.Seed != nil {
+		options["seed"] = *config.Seed
+	}
+	return options
 }
 
-func displayResponse(response string) {
-	fmt.Printf("\nChatbot: %s\n", response)
+// truncateAtStop cuts a reply at the first stop sequence, in case the
+// backend ignored the stop option and kept writing the user's lines.
+func truncateAtStop(content string, stops []string) string {
+	for _, stop := range stops {
+		if i := strings.Index(content, stop); i >= 0 {
+			content = content[:i]
+		}
+	}
+	return strings.TrimSpace(content)
+}
+
+func appendMessage(role, content string) {
+	messageHistory = append(messageHistory, Message{Role: role, Content: content})
+	spectators.publish(messageHistory)
+}
+
+func setHistory(history []Message) {
+	messageHistory = history
+	spectators.publish(messageHistory)
+}
+
+// resetSession clears all per-chat state before a new chat starts.
+func resetSession() {
+	setHistory(nil)
+	checkpoints = map[string][]Message{}
+	branches = map[string][]Message{}
+	currentBranch = MainBranch
+	authorsNote = AuthorsNote{}
+	gameState = map[string]interface{}{}
+	pendingGMTurn = nil
+	gallery = nil
+	sessionTags = nil
+	pendingInputs = nil
+	pendingImages = nil
+	attachments, retrievedChunks = nil, nil
+	characterMood = ""
+	relationshipScore, pendingAffinity = nil, 0
+	pendingEvent, turnsSinceEvent = "", 0
+	worldTime, timeSkipped = nil, 0
+	chatMode = ""
+	chatScenario = ""
+	chatOverrides = ChatOverrides{}
+	chatContextStrategy = ""
+	chatSummary = ""
+	chatMemories = nil
+	examplesDropped = false
+	usage.resetSession()
+	loreLastAdded, lastActivations = map[string]int{}, nil
+	sessionName, sessionCreated = "", time.Now()
+}
+
+func displayResponse(response string, config *Config) {
+	label := chatLabel()
+	fmt.Print("\n" + label)
+	typewrite(config.Typewriter, paintReply(wrapLabeled(config, response, label)))
+	fmt.Println()
+	if ttsSpeaker != nil {
+		ttsSpeaker.speak(response)
+	}
 }
 
 func saveConfig(config Config) {
 	configPath := getConfigFilePath()
-	data, _ := json.MarshalIndent(config, "", "  ")
+	data, _ := json.MarshalIndent(userConfig(config), "", "  ")
 	_ = ioutil.WriteFile(configPath, data, 0644)
 }
 
-func displayGreeting(greeting string) {
-	fmt.Printf("\nChatbot: %s\n", greeting)
-	messageHistory = append(messageHistory, struct {
-		Role    string `json:"role"`
-		Content string `json:"content"`
-	}{Role: "assistant", Content: greeting})
+func displayGreeting(greeting string, config *Config) {
+	fmt.Printf("\n%s%s\n", replyLabel, paintReply(wrapReply(config, greeting)))
+	if ttsSpeaker != nil {
+		ttsSpeaker.speak(greeting)
+	}
+	appendMessage("assistant", greeting)
 }
 
 func displayVersion() {
 	fmt.Printf("\nApp Version: %s\n", AppVersion)
 }
 
-func showHistory(option string) {
-	fmt.Println("\n[History]:")
-	for _, msg := range messageHistory {
-		if option == "user" && msg.Role == "user" || option == "assistant" && msg.Role == "assistant" || option == "" {
-			fmt.Printf("[%s]: %s\n", strings.Title(msg.Role), msg.Content)
+// showHistory handles /hist [user | assistant] [last N | N-M], paging the
+// output when it is longer than the terminal.
+func showHistory(args string) {
+	const usage = "Usage: /hist [user | assistant] [last {count} | {from}-{to}]"
+	role, from, to := "", 1, len(messageHistory)
+	fields := strings.Fields(args)
+	if len(fields) > 0 && (fields[0] == "user" || fields[0] == "assistant") {
+		role, fields = fields[0], fields[1:]
+	}
+	switch {
+	case len(fields) == 0:
+	case len(fields) == 2 && fields[0] == "last":
+		n, err := strconv.Atoi(fields[1])
+		if err != nil || n < 1 {
+			fmt.Println(usage)
+			return
+		}
+		from = max(len(messageHistory)-n+1, 1)
+	case len(fields) == 1 && strings.Contains(fields[0], "-"):
+		a, b, _ := strings.Cut(fields[0], "-")
+		var errA, errB error
+		from, errA = strconv.Atoi(a)
+		to, errB = strconv.Atoi(b)
+		if errA != nil || errB != nil || from < 1 || to < from {
+			fmt.Println(usage)
+			return
+		}
+		to = min(to, len(messageHistory))
+	default:
+		fmt.Println(usage)
+		return
+	}
+
+	var b strings.Builder
+	b.WriteString("\n[History]:\n")
+	for i := from - 1; i < to; i++ {
+		msg := messageHistory[i]
+		if role == "" || msg.Role == role {
+			pin := ""
+			if msg.Pinned {
+				pin = " (pinned)"
+			}
+			fmt.Fprintf(&b, "%d. [%s]%s: %s%s\n", i+1, strings.Title(msg.Role), pin, msg.Content, imageNote(msg))
 		}
 	}
+	page(b.String())
 }
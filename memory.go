@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// chatMemories are facts /remember keeps in every prompt, however far back
+// in the chat they came up. They are saved with the session.
+var chatMemories []string
+
+// memoryPrompt is the system prompt addition for the remembered facts.
+func memoryPrompt() string {
+	if len(chatMemories) == 0 {
+		return ""
+	}
+	lines := make([]string, len(chatMemories))
+	for i, fact := range chatMemories {
+		lines[i] = "- " + fact
+	}
+	return "Facts to remember about this story:\n" + strings.Join(lines, "\n")
+}
+
+// handleRememberCommand handles /remember {fact}.
+func handleRememberCommand(args string) {
+	fact := strings.TrimSpace(args)
+	if fact == "" {
+		fmt.Println("Usage: /remember {fact}, e.g. /remember The user's sister is named Kira")
+		return
+	}
+	chatMemories = append(chatMemories, fact)
+	fmt.Printf("Remembered (%d). It goes in every prompt from now on.\n", len(chatMemories))
+}
+
+// handleMemoriesCommand lists the remembered facts.
+func handleMemoriesCommand() {
+	if len(chatMemories) == 0 {
+		fmt.Println("Nothing remembered yet. Add a fact using /remember {fact}")
+		return
+	}
+	fmt.Println("\n[Memories]:")
+	for i, fact := range chatMemories {
+		fmt.Printf("%d. %s\n", i+1, fact)
+	}
+}
+
+// handleForgetCommand handles /forget {number}.
+func handleForgetCommand(args string) {
+	n, err := strconv.Atoi(strings.TrimSpace(args))
+	if err != nil || n < 1 || n > len(chatMemories) {
+		fmt.Println("Usage: /forget {number}, as numbered in /memories")
+		return
+	}
+	fact := chatMemories[n-1]
+	chatMemories = append(chatMemories[:n-1:n-1], chatMemories[n:]...)
+	fmt.Printf("Forgot: %s\n", fact)
+}
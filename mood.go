@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// MoodConfig tracks the character's mood after each reply. Method is
+// "keywords" (the default) or "model", which asks Model, or the chat model
+// if it's empty, and falls back to keywords when the answer is unusable.
+type MoodConfig struct {
+	Method string `json:"method,omitempty"`
+	Model  string `json:"model,omitempty"`
+}
+
+var moodWords = map[string]map[string]bool{
+	"joyful":       wordSet("happy glad joy joyful smile smiles smiled smiling laugh laughs laughed laughing grin grins grinned beam beams beamed delighted cheerful excited thrilled yay"),
+	"sad":          wordSet("sad sigh sighs sighed tears tearful cry cries cried crying sob sobs sorrow frown frowns frowned lonely miserable heartbroken"),
+	"angry":        wordSet("angry furious rage fury glare glares glared snap snaps snapped growl growls growled scowl scowls scowled annoyed irritated seething"),
+	"afraid":       wordSet("afraid scared fear frightened tremble trembles trembling shiver shivers shivered nervous nervously flinch flinches flinched panic terrified"),
+	"affectionate": wordSet("love loving hug hugs hugged blush blushes blushed kiss kisses kissed warmly tender tenderly gently fond cuddle cuddles"),
+	"surprised":    wordSet("surprised surprise gasp gasps gasped shock shocked astonished stunned blink blinks blinked wide-eyed"),
+	"playful":      wordSet("tease teases teased teasing wink winks winked smirk smirks smirked giggle giggles giggled playful playfully mischievous"),
+}
+
+// moods are what the character's mood can be, in the order ties go.
+var moods = []string{"joyful", "sad", "angry", "afraid", "affectionate", "surprised", "playful", "calm"}
+
+// characterMood is the character's mood as of the last reply.
+var characterMood string
+
+// updateMood works out the character's mood from the last reply, noting
+// changes.
+func updateMood(client *http.Client, config *Config, debug bool) {
+	if config.Mood == nil || len(messageHistory) == 0 {
+		return
+	}
+	reply := messageHistory[len(messageHistory)-1].Content
+	mood := ""
+	if config.Mood.Method == "model" {
+		mood = askMood(client, config, reply)
+		if mood == "" && debug {
+			notice("[Debug] The mood model's answer was unusable; using keywords.\n")
+		}
+	}
+	if mood == "" {
+		mood = keywordMood(reply)
+	}
+	if mood == "" || mood == characterMood {
+		return
+	}
+	characterMood = mood
+	notice("[Mood]: %s is %s\n", characterDisplayName(activeCharacter), mood)
+}
+
+// keywordMood picks the mood with the most telling words in text, or ""
+// if none show, so the last mood carries on.
+func keywordMood(text string) string {
+	counts := map[string]int{}
+	for _, word := range strings.Fields(strings.ToLower(text)) {
+		word = strings.Trim(word, ".,!?;:\"'*()-")
+		for mood, words := range moodWords {
+			if words[word] {
+				counts[mood]++
+			}
+		}
+	}
+	best := ""
+	for _, mood := range moods {
+		if counts[mood] > counts[best] {
+			best = mood
+		}
+	}
+	return best
+}
+
+// askMood asks the model for the character's mood in one word.
+func askMood(client *http.Client, config *Config, reply string) string {
+	moodConfig := *config
+	if config.Mood.Model != "" {
+		moodConfig.Model = config.Mood.Model
+	}
+	question := reply + "\n\nWhich one of these words best describes the emotional state of " + characterDisplayName(activeCharacter) +
+		" at the end of the text above: " + strings.Join(moods, ", ") + "? Answer with the word only."
+	ctx, cancel := context.WithTimeout(context.Background(), titleTimeout)
+	defer cancel()
+	result, err := chatCompletion(ctx, client, config.URL, newChatMessage(&moodConfig, []Message{{Role: "user", Content: question}}))
+	if err != nil {
+		return ""
+	}
+	answer := strings.ToLower(strings.Trim(strings.TrimSpace(result.Content), ".!\"'*"))
+	for _, mood := range moods {
+		if answer == mood {
+			return mood
+		}
+	}
+	return ""
+}
+
+// moodPrompt keeps the character's mood going from reply to reply.
+func moodPrompt() string {
+	if characterMood == "" {
+		return ""
+	}
+	return characterDisplayName(activeCharacter) + " is currently feeling " + characterMood + ". Carry that on unless something in the story changes it."
+}
+
+// handleMoodCommand shows or sets the character's mood.
+func handleMoodCommand(args string) {
+	switch args {
+	case "":
+		if characterMood == "" {
+			fmt.Println("No mood tracked yet. Turn tracking on with \"mood\": {} in the config, or set one using: /mood {mood}")
+			return
+		}
+		fmt.Printf("%s is %s.\n", characterDisplayName(activeCharacter), characterMood)
+	case "clear":
+		characterMood = ""
+		fmt.Println("Mood cleared.")
+	default:
+		for _, mood := range moods {
+			if args == mood {
+				characterMood = mood
+				fmt.Printf("%s is now %s.\n", characterDisplayName(activeCharacter), mood)
+				return
+			}
+		}
+		fmt.Printf("Unknown mood. Moods: %s\n", strings.Join(moods, ", "))
+	}
+}
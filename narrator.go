@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	narratorLabel  = "Narrator: "
+	narratorPrompt = "Write this story as a novel, as its narrator: third person, past tense, in flowing prose. Describe the scene, what %s and the other characters do, say and feel, and the world around them. Don't decide the words or actions of the user's character beyond what they write."
+)
+
+// chatMode is "narrator" while /mode narrator has the model tell the story
+// as prose, and "" for the usual dialogue. It is saved with the session.
+var chatMode string
+
+// modePrompt is the system prompt addition for the chat mode.
+func modePrompt(config *Config) string {
+	if chatMode != "narrator" {
+		return ""
+	}
+	if config.NarratorPrompt != "" {
+		return config.NarratorPrompt
+	}
+	return fmt.Sprintf(narratorPrompt, characterDisplayName(activeCharacter))
+}
+
+// chatLabel is printed before replies.
+func chatLabel() string {
+	if chatMode == "narrator" {
+		return narratorLabel
+	}
+	return replyLabel
+}
+
+// handleModeCommand handles /mode [narrator | dialogue].
+func handleModeCommand(args string) {
+	switch strings.TrimSpace(args) {
+	case "":
+		mode := chatMode
+		if mode == "" {
+			mode = "dialogue"
+		}
+		fmt.Printf("This chat is in %s mode. Usage: /mode narrator|dialogue\n", mode)
+	case "narrator":
+		chatMode = "narrator"
+		fmt.Println("Narrator mode: replies tell the story in third-person prose. Go back using /mode dialogue")
+	case "dialogue":
+		chatMode = ""
+		fmt.Println("Dialogue mode: the character replies in their own voice.")
+	default:
+		fmt.Println("Usage: /mode narrator|dialogue")
+	}
+}
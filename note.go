@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const DefaultNoteDepth = 4
+
+// AuthorsNote is an instruction inserted Depth messages from the end of the
+// prompt. Instructions close to the latest turn steer the model much more
+// than anything at the top of the system prompt.
+type AuthorsNote struct {
+	Text  string `json:"text"`
+	Depth int    `json:"depth"`
+}
+
+var authorsNote AuthorsNote
+
+func handleNoteCommand(args string) {
+	switch {
+	case args == "":
+		if authorsNote.Text == "" {
+			fmt.Println("No author's note set. Set one using: /note set \"...\" [--depth N]")
+			return
+		}
+		fmt.Printf("\n[Author's Note] (depth %d): %s\n", authorsNote.Depth, authorsNote.Text)
+	case args == "clear":
+		authorsNote = AuthorsNote{}
+		fmt.Println("Author's note cleared.")
+	case strings.HasPrefix(args, "set"):
+		note, err := parseNote(strings.TrimSpace(strings.TrimPrefix(args, "set")))
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		authorsNote = note
+		fmt.Printf("Author's note set at depth %d.\n", note.Depth)
+	default:
+		fmt.Println("Usage: /note [set \"...\" [--depth N] | clear]")
+	}
+}
+
+func parseNote(args string) (AuthorsNote, error) {
+	note := AuthorsNote{Depth: DefaultNoteDepth}
+	if i := strings.LastIndex(args, "--depth"); i >= 0 {
+		depth, err := strconv.Atoi(strings.TrimSpace(args[i+len("--depth"):]))
+		if err != nil || depth < 0 {
+			return note, fmt.Errorf("Invalid depth. Use a number of messages from the end, e.g. --depth 4")
+		}
+		note.Depth = depth
+		args = strings.TrimSpace(args[:i])
+	}
+
+	note.Text = strings.Trim(args, "\"")
+	if note.Text == "" {
+		return note, fmt.Errorf("Usage: /note set \"...\" [--depth N]")
+	}
+	return note, nil
+}
+
+// injectAuthorsNote returns history with the note inserted as a system
+// message depth messages before the end.
+func injectAuthorsNote(history []Message, note AuthorsNote) []Message {
+	if note.Text == "" {
+		return history
+	}
+	pos := len(history) - note.Depth
+	if pos < 0 {
+		pos = 0
+	}
+	out := make([]Message, 0, len(history)+1)
+	out = append(out, history[:pos]...)
+	out = append(out, Message{Role: "system", Content: "[Author's Note: " + note.Text + "]"})
+	return append(out, history[pos:]...)
+}
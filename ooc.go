@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+const oocPrompt = "The user's last message is out of character (OOC): they are talking to you as the writer, not to %s. Step out of the roleplay and answer it plainly and briefly, as (OOC: ...). The story picks up again with their next in-character message."
+
+// isOOC reports whether a message is an out-of-character aside, written
+// as (OOC: ...).
+func isOOC(text string) bool {
+	text = strings.ToLower(strings.TrimSpace(text))
+	return strings.HasPrefix(text, "(ooc:") || strings.HasPrefix(text, "(ooc ")
+}
+
+// oocMessage asks for an out-of-character reply when the latest message
+// is one.
+func oocMessage(config *Config, history []Message) []Message {
+	if len(history) == 0 || history[len(history)-1].Role != "user" || !isOOC(history[len(history)-1].Content) {
+		return nil
+	}
+	prompt := config.OOCPrompt
+	if prompt == "" {
+		prompt = fmt.Sprintf(oocPrompt, characterDisplayName(activeCharacter))
+	}
+	return []Message{{Role: "system", Content: prompt}}
+}
+
+// storyHistory leaves out the out-of-character messages and the replies
+// to them, when the config keeps them out of the story.
+func storyHistory(config *Config, history []Message) []Message {
+	if !config.ExcludeOOC {
+		return history
+	}
+	outside := outOfStory(config, history)
+	var story []Message
+	for i, msg := range history {
+		if !outside[i] {
+			story = append(story, msg)
+		}
+	}
+	return story
+}
+
+// outOfStory returns the indexes of the messages storyHistory leaves out,
+// for views that number messages as /hist does.
+func outOfStory(config *Config, history []Message) map[int]bool {
+	outside := map[int]bool{}
+	if !config.ExcludeOOC {
+		return outside
+	}
+	for i := 0; i < len(history); i++ {
+		if history[i].Role == "user" && isOOC(history[i].Content) {
+			outside[i] = true
+			if i+1 < len(history) && history[i+1].Role == "assistant" {
+				outside[i+1] = true
+				i++
+			}
+		}
+	}
+	return outside
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// ChatOverrides are settings for one chat, saved with its session: a model
+// and options used over the config's and the character's, and a persona
+// describing who you play.
+type ChatOverrides struct {
+	modelSettings
+	Persona string `json:"persona,omitempty"`
+}
+
+var chatOverrides ChatOverrides
+
+func (o ChatOverrides) empty() bool {
+	return o.Model == "" && len(o.Options) == 0 && o.Persona == ""
+}
+
+// savedOverrides is chatOverrides as stored in a session.
+func savedOverrides() *ChatOverrides {
+	if chatOverrides.empty() {
+		return nil
+	}
+	overrides := chatOverrides
+	return &overrides
+}
+
+// personaPrompt tells the model who the user plays in this chat.
+func personaPrompt() string {
+	if chatOverrides.Persona == "" {
+		return ""
+	}
+	return "The user's character: " + chatOverrides.Persona
+}
+
+// handleOverrideCommand handles /override [model {name} | option {key}
+// {value} | persona {text} | clear [model | option {key} | persona]],
+// settings that only apply to this chat.
+func handleOverrideCommand(args string, config *Config) {
+	const usage = "Usage: /override [model {name} | option {key} {value} | persona {text} | clear [model | option {key} | persona]]"
+	fields := strings.Fields(args)
+	if len(fields) == 0 {
+		displayOverrides()
+		return
+	}
+	rest := strings.TrimSpace(strings.TrimPrefix(args, fields[0]))
+	switch {
+	case fields[0] == "model" && len(fields) == 2:
+		chatOverrides.Model = fields[1]
+	case fields[0] == "option" && len(fields) >= 3:
+		var value interface{}
+		raw := strings.TrimSpace(strings.TrimPrefix(rest, fields[1]))
+		if err := json.Unmarshal([]byte(raw), &value); err != nil {
+			value = raw
+		}
+		options := map[string]interface{}{}
+		for key, v := range chatOverrides.Options {
+			options[key] = v
+		}
+		options[fields[1]] = value
+		chatOverrides.Options = options
+	case fields[0] == "persona" && rest != "":
+		chatOverrides.Persona = strings.Trim(rest, "\"")
+		fmt.Println("Persona set for this chat.")
+		return
+	case fields[0] == "clear" && len(fields) == 1:
+		chatOverrides = ChatOverrides{}
+	case fields[0] == "clear" && fields[1] == "model":
+		chatOverrides.Model = ""
+	case fields[0] == "clear" && fields[1] == "option" && len(fields) == 3:
+		options := map[string]interface{}{}
+		for key, v := range chatOverrides.Options {
+			if key != fields[2] {
+				options[key] = v
+			}
+		}
+		chatOverrides.Options = options
+	case fields[0] == "clear" && fields[1] == "persona":
+		chatOverrides.Persona = ""
+		fmt.Println("Persona cleared.")
+		return
+	default:
+		fmt.Println(usage)
+		return
+	}
+	if len(chatOverrides.Options) == 0 {
+		chatOverrides.Options = nil
+	}
+	applySettings(config)
+	fmt.Println("Chat settings updated. They are saved with the session.")
+}
+
+func displayOverrides() {
+	if chatOverrides.empty() {
+		fmt.Println("This chat uses the character's and config's settings. Change them for this chat only using /override")
+		return
+	}
+	fmt.Println("\n[Chat Overrides]:")
+	if chatOverrides.Model != "" {
+		fmt.Printf("Model: %s\n", chatOverrides.Model)
+	}
+	keys := make([]string, 0, len(chatOverrides.Options))
+	for key := range chatOverrides.Options {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		fmt.Printf("Option %s: %v\n", key, chatOverrides.Options[key])
+	}
+	if chatOverrides.Persona != "" {
+		fmt.Printf("Persona: %s\n", chatOverrides.Persona)
+	}
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"golang.org/x/term"
+)
+
+// page shows text a screen at a time when it doesn't fit in the terminal,
+// using $PAGER if it is set and a built-in pager otherwise.
+func page(text string) {
+	fd := int(os.Stdout.Fd())
+	width, height, err := term.GetSize(fd)
+	if err != nil || !term.IsTerminal(int(os.Stdin.Fd())) {
+		fmt.Print(text)
+		return
+	}
+	lines := strings.Split(wrapText(strings.TrimRight(text, "\n"), width, 0), "\n")
+	if len(lines) < height {
+		fmt.Print(text)
+		return
+	}
+
+	if pager := os.Getenv("PAGER"); pager != "" {
+		cmd := exec.Command("sh", "-c", pager)
+		cmd.Stdin = strings.NewReader(text)
+		cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+		if err := cmd.Run(); err == nil {
+			return
+		}
+		printError("Error running $PAGER, using the built-in pager instead.")
+	}
+	state, err := term.MakeRaw(int(os.Stdin.Fd()))
+	if err != nil {
+		fmt.Print(text)
+		return
+	}
+	defer term.Restore(int(os.Stdin.Fd()), state)
+	fmt.Print("\x1b[?1049h")
+	defer fmt.Print("\x1b[?1049l")
+	(&pagerState{lines: lines, height: height - 1}).run()
+}
+
+const pagerHelp = "space/b page, j/k line, g/G top/end, /search, n/N next/previous, q quit"
+
+type pagerState struct {
+	lines  []string
+	height int
+	top    int
+	query  string
+	status string
+}
+
+func (p *pagerState) run() {
+	for {
+		p.draw()
+		r, _, err := stdinReader.ReadRune()
+		if err != nil {
+			return
+		}
+		p.status = ""
+		switch r {
+		case 'q', 'Q', 3:
+			return
+		case ' ', 'f', 6:
+			p.scroll(p.height)
+		case 'b', 2:
+			p.scroll(-p.height)
+		case 'j', '\r', '\n':
+			p.scroll(1)
+		case 'k':
+			p.scroll(-1)
+		case 'g':
+			p.top = 0
+		case 'G':
+			p.scroll(len(p.lines))
+		case '/':
+			p.query = p.readQuery()
+			p.search(1)
+		case 'n':
+			p.search(1)
+		case 'N':
+			p.search(-1)
+		case 'h', '?':
+			p.status = pagerHelp
+		case 27:
+			switch readEscape() {
+			case "[A", "OA":
+				p.scroll(-1)
+			case "[B", "OB":
+				p.scroll(1)
+			case "[5~":
+				p.scroll(-p.height)
+			case "[6~":
+				p.scroll(p.height)
+			case "[H", "OH":
+				p.top = 0
+			case "[F", "OF":
+				p.scroll(len(p.lines))
+			}
+		}
+	}
+}
+
+func (p *pagerState) scroll(n int) {
+	p.top += n
+	if last := len(p.lines) - p.height; p.top > last {
+		p.top = last
+	}
+	if p.top < 0 {
+		p.top = 0
+	}
+}
+
+// search moves to the next line containing the query, in direction dir,
+// starting after the top line.
+func (p *pagerState) search(dir int) {
+	if p.query == "" {
+		return
+	}
+	query := strings.ToLower(p.query)
+	for i := p.top + dir; i >= 0 && i < len(p.lines); i += dir {
+		if strings.Contains(strings.ToLower(p.lines[i]), query) {
+			p.top = i
+			p.scroll(0)
+			return
+		}
+	}
+	p.status = "Not found: " + p.query
+}
+
+func (p *pagerState) readQuery() string {
+	var query []rune
+	for {
+		fmt.Printf("\r\x1b[K/%s", string(query))
+		r, _, err := stdinReader.ReadRune()
+		switch {
+		case err != nil, r == '\r', r == '\n':
+			return string(query)
+		case r == 27 || r == 3:
+			return ""
+		case r == 127 || r == 8:
+			if len(query) > 0 {
+				query = query[:len(query)-1]
+			}
+		case r >= 32:
+			query = append(query, r)
+		}
+	}
+}
+
+func (p *pagerState) draw() {
+	fmt.Print("\x1b[H\x1b[2J")
+	end := p.top + p.height
+	if end > len(p.lines) {
+		end = len(p.lines)
+	}
+	for _, line := range p.lines[p.top:end] {
+		line = highlightMatches(line, p.query)
+		fmt.Print(line + "\r\n")
+	}
+	status := p.status
+	if status == "" {
+		status = fmt.Sprintf("lines %d-%d of %d (%d%%), h for help", p.top+1, end, len(p.lines), end*100/len(p.lines))
+	}
+	fmt.Print("\x1b[7m" + status + "\x1b[0m")
+}
+
+// highlightMatches shows the search matches in line in reverse video.
+func highlightMatches(line, query string) string {
+	if query == "" {
+		return line
+	}
+	var b strings.Builder
+	lower, q := strings.ToLower(line), strings.ToLower(query)
+	for {
+		i := strings.Index(lower, q)
+		if i < 0 || len(lower) != len(line) {
+			b.WriteString(line)
+			return b.String()
+		}
+		b.WriteString(line[:i] + "\x1b[7m" + line[i:i+len(q)] + "\x1b[0m")
+		line, lower = line[i+len(q):], lower[i+len(q):]
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// handlePinCommand handles /pin and /unpin {number}, numbered as in /hist.
+func handlePinCommand(args string, pin bool) {
+	name := "/unpin"
+	if pin {
+		name = "/pin"
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(args))
+	if err != nil || n < 1 || n > len(messageHistory) {
+		fmt.Printf("Usage: %s {number}, from 1 to %d as numbered in /pins and /hist\n", name, len(messageHistory))
+		return
+	}
+	msg := &messageHistory[n-1]
+	switch {
+	case msg.Pinned == pin && pin:
+		fmt.Printf("Message %d is already pinned.\n", n)
+	case msg.Pinned == pin:
+		fmt.Printf("Message %d isn't pinned.\n", n)
+	case pin:
+		msg.Pinned = true
+		fmt.Printf("Pinned message %d. It stays in the context however long the chat gets.\n", n)
+	default:
+		msg.Pinned = false
+		fmt.Printf("Unpinned message %d.\n", n)
+	}
+}
+
+// handlePinsCommand lists the pinned messages.
+func handlePinsCommand() {
+	var b strings.Builder
+	for i, msg := range messageHistory {
+		if msg.Pinned {
+			fmt.Fprintf(&b, "%d. [%s]: %s\n", i+1, strings.Title(msg.Role), msg.Content)
+		}
+	}
+	if b.Len() == 0 {
+		fmt.Println("No pinned messages. Pin one using /pin {number}, numbered as in /hist")
+		return
+	}
+	fmt.Println("\n[Pinned]:")
+	page(b.String())
+}
+
+// splitPinned separates the pinned messages from the rest, keeping their
+// order.
+func splitPinned(messages []Message) (pinned, rest []Message) {
+	for _, msg := range messages {
+		if msg.Pinned {
+			pinned = append(pinned, msg)
+		} else {
+			rest = append(rest, msg)
+		}
+	}
+	return pinned, rest
+}
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// PromptConfig arranges the system prompt. Order lists layers to put
+// first; the rest follow in their usual order. Disabled layers aren't
+// sent at all, including the ones placed around the history:
+// authors_note, event, ooc and post_history.
+type PromptConfig struct {
+	Order    []string `json:"order,omitempty"`
+	Disabled []string `json:"disabled,omitempty"`
+}
+
+// promptLayer is one part of the system prompt.
+type promptLayer struct {
+	Name string
+	Text func(p *promptParts) string
+}
+
+// promptParts is what the layers are built from for one request.
+type promptParts struct {
+	config            *Config
+	loreBefore, after string
+}
+
+// promptLayers are the layers of the system prompt in their usual order:
+// the rules, then who the character is, then where and who with, then the
+// state of the story.
+var promptLayers = []promptLayer{
+	{"system", func(p *promptParts) string { return p.config.System }},
+	{"safety", func(p *promptParts) string { return safetyPrompt(p.config) }},
+	{"lore_before", func(p *promptParts) string { return p.loreBefore }},
+	{"definition", func(p *promptParts) string { return activeCharacter.definitionPrompt() }},
+	{"examples", func(p *promptParts) string { return examplesPrompt(activeCharacter) }},
+	{"scenario", func(p *promptParts) string { return scenarioPrompt(currentScenario()) }},
+	{"persona", func(p *promptParts) string { return personaPrompt() }},
+	{"memory", func(p *promptParts) string { return memoryPrompt() }},
+	{"lore_after", func(p *promptParts) string { return p.after }},
+	{"mode", func(p *promptParts) string { return modePrompt(p.config) }},
+	{"attachments", func(p *promptParts) string { return retrievedPrompt() }},
+	{"state", func(p *promptParts) string { return statePrompt() }},
+	{"mood", func(p *promptParts) string { return moodPrompt() }},
+	{"relationship", func(p *promptParts) string { return relationshipPrompt(p.config) }},
+	{"clock", func(p *promptParts) string { return clockPrompt(p.config) }},
+}
+
+// historyLayers are placed in or after the history instead of the system
+// prompt. They can be disabled but not moved.
+var historyLayers = []string{"authors_note", "event", "ooc", "post_history"}
+
+// promptSection is a layer's text in a built prompt.
+type promptSection struct {
+	Name, Text string
+}
+
+// layerEnabled reports whether the config leaves a layer on, and for the
+// example dialogue whether the chat hasn't dropped it. The safety layer is
+// always on; only safe_mode turns it off.
+func layerEnabled(config *Config, name string) bool {
+	if name == "examples" && examplesDropped {
+		return false
+	}
+	if config.Prompt == nil || name == "safety" {
+		return true
+	}
+	for _, disabled := range config.Prompt.Disabled {
+		if disabled == name {
+			return false
+		}
+	}
+	return true
+}
+
+// orderedLayers puts the layers in the order the config asks for.
+func orderedLayers(config *Config) []promptLayer {
+	if config.Prompt == nil || len(config.Prompt.Order) == 0 {
+		return promptLayers
+	}
+	var layers []promptLayer
+	placed := map[string]bool{}
+	for _, name := range config.Prompt.Order {
+		for _, layer := range promptLayers {
+			if layer.Name == name && !placed[name] {
+				layers = append(layers, layer)
+				placed[name] = true
+			}
+		}
+	}
+	for _, layer := range promptLayers {
+		if !placed[layer.Name] {
+			layers = append(layers, layer)
+		}
+	}
+	return layers
+}
+
+// systemSections builds the layers of the system prompt that have
+// anything to say, leaving out those in skip.
+func systemSections(config *Config, history []Message, skip ...string) []promptSection {
+	parts := &promptParts{config: config}
+	parts.loreBefore, parts.after = loreInjections(config, history)
+	var sections []promptSection
+layers:
+	for _, layer := range orderedLayers(config) {
+		for _, name := range skip {
+			if layer.Name == name {
+				continue layers
+			}
+		}
+		if !layerEnabled(config, layer.Name) {
+			continue
+		}
+		if text := strings.TrimSpace(layer.Text(parts)); text != "" {
+			sections = append(sections, promptSection{layer.Name, text})
+		}
+	}
+	return sections
+}
+
+func joinSections(sections []promptSection) string {
+	texts := make([]string, len(sections))
+	for i, section := range sections {
+		texts[i] = section.Text
+	}
+	return strings.Join(texts, "\n\n")
+}
+
+// buildPrompt returns the full message list sent to the backend for history.
+func buildPrompt(config *Config, history []Message) []Message {
+	prompt, _ := buildPromptSections(config, history)
+	return prompt
+}
+
+// buildPromptSections is buildPrompt that also returns the layers that
+// went into the system prompt.
+func buildPromptSections(config *Config, history []Message) ([]Message, []promptSection) {
+	if len(history) > 0 {
+		// The latest message is being answered, even if it is out of
+		// character.
+		history = append(storyHistory(config, history[:len(history)-1]), history[len(history)-1])
+	}
+	sections := systemSections(config, history)
+	prompt := assemblePrompt(config, history, sections)
+	// Example dialogue is the first thing to go when the context is tight;
+	// by then the chat itself shows how the character talks.
+	if estimatePromptTokens(prompt) > contextLimit(config)-replyReserve && examplesPrompt(activeCharacter) != "" {
+		sections = systemSections(config, history, "examples")
+		prompt = assemblePrompt(config, history, sections)
+	}
+	return prompt, sections
+}
+
+func assemblePrompt(config *Config, history []Message, sections []promptSection) []Message {
+	if layerEnabled(config, "authors_note") {
+		history = injectAuthorsNote(history, authorsNote)
+	}
+	prompt := append([]Message{{Role: "system", Content: joinSections(sections)}}, history...)
+	if layerEnabled(config, "event") {
+		prompt = append(prompt, eventMessage()...)
+	}
+	if layerEnabled(config, "ooc") {
+		prompt = append(prompt, oocMessage(config, history)...)
+	}
+	if activeCharacter.PostHistory != "" && layerEnabled(config, "post_history") {
+		prompt = append(prompt, Message{Role: "system", Content: activeCharacter.PostHistory})
+	}
+	return prompt
+}
+
+// checkPromptConfig warns about layer names the config gets wrong.
+func checkPromptConfig(config *Config) {
+	if config.Prompt == nil {
+		return
+	}
+	known := map[string]bool{}
+	for _, layer := range promptLayers {
+		known[layer.Name] = true
+	}
+	for _, name := range historyLayers {
+		known[name] = true
+	}
+	for _, name := range append(append([]string{}, config.Prompt.Order...), config.Prompt.Disabled...) {
+		if !known[name] {
+			fmt.Printf("Unknown prompt layer %q in the config.\n", name)
+		}
+	}
+	for _, name := range config.Prompt.Disabled {
+		if name == "safety" {
+			fmt.Println("The safety layer can't be disabled; set safe_mode to false instead.")
+		}
+	}
+}
+
+// handlePromptCommand shows the request the next message will be sent
+// with: each layer of the system prompt, then the history and whatever is
+// placed around it. raw prints the request body as JSON instead.
+func handlePromptCommand(args string, config *Config) {
+	switch strings.TrimSpace(args) {
+	case "", "--raw":
+	default:
+		fmt.Println("Usage: /prompt [--raw]")
+		return
+	}
+	if rawMode {
+		fmt.Println("Raw mode is on, so messages go without the system prompt. Turn it off with /raw off to see the roleplay prompt.")
+		return
+	}
+	prompt, sections := buildPromptSections(config, messageHistory)
+
+	var b strings.Builder
+	if strings.TrimSpace(args) == "--raw" {
+		data := chatRequest(config, prompt)
+		out, err := json.MarshalIndent(data, "", "  ")
+		if err != nil {
+			printError("Error encoding the request:", err)
+			return
+		}
+		b.Write(out)
+		b.WriteString("\n")
+		page(b.String())
+		return
+	}
+
+	fmt.Fprintf(&b, "\n[Prompt]: %d message(s), about %d of %d tokens\n", len(prompt), estimatePromptTokens(prompt), contextLimit(config))
+	if examplesPrompt(activeCharacter) != "" && !hasSection(sections, "examples") && layerEnabled(config, "examples") {
+		b.WriteString("Example dialogue is left out to make room.\n")
+	}
+	for _, section := range sections {
+		fmt.Fprintf(&b, "\n--- system: %s (about %d tokens) ---\n%s\n", section.Name, estimateTokens(section.Text), section.Text)
+	}
+	for _, msg := range prompt[1:] {
+		fmt.Fprintf(&b, "\n--- %s (about %d tokens) ---\n%s%s\n", msg.Role, estimateTokens(msg.Content), msg.Content, imageNote(msg))
+	}
+	if gmMode {
+		b.WriteString("\nGame master mode adds its instructions and the game state after these.\n")
+	}
+	b.WriteString("\nYour next message goes at the end.\n")
+	page(b.String())
+}
+
+func hasSection(sections []promptSection, name string) bool {
+	for _, section := range sections {
+		if section.Name == name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"os"
+	"strings"
+
+	"golang.org/x/term"
+)
+
+const quickRecentSessions = 5
+
+// quickItem is an entry of the quick menu: a favorite character or a
+// recent session.
+type quickItem struct {
+	Label     string
+	Character string
+	Session   string
+}
+
+func quickItems(config *Config) []quickItem {
+	var items []quickItem
+	for _, name := range config.Favorites {
+		items = append(items, quickItem{Label: "★ " + name, Character: name})
+	}
+	sessions, _ := listSessions()
+	for i, session := range sessions {
+		if i == quickRecentSessions {
+			break
+		}
+		character := session.Character
+		if character == "" {
+			character = "default"
+		}
+		items = append(items, quickItem{
+			Label:   fmt.Sprintf("%s (%s, %s)", session.Name, character, session.Updated.Format("Jan 2 15:04")),
+			Session: session.Name,
+		})
+	}
+	if len(items) > 9 {
+		items = items[:9]
+	}
+	return items
+}
+
+// startupQuickMenu offers the quick menu when the app starts in a terminal,
+// reporting whether a chat was picked from it.
+func startupQuickMenu(config *Config) bool {
+	if config.QuickMenu != nil && !*config.QuickMenu || !term.IsTerminal(int(os.Stdin.Fd())) {
+		return false
+	}
+	return showQuickMenu(config, "Enter to start a new chat")
+}
+
+// showQuickMenu lists the quick menu items and opens the one whose number
+// is pressed.
+func showQuickMenu(config *Config, skip string) bool {
+	items := quickItems(config)
+	if len(items) == 0 {
+		return false
+	}
+	fmt.Println("\n[Quick Menu]:")
+	for i, item := range items {
+		fmt.Printf("%d. %s\n", i+1, item.Label)
+	}
+	key, err := readKey(fmt.Sprintf("Press a number, or %s: ", skip))
+	if err == errInputInterrupted {
+		quitOnInterrupt()
+	}
+	n := int(key - '0')
+	if err != nil || n < 1 || n > len(items) {
+		return false
+	}
+
+	item := items[n-1]
+	if item.Session != "" {
+		handleLoadCommand(item.Session, config)
+		return true
+	}
+	character, err := loadCharacter(item.Character)
+	if err != nil {
+		printError("Error loading character:", err)
+		return false
+	}
+	switchCharacter(character, config)
+	return true
+}
+
+func handleQuickCommand(args string, config *Config) {
+	fields := strings.Fields(args)
+	if len(fields) == 0 {
+		if len(quickItems(config)) == 0 {
+			fmt.Println("No favorites or saved sessions yet. Add a favorite using: /quick fav {character}")
+			return
+		}
+		showQuickMenu(config, "Enter to stay here")
+		return
+	}
+
+	name := strings.TrimSpace(strings.TrimPrefix(args, fields[0]))
+	if name == "" && activeCharacter.Name != "" {
+		name = activeCharacter.Name
+	}
+	switch {
+	case name == "":
+		fmt.Println("Usage: /quick [fav | unfav] {character}")
+	case fields[0] == "fav":
+		if _, err := loadCharacter(name); err != nil {
+			printError("Error loading character:", err)
+			return
+		}
+		if indexOf(config.Favorites, name) < 0 {
+			config.Favorites = append(config.Favorites, name)
+			saveConfig(*config)
+		}
+		fmt.Printf("'%s' is a favorite.\n", name)
+	case fields[0] == "unfav":
+		if i := indexOf(config.Favorites, name); i >= 0 {
+			config.Favorites = append(config.Favorites[:i], config.Favorites[i+1:]...)
+			saveConfig(*config)
+		}
+		fmt.Printf("'%s' is not a favorite.\n", name)
+	default:
+		fmt.Println("Usage: /quick [fav | unfav] {character}")
+	}
+}
+
+// displaySuggestion prints a character to try at startup, other than the
+// current one: any at random, or the one least recently chatted with.
+func displaySuggestion(config *Config) {
+	if config.SuggestCharacter == "" {
+		return
+	}
+	names, _ := listCharacters()
+	if i := indexOf(names, activeCharacter.Name); i >= 0 {
+		names = append(names[:i], names[i+1:]...)
+	}
+	if len(names) == 0 {
+		return
+	}
+
+	name := names[rand.Intn(len(names))]
+	if config.SuggestCharacter == "least_used" {
+		lastUsed := charactersLastUsed()
+		// Shuffle first so characters never used are picked from evenly.
+		rand.Shuffle(len(names), func(i, j int) { names[i], names[j] = names[j], names[i] })
+		name = names[0]
+		for _, n := range names[1:] {
+			if lastUsed[n].Before(lastUsed[name]) {
+				name = n
+			}
+		}
+	}
+	character, err := loadCharacter(name)
+	if err != nil {
+		return
+	}
+	fmt.Println("\nWho do you want to talk to today? How about " + character.Name + "?")
+	if greeting := strings.TrimSpace(character.Greeting); greeting != "" {
+		fmt.Printf("  \"%s\"\n", truncateText(greeting, 100))
+	}
+	fmt.Printf("Switch using: /char load %s\n", character.Name)
+}
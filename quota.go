@@ -0,0 +1,191 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const QuotaUsageFile = "quota_usage.json"
+
+// QuotaLimits caps usage over a day and a month. Zero means unlimited.
+type QuotaLimits struct {
+	DailyMessages   int `json:"daily_messages,omitempty"`
+	MonthlyMessages int `json:"monthly_messages,omitempty"`
+	DailyTokens     int `json:"daily_tokens,omitempty"`
+	MonthlyTokens   int `json:"monthly_tokens,omitempty"`
+}
+
+// QuotaConfig holds the limits enforced in server mode. Entries in Users and
+// Characters replace the PerUser and PerCharacter defaults for that name.
+//
+// Users are told apart by APIKeys, which maps the keys clients send as
+// "Authorization: Bearer <key>" to user names; requests without a known key
+// are then refused. Without APIKeys, each client address is a user.
+type QuotaConfig struct {
+	PerUser      QuotaLimits            `json:"per_user"`
+	PerCharacter QuotaLimits            `json:"per_character"`
+	Users        map[string]QuotaLimits `json:"users,omitempty"`
+	Characters   map[string]QuotaLimits `json:"characters,omitempty"`
+	APIKeys      map[string]string      `json:"api_keys,omitempty"`
+}
+
+func (q *QuotaConfig) userLimits(user string) QuotaLimits {
+	if limits, ok := q.Users[user]; ok {
+		return limits
+	}
+	return q.PerUser
+}
+
+func (q *QuotaConfig) characterLimits(character string) QuotaLimits {
+	if limits, ok := q.Characters[character]; ok {
+		return limits
+	}
+	return q.PerCharacter
+}
+
+type quotaUsage struct {
+	Day           string `json:"day"`
+	DayMessages   int    `json:"day_messages"`
+	DayTokens     int    `json:"day_tokens"`
+	Month         string `json:"month"`
+	MonthMessages int    `json:"month_messages"`
+	MonthTokens   int    `json:"month_tokens"`
+}
+
+// rollover resets the counters of any period that has ended.
+func (u *quotaUsage) rollover(now time.Time) {
+	if day := now.Format("2006-01-02"); u.Day != day {
+		u.Day, u.DayMessages, u.DayTokens = day, 0, 0
+	}
+	if month := now.Format("2006-01"); u.Month != month {
+		u.Month, u.MonthMessages, u.MonthTokens = month, 0, 0
+	}
+}
+
+// quotaTracker counts messages and tokens per user and per character and
+// persists them so restarting the server does not reset anyone's quota.
+type quotaTracker struct {
+	mu    sync.Mutex
+	path  string
+	usage map[string]*quotaUsage
+}
+
+func newQuotaTracker() *quotaTracker {
+	q := &quotaTracker{
+		path:  filepath.Join(getConfigDir(), QuotaUsageFile),
+		usage: map[string]*quotaUsage{},
+	}
+	if data, err := ioutil.ReadFile(q.path); err == nil {
+		if err := json.Unmarshal(data, &q.usage); err != nil {
+			printError("Error parsing quota usage file:", err)
+		}
+	}
+	return q
+}
+
+func (q *quotaTracker) get(key string, now time.Time) *quotaUsage {
+	u, ok := q.usage[key]
+	if !ok {
+		u = &quotaUsage{}
+		q.usage[key] = u
+	}
+	u.rollover(now)
+	return u
+}
+
+// quotaCheck is one quota a request counts against.
+type quotaCheck struct {
+	key     string
+	subject string
+	limits  QuotaLimits
+}
+
+// exceeded returns a friendly explanation if the check's subject has used
+// up any of its limits, or an empty string if the request may go ahead.
+func (c quotaCheck) exceeded(u *quotaUsage) string {
+	limits := c.limits
+	switch {
+	case limits.DailyMessages > 0 && u.DayMessages >= limits.DailyMessages:
+		return quotaMessage(c.subject, "daily", fmt.Sprintf("%d messages", limits.DailyMessages))
+	case limits.DailyTokens > 0 && u.DayTokens >= limits.DailyTokens:
+		return quotaMessage(c.subject, "daily", fmt.Sprintf("%d tokens", limits.DailyTokens))
+	case limits.MonthlyMessages > 0 && u.MonthMessages >= limits.MonthlyMessages:
+		return quotaMessage(c.subject, "monthly", fmt.Sprintf("%d messages", limits.MonthlyMessages))
+	case limits.MonthlyTokens > 0 && u.MonthTokens >= limits.MonthlyTokens:
+		return quotaMessage(c.subject, "monthly", fmt.Sprintf("%d tokens", limits.MonthlyTokens))
+	}
+	return ""
+}
+
+// reserve counts a message against every check, unless one of them is used
+// up, in which case it counts nothing and returns the explanation. Checking
+// and counting under one lock keeps concurrent requests from all getting
+// through on the last message of a quota.
+func (q *quotaTracker) reserve(now time.Time, checks ...quotaCheck) string {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for _, c := range checks {
+		if msg := c.exceeded(q.get(c.key, now)); msg != "" {
+			return msg
+		}
+	}
+	for _, c := range checks {
+		u := q.get(c.key, now)
+		u.DayMessages++
+		u.MonthMessages++
+	}
+	q.save(now)
+	return ""
+}
+
+// release gives back the message reserved for a request that failed.
+func (q *quotaTracker) release(now time.Time, checks ...quotaCheck) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for _, c := range checks {
+		u := q.get(c.key, now)
+		u.DayMessages = max(u.DayMessages-1, 0)
+		u.MonthMessages = max(u.MonthMessages-1, 0)
+	}
+	q.save(now)
+}
+
+// addTokens counts the tokens a reserved message used.
+func (q *quotaTracker) addTokens(tokens int, now time.Time, checks ...quotaCheck) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for _, c := range checks {
+		u := q.get(c.key, now)
+		u.DayTokens += tokens
+		u.MonthTokens += tokens
+	}
+	q.save(now)
+}
+
+// save writes the usage file, leaving out entries with nothing counted
+// this month so it doesn't keep every client the server has ever seen.
+func (q *quotaTracker) save(now time.Time) {
+	for key, u := range q.usage {
+		u.rollover(now)
+		if u.MonthMessages == 0 && u.MonthTokens == 0 {
+			delete(q.usage, key)
+		}
+	}
+
+	data, _ := json.MarshalIndent(q.usage, "", "  ")
+	if err := ioutil.WriteFile(q.path, data, 0644); err != nil {
+		printError("Error saving quota usage:", err)
+	}
+}
+
+func quotaMessage(subject, period, limit string) string {
+	reset := "tomorrow"
+	if period == "monthly" {
+		reset = "at the start of next month"
+	}
+	return fmt.Sprintf("%s reached the %s limit of %s. Please come back %s!", subject, period, limit, reset)
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func newTestQuotaTracker(t *testing.T) *quotaTracker {
+	return &quotaTracker{path: filepath.Join(t.TempDir(), QuotaUsageFile), usage: map[string]*quotaUsage{}}
+}
+
+func TestQuotaReserve(t *testing.T) {
+	now := time.Date(2026, 3, 15, 12, 0, 0, 0, time.UTC)
+	user := quotaCheck{"user:ann", "You", QuotaLimits{DailyMessages: 2}}
+	character := quotaCheck{"character:bob", "Bob", QuotaLimits{MonthlyTokens: 100}}
+	tests := []struct {
+		name     string
+		usage    map[string]quotaUsage
+		when     time.Time
+		blocked  bool
+		wantUser int
+		wantChar int
+	}{
+		{"fresh", nil, now, false, 1, 1},
+		{"under the limit", map[string]quotaUsage{"user:ann": {Day: "2026-03-15", DayMessages: 1, Month: "2026-03", MonthMessages: 1}}, now, false, 2, 1},
+		{"daily limit used up", map[string]quotaUsage{"user:ann": {Day: "2026-03-15", DayMessages: 2, Month: "2026-03", MonthMessages: 2}}, now, true, 2, 0},
+		{"next day", map[string]quotaUsage{"user:ann": {Day: "2026-03-15", DayMessages: 2, Month: "2026-03", MonthMessages: 2}}, now.AddDate(0, 0, 1), false, 1, 1},
+		// One used-up check counts nothing against the others.
+		{"other check used up", map[string]quotaUsage{"character:bob": {Day: "2026-03-15", Month: "2026-03", MonthMessages: 3, MonthTokens: 100}}, now, true, 0, 0},
+	}
+	for _, tt := range tests {
+		q := newTestQuotaTracker(t)
+		for key, u := range tt.usage {
+			u := u
+			q.usage[key] = &u
+		}
+		msg := q.reserve(tt.when, user, character)
+		if blocked := msg != ""; blocked != tt.blocked {
+			t.Errorf("%s: reserve() = %q, want blocked %v", tt.name, msg, tt.blocked)
+		}
+		if got := q.get(user.key, tt.when).DayMessages; got != tt.wantUser {
+			t.Errorf("%s: user day messages = %d, want %d", tt.name, got, tt.wantUser)
+		}
+		if got := q.get(character.key, tt.when).DayMessages; got != tt.wantChar {
+			t.Errorf("%s: character day messages = %d, want %d", tt.name, got, tt.wantChar)
+		}
+	}
+}
+
+func TestQuotaRelease(t *testing.T) {
+	now := time.Date(2026, 3, 15, 12, 0, 0, 0, time.UTC)
+	check := quotaCheck{"user:ann", "You", QuotaLimits{DailyMessages: 1}}
+	tests := []struct {
+		name     string
+		reserves int
+		releases int
+		want     int
+	}{
+		{"reserve then release", 1, 1, 0},
+		{"release without reserve", 0, 1, 0},
+		{"reserve past the limit", 2, 0, 1},
+	}
+	for _, tt := range tests {
+		q := newTestQuotaTracker(t)
+		for i := 0; i < tt.reserves; i++ {
+			q.reserve(now, check)
+		}
+		for i := 0; i < tt.releases; i++ {
+			q.release(now, check)
+		}
+		u := q.get(check.key, now)
+		if u.DayMessages != tt.want || u.MonthMessages != tt.want {
+			t.Errorf("%s: messages = %d today, %d this month, want %d", tt.name, u.DayMessages, u.MonthMessages, tt.want)
+		}
+		if tt.releases > 0 && q.reserve(now, check) != "" {
+			t.Errorf("%s: reserve after release was refused", tt.name)
+		}
+	}
+}
@@ -0,0 +1,309 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	AttachmentsDir      = "attachments"
+	DefaultEmbedModel   = "nomic-embed-text"
+	DefaultChunkTokens  = 300
+	DefaultRetrieveTopK = 3
+	DefaultMinScore     = 0.5
+	embedBatch          = 32
+	embedTimeout        = 2 * time.Minute
+)
+
+// EmbeddingsConfig sets how /attach documents are embedded and retrieved.
+// MinScore is the cosine similarity a chunk needs to be brought in.
+type EmbeddingsConfig struct {
+	Model       string  `json:"model,omitempty"`
+	URL         string  `json:"url,omitempty"`
+	ChunkTokens int     `json:"chunk_tokens,omitempty"`
+	TopK        int     `json:"top_k,omitempty"`
+	MinScore    float64 `json:"min_score,omitempty"`
+}
+
+// Attachment is a document split into chunks and embedded. Attachments are
+// stored apart from sessions, which only name them, as the vectors are big.
+type Attachment struct {
+	Name   string            `json:"name"`
+	Source string            `json:"source"`
+	Model  string            `json:"model"`
+	Chunks []attachmentChunk `json:"chunks"`
+}
+
+type attachmentChunk struct {
+	Text   string    `json:"text"`
+	Vector []float64 `json:"vector"`
+}
+
+// attachments are the current session's documents, and retrievedChunks
+// the parts of them relevant to the latest message, for buildPrompt.
+var (
+	attachments     []*Attachment
+	retrievedChunks []string
+)
+
+func getAttachmentsDir() string {
+	return filepath.Join(getConfigDir(), AttachmentsDir)
+}
+
+func attachmentPath(name string) (string, error) {
+	if name == "" || name != filepath.Base(name) || strings.HasPrefix(name, ".") {
+		return "", fmt.Errorf("invalid attachment name %q", name)
+	}
+	return filepath.Join(getAttachmentsDir(), name+".json"), nil
+}
+
+func embeddingSettings(config *Config) EmbeddingsConfig {
+	var e EmbeddingsConfig
+	if config.Embeddings != nil {
+		e = *config.Embeddings
+	}
+	if e.Model == "" {
+		e.Model = DefaultEmbedModel
+	}
+	if e.URL == "" {
+		e.URL = backendBaseURL(config.URL) + "/api/embed"
+	}
+	if e.ChunkTokens <= 0 {
+		e.ChunkTokens = DefaultChunkTokens
+	}
+	if e.TopK <= 0 {
+		e.TopK = DefaultRetrieveTopK
+	}
+	if e.MinScore <= 0 {
+		e.MinScore = DefaultMinScore
+	}
+	return e
+}
+
+func handleAttachCommand(client *http.Client, config *Config, args string) {
+	fields := strings.Fields(args)
+	switch {
+	case len(fields) == 0:
+		if len(attachments) == 0 {
+			fmt.Println("No documents attached. Attach one using: /attach {file}")
+			return
+		}
+		fmt.Println("\n[Attachments]:")
+		for _, a := range attachments {
+			fmt.Printf("- %s (%d chunks, from %s)\n", a.Name, len(a.Chunks), a.Source)
+		}
+		fmt.Println("Detach one using: /attach remove {name}")
+	case fields[0] == "remove" && len(fields) == 2:
+		for i, a := range attachments {
+			if a.Name == fields[1] {
+				attachments = append(attachments[:i], attachments[i+1:]...)
+				retrievedChunks = nil
+				fmt.Printf("Detached '%s'.\n", a.Name)
+				return
+			}
+		}
+		fmt.Printf("No attachment named '%s'.\n", fields[1])
+	default:
+		attachFile(client, config, strings.Trim(strings.TrimSpace(args), "\""))
+	}
+}
+
+// attachFile chunks and embeds a text or PDF file and adds it to the
+// session.
+func attachFile(client *http.Client, config *Config, path string) {
+	text, err := readDocument(path)
+	if err != nil {
+		printError("Error reading document:", err)
+		return
+	}
+	name := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	for _, a := range attachments {
+		if a.Name == name {
+			fmt.Printf("'%s' is already attached.\n", name)
+			return
+		}
+	}
+	settings := embeddingSettings(config)
+	chunks := splitInput(text, settings.ChunkTokens)
+	if len(chunks) == 0 {
+		fmt.Println("The document has no text.")
+		return
+	}
+
+	fmt.Printf("Embedding %d chunks using %s...\n", len(chunks), settings.Model)
+	attachment := &Attachment{Name: name, Source: path, Model: settings.Model}
+	for start := 0; start < len(chunks); start += embedBatch {
+		end := min(start+embedBatch, len(chunks))
+		vectors, err := embed(client, settings, chunks[start:end])
+		if err != nil {
+			printError("Error embedding document:", err)
+			fmt.Printf("Is the model installed? Download it using: ollama pull %s\n", settings.Model)
+			return
+		}
+		for i, vector := range vectors {
+			attachment.Chunks = append(attachment.Chunks, attachmentChunk{Text: chunks[start+i], Vector: vector})
+		}
+	}
+	if err := saveAttachment(attachment); err != nil {
+		printError("Error saving attachment:", err)
+		return
+	}
+	attachments = append(attachments, attachment)
+	fmt.Printf("Attached '%s'. Relevant parts will be brought into the chat as it touches them.\n", name)
+}
+
+// readDocument returns the text of a file, converting PDFs with pdftotext
+// from poppler.
+func readDocument(path string) (string, error) {
+	if strings.EqualFold(filepath.Ext(path), ".pdf") {
+		out, err := exec.Command("pdftotext", "-layout", path, "-").Output()
+		if err != nil {
+			if _, lookErr := exec.LookPath("pdftotext"); lookErr != nil {
+				return "", fmt.Errorf("reading PDFs needs pdftotext (poppler-utils)")
+			}
+			return "", err
+		}
+		return string(out), nil
+	}
+	data, err := ioutil.ReadFile(path)
+	return string(data), err
+}
+
+// embed returns the embedding of each text, using Ollama's /api/embed.
+func embed(client *http.Client, settings EmbeddingsConfig, texts []string) ([][]float64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), embedTimeout)
+	defer cancel()
+	var result struct {
+		Embeddings [][]float64 `json:"embeddings"`
+	}
+	if err := postJSON(ctx, client, settings.URL, map[string]interface{}{"model": settings.Model, "input": texts}, &result); err != nil {
+		return nil, err
+	}
+	if len(result.Embeddings) != len(texts) {
+		return nil, fmt.Errorf("expected %d embeddings, got %d", len(texts), len(result.Embeddings))
+	}
+	return result.Embeddings, nil
+}
+
+func saveAttachment(attachment *Attachment) error {
+	path, err := attachmentPath(attachment.Name)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(getAttachmentsDir(), os.ModePerm); err != nil {
+		return err
+	}
+	data, _ := json.Marshal(attachment)
+	return ioutil.WriteFile(path, data, 0644)
+}
+
+// loadAttachments reads a restored session's attachments, skipping ones
+// that are gone.
+func loadAttachments(names []string) []*Attachment {
+	var loaded []*Attachment
+	for _, name := range names {
+		path, err := attachmentPath(name)
+		if err != nil {
+			continue
+		}
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			printErrorf("Error loading attachment '%s': %v\n", name, err)
+			continue
+		}
+		var attachment Attachment
+		if err := json.Unmarshal(data, &attachment); err != nil {
+			printErrorf("Error loading attachment '%s': %v\n", name, err)
+			continue
+		}
+		loaded = append(loaded, &attachment)
+	}
+	return loaded
+}
+
+func attachmentNames() []string {
+	var names []string
+	for _, a := range attachments {
+		names = append(names, a.Name)
+	}
+	return names
+}
+
+// retrieveContext finds the attached chunks most like the latest message
+// and keeps them for buildPrompt. Errors leave the chat without them
+// rather than stopping it.
+func retrieveContext(client *http.Client, config *Config, debug bool) {
+	retrievedChunks = nil
+	if len(attachments) == 0 || len(messageHistory) == 0 {
+		return
+	}
+	settings := embeddingSettings(config)
+	vectors, err := embed(client, settings, []string{messageHistory[len(messageHistory)-1].Content})
+	if err != nil {
+		if debug {
+			notice("[Debug] Retrieval failed: %v\n", err)
+		}
+		return
+	}
+
+	type match struct {
+		text   string
+		source string
+		score  float64
+	}
+	var matches []match
+	for _, a := range attachments {
+		if a.Model != settings.Model {
+			continue
+		}
+		for _, chunk := range a.Chunks {
+			if score := cosineSimilarity(vectors[0], chunk.Vector); score >= settings.MinScore {
+				matches = append(matches, match{chunk.Text, a.Name, score})
+			}
+		}
+	}
+	sort.Slice(matches, func(i, j int) bool { return matches[i].score > matches[j].score })
+	if len(matches) > settings.TopK {
+		matches = matches[:settings.TopK]
+	}
+	for _, m := range matches {
+		retrievedChunks = append(retrievedChunks, m.text)
+		if debug {
+			notice("[Debug] Retrieved from %s (%.2f): %s\n", m.source, m.score, truncateText(m.text, 60))
+		}
+	}
+}
+
+func cosineSimilarity(a, b []float64) float64 {
+	if len(a) != len(b) {
+		return 0
+	}
+	var dot, na, nb float64
+	for i := range a {
+		dot += a[i] * b[i]
+		na += a[i] * a[i]
+		nb += b[i] * b[i]
+	}
+	if na == 0 || nb == 0 {
+		return 0
+	}
+	return dot / (math.Sqrt(na) * math.Sqrt(nb))
+}
+
+// retrievedPrompt is the system prompt section holding retrieved chunks.
+func retrievedPrompt() string {
+	if len(retrievedChunks) == 0 {
+		return ""
+	}
+	return "Reference material from the user's documents, relevant to the conversation:\n\n" + strings.Join(retrievedChunks, "\n\n---\n\n")
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// In raw mode messages go to the model without the system prompt or the
+// character definition. Raw exchanges are kept apart from the roleplay and
+// dropped when raw mode ends, so they never leak into the story.
+var (
+	rawMode    bool
+	rawHistory []Message
+)
+
+func handleRawCommand(option string) {
+	switch option {
+	case "on":
+		rawMode = true
+		rawHistory = nil
+		fmt.Println("Raw mode on. Messages go straight to the model, out of character. Resume with /raw off")
+	case "off":
+		rawMode = false
+		rawHistory = nil
+		fmt.Println("Raw mode off. Back to the roleplay.")
+	default:
+		state := "off"
+		if rawMode {
+			state = "on"
+		}
+		fmt.Printf("Raw mode is %s. Usage: /raw on|off\n", state)
+	}
+}
+
+// sendRawMessage asks the model a plain question. The roleplay so far is
+// included so requests like "summarize this" have something to work on.
+func sendRawMessage(client *http.Client, config *Config, input string, debug bool) {
+	rawHistory = append(rawHistory, Message{Role: "user", Content: input})
+	messages := append(copyHistory(messageHistory), rawHistory...)
+
+	response, err := requestReply(client, config, messages, debug)
+	if err != nil {
+		printError("\nRequest error:", err)
+		rawHistory = rawHistory[:len(rawHistory)-1]
+		return
+	}
+	rawHistory = append(rawHistory, Message{Role: "assistant", Content: response})
+	fmt.Printf("\nModel: %s\n", response)
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// regenerateReply rerolls the last assistant message and shows a word diff
+// against the previous one so the user can decide which to keep.
+func regenerateReply(client *http.Client, config *Config, debug bool) {
+	n := len(messageHistory)
+	if n < 2 || messageHistory[n-1].Role != "assistant" {
+		fmt.Println("Nothing to regenerate.")
+		return
+	}
+
+	old := messageHistory[n-1]
+	setHistory(messageHistory[:n-1])
+	response, err := sendChatRequest(client, config, debug)
+	if err != nil {
+		printError("Request error:", err)
+		discardReply()
+		setHistory(append(messageHistory, old))
+		return
+	}
+	if checkReplies(config) {
+		checked, ok := checkReply(client, config, lastUserInput(messageHistory), response, debug)
+		if !ok {
+			discardReply()
+			setHistory(append(messageHistory, old))
+			return
+		}
+		response = checked
+	}
+
+	displayResponse(response, config)
+	fmt.Println("\n[Changes]:")
+	fmt.Println(wordDiff(old.Content, response))
+
+	answer := promptUserForInput("\nKeep the new reply? (y/n)", "y")
+	if strings.HasPrefix(strings.ToLower(answer), "y") {
+		acceptReply(config)
+		appendMessage("assistant", response)
+		fmt.Println("New reply kept.")
+		return
+	}
+	discardReply()
+	setHistory(append(messageHistory, old))
+	fmt.Println("Previous reply kept.")
+}
+
+// wordDiff renders the difference between two texts word by word, marking
+// removed words as [-word-] and added words as {+word+}.
+func wordDiff(a, b string) string {
+	oldWords, newWords := strings.Fields(a), strings.Fields(b)
+
+	// lcs[i][j] is the length of the longest common subsequence of
+	// oldWords[i:] and newWords[j:].
+	lcs := make([][]int, len(oldWords)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(newWords)+1)
+	}
+	for i := len(oldWords) - 1; i >= 0; i-- {
+		for j := len(newWords) - 1; j >= 0; j-- {
+			if oldWords[i] == newWords[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	var out []string
+	var removed, added []string
+	flush := func() {
+		if len(removed) > 0 {
+			out = append(out, "[-"+strings.Join(removed, " ")+"-]")
+			removed = nil
+		}
+		if len(added) > 0 {
+			out = append(out, "{+"+strings.Join(added, " ")+"+}")
+			added = nil
+		}
+	}
+
+	i, j := 0, 0
+	for i < len(oldWords) || j < len(newWords) {
+		switch {
+		case i < len(oldWords) && j < len(newWords) && oldWords[i] == newWords[j]:
+			flush()
+			out = append(out, oldWords[i])
+			i++
+			j++
+		case j < len(newWords) && (i == len(oldWords) || lcs[i][j+1] >= lcs[i+1][j]):
+			added = append(added, newWords[j])
+			j++
+		default:
+			removed = append(removed, oldWords[i])
+			i++
+		}
+	}
+	flush()
+	return strings.Join(out, " ")
+}
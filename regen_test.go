@@ -0,0 +1,24 @@
+package main
+
+import "testing"
+
+func TestWordDiff(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want string
+	}{
+		{"", "", ""},
+		{"she waves", "she waves", "she waves"},
+		{"", "hello there", "{+hello there+}"},
+		{"hello there", "", "[-hello there-]"},
+		{"she waves at you", "she smiles at you", "she [-waves-] {+smiles+} at you"},
+		{"a b c", "a c", "a [-b-] c"},
+		{"a c", "a b c", "a {+b+} c"},
+		{"the  old\nline", "the old line", "the old line"},
+	}
+	for _, tt := range tests {
+		if got := wordDiff(tt.a, tt.b); got != tt.want {
+			t.Errorf("wordDiff(%q, %q) = %q, want %q", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
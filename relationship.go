@@ -0,0 +1,125 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const (
+	DefaultRelationshipLabel = "trusts"
+	DefaultRelationshipMax   = 10
+	maxRelationshipStep      = 2
+)
+
+// RelationshipConfig turns on a relationship meter: a score the model
+// adjusts each reply with a hidden tag, as in "the character trusts the
+// user 7/10". Label is the verb, e.g. "trusts" or "likes".
+type RelationshipConfig struct {
+	Label string `json:"label,omitempty"`
+	Max   int    `json:"max,omitempty"`
+	// Start is the score of a new chat; by default half of Max.
+	Start *int `json:"start,omitempty"`
+}
+
+var relationshipTag = regexp.MustCompile(`(?i)\s*<affinity>\s*([+-]?\d+)\s*</affinity>\s*`)
+
+// relationshipTagOpen starts the tag, for holding it back while a reply
+// is streamed.
+const relationshipTagOpen = "<affinity>"
+
+// relationshipScore is the meter for the current chat, or nil before the
+// first reply with the meter on. pendingAffinity is the change asked for
+// by the reply that is waiting to be accepted.
+var (
+	relationshipScore *int
+	pendingAffinity   int
+)
+
+func relationshipSettings(config *Config) (string, int, int) {
+	r := config.Relationship
+	label, limit := r.Label, r.Max
+	if label == "" {
+		label = DefaultRelationshipLabel
+	}
+	if limit <= 0 {
+		limit = DefaultRelationshipMax
+	}
+	start := limit / 2
+	if r.Start != nil {
+		start = max(0, min(*r.Start, limit))
+	}
+	return label, limit, start
+}
+
+func currentRelationship(config *Config) int {
+	_, _, start := relationshipSettings(config)
+	if relationshipScore == nil {
+		return start
+	}
+	return *relationshipScore
+}
+
+// relationshipPrompt tells the model the score and how to change it.
+func relationshipPrompt(config *Config) string {
+	if config.Relationship == nil {
+		return ""
+	}
+	label, limit, _ := relationshipSettings(config)
+	name := characterDisplayName(activeCharacter)
+	return fmt.Sprintf("%s currently %s the user %d/%d; let that show in how %s acts. "+
+		"At the very end of every reply, add a hidden tag for how this exchange changed it, from <affinity>-%d</affinity> to <affinity>+%d</affinity>, or <affinity>0</affinity> if it didn't.",
+		name, label, currentRelationship(config), limit, name, maxRelationshipStep, maxRelationshipStep)
+}
+
+// takeRelationshipTag removes the tags from a reply, keeping the change
+// the last one asks for until the reply is accepted.
+func takeRelationshipTag(content string) string {
+	matches := relationshipTag.FindAllStringSubmatch(content, -1)
+	if len(matches) == 0 {
+		return content
+	}
+	delta, _ := strconv.Atoi(matches[len(matches)-1][1])
+	pendingAffinity = max(-maxRelationshipStep, min(delta, maxRelationshipStep))
+	return strings.TrimSpace(relationshipTag.ReplaceAllString(content, " "))
+}
+
+// applyPendingAffinity applies the change from the reply just accepted.
+func applyPendingAffinity(config *Config) {
+	delta := pendingAffinity
+	pendingAffinity = 0
+	if config.Relationship == nil || delta == 0 {
+		return
+	}
+	label, limit, _ := relationshipSettings(config)
+	score := max(0, min(currentRelationship(config)+delta, limit))
+	relationshipScore = &score
+	notice("[Relationship]: %s %s you %d/%d (%+d)\n", characterDisplayName(activeCharacter), label, score, limit, delta)
+}
+
+// handleRelationshipCommand shows the meter or sets it.
+func handleRelationshipCommand(args string, config *Config) {
+	if config.Relationship == nil {
+		fmt.Println("The relationship meter is off. Turn it on with \"relationship\": {} in the config.")
+		return
+	}
+	label, limit, _ := relationshipSettings(config)
+	fields := strings.Fields(args)
+	switch {
+	case len(fields) == 0:
+		score := currentRelationship(config)
+		fmt.Printf("[Relationship]: %s %s you %d/%d %s\n", characterDisplayName(activeCharacter), label, score, limit,
+			strings.Repeat("█", score)+strings.Repeat("░", limit-score))
+	case len(fields) == 2 && fields[0] == "set":
+		score, err := strconv.Atoi(fields[1])
+		if err != nil || score < 0 || score > limit {
+			fmt.Printf("Use a score from 0 to %d.\n", limit)
+			return
+		}
+		relationshipScore = &score
+		fmt.Printf("%s now %s you %d/%d.\n", characterDisplayName(activeCharacter), label, score, limit)
+	default:
+		fmt.Println("Usage: /relationship [set {score}]")
+	}
+}
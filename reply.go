@@ -0,0 +1,40 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// maxQuoteLen is how much of the message /reply quotes.
+const maxQuoteLen = 200
+
+// handleReplyCommand handles /reply {number} {text}, sending text as the
+// next message with the earlier one quoted, so the model knows what it
+// refers to. Messages are numbered as in /hist.
+func handleReplyCommand(args string) {
+	number, text, _ := strings.Cut(strings.TrimSpace(args), " ")
+	n, err := strconv.Atoi(number)
+	text = strings.TrimSpace(text)
+	if err != nil || text == "" {
+		fmt.Println("Usage: /reply {number} {message}, numbered as in /hist")
+		return
+	}
+	if n < 1 || n > len(messageHistory) {
+		fmt.Printf("There is no message %d. The chat has %d messages.\n", n, len(messageHistory))
+		return
+	}
+	pendingInputs = append([]string{quoteMessage(messageHistory[n-1]) + text}, pendingInputs...)
+}
+
+// quoteMessage introduces a reply to msg.
+func quoteMessage(msg Message) string {
+	what := "my earlier message"
+	switch msg.Role {
+	case "assistant":
+		what = characterDisplayName(activeCharacter)
+	case "system":
+		what = "the story notes"
+	}
+	return fmt.Sprintf("[Replying to %s: \"%s\"]\n", what, truncateText(msg.Content, maxQuoteLen))
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"net"
+	"net/http"
+	"time"
+)
+
+const (
+	DefaultMaxAttempts = 3
+
+	retryBaseDelay = 500 * time.Millisecond
+	retryMaxDelay  = 8 * time.Second
+)
+
+// retryableError marks failures worth another attempt: the backend could
+// not be reached or answered with a server error.
+type retryableError struct {
+	err error
+}
+
+func (e *retryableError) Error() string { return e.err.Error() }
+func (e *retryableError) Unwrap() error { return e.err }
+
+// requestError marks a failed request as retryable unless it timed out: a
+// backend that took too long once would most likely take too long again,
+// and every attempt waits out the whole timeout.
+func requestError(err error) error {
+	var netErr net.Error
+	if errors.Is(err, errReadTimeout) || errors.As(err, &netErr) && netErr.Timeout() {
+		return err
+	}
+	return &retryableError{err}
+}
+
+// chatCompletionWithRetry sends data, retrying transient failures with
+// exponential backoff and jitter up to config.MaxAttempts times.
+func chatCompletionWithRetry(ctx context.Context, client *http.Client, config *Config, data ChatMessage, debug bool) (ChatResult, error) {
+	attempts := config.MaxAttempts
+	if attempts <= 0 {
+		attempts = DefaultMaxAttempts
+	}
+
+	var err error
+	for attempt := 1; ; attempt++ {
+		var result ChatResult
+		result, err = chatCompletion(ctx, client, config.URL, data)
+
+		var retryable *retryableError
+		if err == nil || !errors.As(err, &retryable) || attempt >= attempts {
+			return result, err
+		}
+
+		delay := retryDelay(attempt)
+		if debug {
+			notice("[Debug] Attempt %d of %d failed: %v. Retrying in %s.\n", attempt, attempts, err, delay.Round(time.Millisecond))
+		}
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return ChatResult{}, errGenerationCancelled
+		}
+	}
+}
+
+// retryDelay doubles the wait after every failed attempt and adds up to the
+// same amount again as jitter.
+func retryDelay(attempt int) time.Duration {
+	// Doubling stops at the cap, so many attempts can't overflow the delay.
+	delay := retryBaseDelay
+	for i := 1; i < attempt && delay < retryMaxDelay; i++ {
+		delay *= 2
+	}
+	delay = min(delay, retryMaxDelay)
+	return delay + time.Duration(rand.Int63n(int64(delay)))
+}
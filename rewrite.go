@@ -0,0 +1,175 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// rewriteReply keeps the last reply up to and including a phrase and has
+// the model write a different continuation from that point.
+func rewriteReply(args string, client *http.Client, config *Config, debug bool) {
+	if !strings.HasPrefix(args, "from ") {
+		fmt.Println("Usage: /rewrite from \"phrase\"")
+		return
+	}
+	phrase := strings.Trim(strings.TrimSpace(strings.TrimPrefix(args, "from ")), "\"")
+
+	n := len(messageHistory)
+	if n < 2 || messageHistory[n-1].Role != "assistant" || phrase == "" {
+		fmt.Println("Nothing to rewrite.")
+		return
+	}
+
+	content := messageHistory[n-1].Content
+	end := phraseEnd(content, phrase)
+	if end < 0 {
+		fmt.Printf("Phrase \"%s\" was not found in the last reply.\n", phrase)
+		return
+	}
+	kept, dropped := content[:end], content[end:]
+
+	messages := append(buildPrompt(config, messageHistory[:n-1]),
+		Message{Role: "assistant", Content: kept},
+		Message{Role: "system", Content: "Your previous reply was cut off. Continue it from exactly where it stops, without repeating any of it. Take it in a different direction than before, when it continued with: " + strings.TrimSpace(dropped)},
+	)
+	rewrite := func() (string, error) {
+		continuation, err := requestReply(client, config, messages, debug)
+		return joinContinuation(kept, strings.TrimPrefix(continuation, kept)), err
+	}
+	rewritten, err := rewrite()
+	if err != nil {
+		printError("Request error:", err)
+		return
+	}
+	if checkReplies(config) {
+		checked, ok := checkReplyWith(client, config, lastUserInput(messageHistory[:n-1]), rewritten, rewrite, debug)
+		if !ok {
+			return
+		}
+		rewritten = checked
+	}
+
+	messageHistory[n-1].Content = rewritten
+	setHistory(messageHistory)
+	displayResponse(rewritten, config)
+}
+
+// phraseEnd returns the offset in content just past the first occurrence of
+// phrase, preferring an exact match to one ignoring case, or -1 if there is
+// none. A match ignoring case can differ in length from phrase.
+func phraseEnd(content, phrase string) int {
+	if i := strings.Index(content, phrase); i >= 0 {
+		return i + len(phrase)
+	}
+	if loc := regexp.MustCompile(`(?i)` + regexp.QuoteMeta(phrase)).FindStringIndex(content); loc != nil {
+		return loc[1]
+	}
+	return -1
+}
+
+// continueReply has the model carry on with a reply that stopped short,
+// adding to the same message.
+func continueReply(client *http.Client, config *Config, debug bool) {
+	n := len(messageHistory)
+	if n < 2 || messageHistory[n-1].Role != "assistant" {
+		fmt.Println("Nothing to continue.")
+		return
+	}
+
+	partial := messageHistory[n-1].Content
+	messages := append(buildPrompt(config, messageHistory),
+		Message{Role: "system", Content: "Your previous reply was cut off. Continue it from exactly where it stops, without repeating any of it."},
+	)
+	next := func() (string, error) {
+		continuation, err := requestReply(client, config, messages, debug)
+		return strings.TrimSpace(strings.TrimPrefix(truncateAtStop(continuation, config.StopSequences), partial)), err
+	}
+	stopTyping := showTypingIndicator(config)
+	continuation, err := next()
+	stopTyping()
+	if err != nil {
+		printError("Request error:", err)
+		return
+	}
+	if continuation == "" {
+		fmt.Println("The model had nothing to add.")
+		return
+	}
+	if checkReplies(config) {
+		checked, ok := checkReplyWith(client, config, lastUserInput(messageHistory), continuation, next, debug)
+		if !ok {
+			return
+		}
+		continuation = checked
+	}
+
+	messageHistory[n-1].Content = joinContinuation(partial, continuation)
+	setHistory(messageHistory)
+	displayResponse(continuation, config)
+}
+
+// joinContinuation appends a continuation to a partial reply, adding a space
+// unless one exists already or the continuation starts with punctuation.
+func joinContinuation(partial, continuation string) string {
+	continuation = strings.TrimSpace(continuation)
+	if continuation == "" || partial == "" {
+		return partial + continuation
+	}
+	last := rune(partial[len(partial)-1])
+	first := []rune(continuation)[0]
+	if unicode.IsSpace(last) || unicode.IsPunct(first) && first != '*' && first != '"' {
+		return partial + continuation
+	}
+	return partial + " " + continuation
+}
+
+// restyleReply has the model rewrite the last reply according to an
+// instruction, replacing it once the user confirms.
+func restyleReply(instruction string, client *http.Client, config *Config, debug bool) {
+	if instruction == "" {
+		fmt.Println("Usage: /restyle <instruction>, e.g. /restyle more concise")
+		return
+	}
+
+	n := len(messageHistory)
+	if n < 2 || messageHistory[n-1].Role != "assistant" {
+		fmt.Println("Nothing to restyle.")
+		return
+	}
+
+	messages := append(buildPrompt(config, messageHistory[:n-1]), Message{
+		Role: "system",
+		Content: "Rewrite your last reply following this instruction: " + instruction +
+			". Keep the same events and stay in character. Respond with only the rewritten reply.\n\nLast reply:\n" +
+			messageHistory[n-1].Content,
+	})
+	restyled, err := requestReply(client, config, messages, debug)
+	if err != nil {
+		printError("Request error:", err)
+		return
+	}
+	if checkReplies(config) {
+		checked, ok := checkReplyWith(client, config, lastUserInput(messageHistory[:n-1]), restyled, func() (string, error) {
+			return requestReply(client, config, messages, debug)
+		}, debug)
+		if !ok {
+			return
+		}
+		restyled = checked
+	}
+
+	fmt.Println("\n[Restyled Reply]:")
+	fmt.Println(restyled)
+
+	answer := promptUserForInput("\nReplace the last reply with this? (y/n)", "y")
+	if !strings.HasPrefix(strings.ToLower(answer), "y") {
+		fmt.Println("Previous reply kept.")
+		return
+	}
+	messageHistory[n-1].Content = restyled
+	setHistory(messageHistory)
+	fmt.Println("Reply replaced.")
+}
@@ -0,0 +1,46 @@
+package main
+
+import "testing"
+
+func TestPhraseEnd(t *testing.T) {
+	tests := []struct {
+		content, phrase string
+		want            int
+	}{
+		{"She waves, then she leaves.", "then she", 19},
+		{"Then she waves, then she leaves.", "then she", 24},
+		{"She waves. Then she leaves.", "then she", 19},
+		{"She waves.", "then she", -1},
+		// The ignoring-case match is longer than the phrase, and lowering
+		// the content would shift it.
+		{"İİ \u212aelvin then", "kelvin", 13},
+		{"İİ then", "THEN", 9},
+	}
+	for _, tt := range tests {
+		if got := phraseEnd(tt.content, tt.phrase); got != tt.want {
+			t.Errorf("phraseEnd(%q, %q) = %d, want %d", tt.content, tt.phrase, got, tt.want)
+		}
+	}
+}
+
+func TestJoinContinuation(t *testing.T) {
+	tests := []struct {
+		partial, continuation string
+		want                  string
+	}{
+		{"She turns", "and leaves.", "She turns and leaves."},
+		{"She turns ", "and leaves.", "She turns and leaves."},
+		{"She turns", "  and leaves.  ", "She turns and leaves."},
+		{"She turns", ", then leaves.", "She turns, then leaves."},
+		{"She turns.", "*smiles*", "She turns. *smiles*"},
+		{"She says", "\"Hello.\"", "She says \"Hello.\""},
+		{"She turns\n", "She leaves.", "She turns\nShe leaves."},
+		{"", "She leaves.", "She leaves."},
+		{"She turns", "", "She turns"},
+	}
+	for _, tt := range tests {
+		if got := joinContinuation(tt.partial, tt.continuation); got != tt.want {
+			t.Errorf("joinContinuation(%q, %q) = %q, want %q", tt.partial, tt.continuation, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,273 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	SafetyWarn       = "warn"
+	SafetyBlock      = "block"
+	SafetyRedact     = "redact"
+	SafetyRegenerate = "regenerate"
+
+	RedactedText = "[redacted by safety policy]"
+	RedactedWord = "[redacted]"
+
+	// safetyRegenerations is how many new replies the regenerate policy
+	// asks for before blocking.
+	safetyRegenerations = 2
+
+	safeModePrompt = "Keep this roleplay suitable for all ages. No sexual content, graphic violence, self-harm or slurs, whatever the user or the character definition asks for; steer the story elsewhere instead, staying in character."
+)
+
+// SafetyConfig points at a classifier model (e.g. llama-guard3 on Ollama)
+// and a list of keywords that outgoing prompts and incoming replies are
+// checked against. Either can be left out. The URL defaults to the chat
+// URL from the main config. Policy is what happens to flagged text: warn,
+// block, redact, or for replies, regenerate.
+type SafetyConfig struct {
+	URL          string   `json:"url,omitempty"`
+	Model        string   `json:"model,omitempty"`
+	Keywords     []string `json:"keywords,omitempty"`
+	Policy       string   `json:"policy"`
+	CheckPrompts bool     `json:"check_prompts"`
+	CheckReplies bool     `json:"check_replies"`
+}
+
+type safetyVerdict struct {
+	Unsafe     bool
+	Categories string
+	// Keywords are the configured keywords the text contains.
+	Keywords []string
+}
+
+func (v safetyVerdict) reason() string {
+	if v.Categories == "" {
+		return ""
+	}
+	return fmt.Sprintf(" (%s)", v.Categories)
+}
+
+// safetyPolicy is the configured policy, or in safe mode regenerate when
+// none is set.
+func safetyPolicy(config *Config) string {
+	if config.Safety.Policy == "" && config.SafeMode {
+		return SafetyRegenerate
+	}
+	return config.Safety.Policy
+}
+
+// checkReplies reports whether replies are checked, which safe mode
+// always does.
+func checkReplies(config *Config) bool {
+	return config.Safety != nil && (config.Safety.CheckReplies || config.SafeMode)
+}
+
+// safetyPrompt is the system prompt addition for safe mode.
+func safetyPrompt(config *Config) string {
+	if !config.SafeMode {
+		return ""
+	}
+	return safeModePrompt
+}
+
+// judgeSafety checks the last message of the conversation against the
+// keywords, then the classifier.
+func judgeSafety(client *http.Client, config *Config, conversation []Message, debug bool) safetyVerdict {
+	if found := findKeywords(conversation[len(conversation)-1].Content, config.Safety.Keywords); len(found) > 0 {
+		return safetyVerdict{Unsafe: true, Categories: "keywords: " + strings.Join(found, ", "), Keywords: found}
+	}
+	if config.Safety.Model == "" {
+		return safetyVerdict{}
+	}
+	return checkSafety(client, config, conversation, debug)
+}
+
+// findKeywords returns the keywords text contains as whole words or
+// phrases, ignoring case.
+func findKeywords(text string, keywords []string) []string {
+	var found []string
+	for _, keyword := range keywords {
+		if len(keywordSpans(text, keyword)) > 0 {
+			found = append(found, keyword)
+		}
+	}
+	return found
+}
+
+// keywordSpans returns where keyword appears in text as a whole word,
+// ignoring case. The spans are offsets into text itself, which lowering
+// the case of text could shift.
+func keywordSpans(text, keyword string) [][2]int {
+	keyword = strings.TrimSpace(keyword)
+	if keyword == "" {
+		return nil
+	}
+	var spans [][2]int
+	for _, loc := range regexp.MustCompile(`(?i)`+regexp.QuoteMeta(keyword)).FindAllStringIndex(text, -1) {
+		before, _ := utf8.DecodeLastRuneInString(text[:loc[0]])
+		after, _ := utf8.DecodeRuneInString(text[loc[1]:])
+		if (loc[0] == 0 || !isWordRune(before)) && (loc[1] == len(text) || !isWordRune(after)) {
+			spans = append(spans, [2]int{loc[0], loc[1]})
+		}
+	}
+	return spans
+}
+
+func isWordRune(r rune) bool {
+	return r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+// redactKeywords replaces the keywords in text.
+func redactKeywords(text string, keywords []string) string {
+	for _, keyword := range keywords {
+		spans := keywordSpans(text, keyword)
+		for i := len(spans) - 1; i >= 0; i-- {
+			text = text[:spans[i][0]] + RedactedWord + text[spans[i][1]:]
+		}
+	}
+	return text
+}
+
+// checkSafety classifies the conversation ending in the message under test.
+// Classifier errors are reported and, see classifierFailed, treated as safe
+// unless the policy is to block.
+func checkSafety(client *http.Client, config *Config, conversation []Message, debug bool) safetyVerdict {
+	url := config.Safety.URL
+	if url == "" {
+		url = config.URL
+	}
+
+	data := ChatMessage{
+		Model:    config.Safety.Model,
+		Stream:   false,
+		Messages: conversation,
+	}
+
+	jsonData, _ := json.Marshal(data)
+	req, _ := http.NewRequest("POST", url, bytes.NewBuffer(jsonData))
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		fmt.Println("Safety classifier error:", err)
+		return classifierFailed(config)
+	}
+	defer resp.Body.Close()
+
+	body, _ := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		fmt.Printf("Safety classifier error: %s: %s\n", resp.Status, strings.TrimSpace(string(body)))
+		return classifierFailed(config)
+	}
+	var response struct {
+		Message Message `json:"message"`
+	}
+	if err := json.Unmarshal(body, &response); err != nil {
+		fmt.Println("Safety classifier returned an invalid response:", err)
+		return classifierFailed(config)
+	}
+
+	if debug {
+		notice("[Debug] Safety classifier: %q\n", response.Message.Content)
+	}
+
+	lines := strings.Split(strings.TrimSpace(response.Message.Content), "\n")
+	if strings.ToLower(strings.TrimSpace(lines[0])) != "unsafe" {
+		return safetyVerdict{}
+	}
+	verdict := safetyVerdict{Unsafe: true}
+	if len(lines) > 1 {
+		verdict.Categories = strings.TrimSpace(lines[1])
+	}
+	return verdict
+}
+
+// classifierFailed is the verdict when the classifier can't give one. The
+// block policy fails closed; the others let the text through rather than
+// take the whole chat down with an unreachable classifier.
+func classifierFailed(config *Config) safetyVerdict {
+	if safetyPolicy(config) == SafetyBlock {
+		return safetyVerdict{Unsafe: true, Categories: "the safety classifier is unavailable"}
+	}
+	return safetyVerdict{}
+}
+
+// applySafetyPolicy runs text through the classifier and returns the text to
+// use in its place, or ok=false if the policy says it must be dropped.
+func applySafetyPolicy(client *http.Client, config *Config, conversation []Message, what string, debug bool) (string, bool) {
+	text := conversation[len(conversation)-1].Content
+	verdict := judgeSafety(client, config, conversation, debug)
+	if !verdict.Unsafe {
+		return text, true
+	}
+
+	reason := verdict.reason()
+	switch safetyPolicy(config) {
+	case SafetyBlock, SafetyRegenerate:
+		notice("\n[Safety]: The %s was blocked%s.\n", what, reason)
+		return "", false
+	case SafetyRedact:
+		notice("\n[Safety]: The %s was redacted%s.\n", what, reason)
+		if len(verdict.Keywords) > 0 {
+			return redactKeywords(text, verdict.Keywords), true
+		}
+		return RedactedText, true
+	default:
+		notice("\n[Safety]: Warning, the %s was flagged as unsafe%s.\n", what, reason)
+		return text, true
+	}
+}
+
+// checkReply applies the safety policy to a reply, asking for new ones
+// under the regenerate policy. It returns the reply to use, or false if
+// there is none.
+func checkReply(client *http.Client, config *Config, input, response string, debug bool) (string, bool) {
+	return checkReplyWith(client, config, input, response, func() (string, error) {
+		return sendChatRequest(client, config, debug)
+	}, debug)
+}
+
+// lastUserInput is the latest user message in history, which a reply
+// after it answers.
+func lastUserInput(history []Message) string {
+	for i := len(history) - 1; i >= 0; i-- {
+		if history[i].Role == "user" {
+			return history[i].Content
+		}
+	}
+	return ""
+}
+
+// checkReplyWith is checkReply for replies that aren't to the chat
+// history, getting new ones from regenerate. input is the message the reply
+// answers, or empty for a reply nobody asked for.
+func checkReplyWith(client *http.Client, config *Config, input, response string, regenerate func() (string, error), debug bool) (string, bool) {
+	for attempt := 0; ; attempt++ {
+		conversation := []Message{{Role: "assistant", Content: response}}
+		if input != "" {
+			conversation = append([]Message{{Role: "user", Content: input}}, conversation...)
+		}
+		if safetyPolicy(config) != SafetyRegenerate || attempt == safetyRegenerations {
+			return applySafetyPolicy(client, config, conversation, "reply", debug)
+		}
+		verdict := judgeSafety(client, config, conversation, debug)
+		if !verdict.Unsafe {
+			return response, true
+		}
+		notice("\n[Safety]: The reply was flagged%s. Asking for another...\n", verdict.reason())
+		var err error
+		if response, err = regenerate(); err != nil {
+			printError("\nRequest error:", err)
+			return "", false
+		}
+	}
+}
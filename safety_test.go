@@ -0,0 +1,48 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestKeywordSpans(t *testing.T) {
+	tests := []struct {
+		text, keyword string
+		want          [][2]int
+	}{
+		{"a bad idea", "bad", [][2]int{{2, 5}}},
+		{"BAD, bad!", "bad", [][2]int{{0, 3}, {5, 8}}},
+		{"badly done", "bad", nil},
+		{"not_bad", "bad", nil},
+		{"a bad idea", "  ", nil},
+		{"café bad", "bad", [][2]int{{6, 9}}},
+		// Lowering the case of Ⱥ makes it longer, which must not shift the
+		// spans.
+		{"ȺȺȺȺ bad", "bad", [][2]int{{9, 12}}},
+		{"ȺȺȺȺ BAD", "bad", [][2]int{{9, 12}}},
+		{"a.b a+b", "a+b", [][2]int{{4, 7}}},
+	}
+	for _, tt := range tests {
+		if got := keywordSpans(tt.text, tt.keyword); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("keywordSpans(%q, %q) = %v, want %v", tt.text, tt.keyword, got, tt.want)
+		}
+	}
+}
+
+func TestRedactKeywords(t *testing.T) {
+	tests := []struct {
+		text     string
+		keywords []string
+		want     string
+	}{
+		{"a bad idea", []string{"bad"}, "a [redacted] idea"},
+		{"Bad and worse", []string{"bad", "worse"}, "[redacted] and [redacted]"},
+		{"badly", []string{"bad"}, "badly"},
+		{"ȺȺȺȺ bad", []string{"bad"}, "ȺȺȺȺ [redacted]"},
+	}
+	for _, tt := range tests {
+		if got := redactKeywords(tt.text, tt.keywords); got != tt.want {
+			t.Errorf("redactKeywords(%q, %q) = %q, want %q", tt.text, tt.keywords, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// chatScenario replaces the character's scenario for this chat, after
+// /scenario set. It is saved with the session.
+var chatScenario string
+
+func currentScenario() string {
+	if chatScenario != "" {
+		return chatScenario
+	}
+	return activeCharacter.Scenario
+}
+
+// scenarioPrompt describes the setting the chat takes place in.
+func scenarioPrompt(scenario string) string {
+	if scenario == "" {
+		return ""
+	}
+	return "Scenario: " + scenario
+}
+
+// handleScenarioCommand handles /scenario [set "..." | clear | save]:
+// changing the setting for this chat, and keeping it in the character.
+func handleScenarioCommand(args string) {
+	command, rest, _ := strings.Cut(strings.TrimSpace(args), " ")
+	switch command {
+	case "":
+		scenario := currentScenario()
+		if scenario == "" {
+			fmt.Println("No scenario. Set one for this chat using: /scenario set \"...\"")
+			return
+		}
+		fmt.Printf("\n[Scenario]: %s\n", scenario)
+		if chatScenario != "" && activeCharacter.Scenario != "" {
+			fmt.Println("This replaces the character's scenario for this chat. Go back to it using /scenario clear")
+		}
+	case "set":
+		scenario := strings.Trim(strings.TrimSpace(rest), "\"")
+		if scenario == "" {
+			fmt.Println("Usage: /scenario set \"...\"")
+			return
+		}
+		chatScenario = scenario
+		fmt.Println("Scenario set for this chat. Keep it in the character using /scenario save")
+	case "clear":
+		chatScenario = ""
+		fmt.Println("Back to the character's scenario.")
+	case "save":
+		activeCharacter.Scenario = currentScenario()
+		chatScenario = ""
+		if activeCharacter.Name == "" {
+			fmt.Println("Scenario kept. Save the character using /char save {name}")
+			return
+		}
+		if err := saveCharacter(activeCharacter); err != nil {
+			printError("Error saving character:", err)
+			return
+		}
+		fmt.Printf("Scenario saved to '%s'.\n", activeCharacter.Name)
+	default:
+		fmt.Println("Usage: /scenario [set \"...\" | clear | save]")
+	}
+}
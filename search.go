@@ -0,0 +1,120 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const maxSearchResults = 20
+
+type searchResult struct {
+	Session string
+	Index   int
+}
+
+// lastSearch holds the results of the last /search, for /search open.
+var lastSearch []searchResult
+
+// handleSearchCommand finds messages in saved sessions containing every
+// word of the query.
+func handleSearchCommand(args string, config *Config) {
+	fields := strings.Fields(args)
+	if len(fields) == 0 {
+		fmt.Println("Usage: /search {query}, then /search open {number} to load a result")
+		return
+	}
+	if fields[0] == "open" && len(fields) == 2 {
+		n, err := strconv.Atoi(fields[1])
+		if err != nil || n < 1 || n > len(lastSearch) {
+			fmt.Printf("No search result number %s.\n", fields[1])
+			return
+		}
+		result := lastSearch[n-1]
+		handleLoadCommand(result.Session, config)
+		fmt.Printf("The match is message %d of %d. Show it using /hist\n", result.Index+1, len(messageHistory))
+		return
+	}
+
+	sessions, err := listSessions()
+	if err != nil {
+		printError("Error listing sessions:", err)
+		return
+	}
+	terms := make([]string, len(fields))
+	for i, field := range fields {
+		terms[i] = strings.ToLower(field)
+	}
+
+	lastSearch = nil
+	total := 0
+	for _, session := range sessions {
+		for i, msg := range session.History {
+			if !containsAllTerms(strings.ToLower(msg.Content), terms) {
+				continue
+			}
+			total++
+			if len(lastSearch) >= maxSearchResults {
+				continue
+			}
+			lastSearch = append(lastSearch, searchResult{Session: session.Name, Index: i})
+
+			fmt.Printf("\n%d. %s, %s (message %d)\n", len(lastSearch), session.Name, session.Updated.Format("Jan 2 2006"), i+1)
+			if i > 0 {
+				prev := session.History[i-1]
+				fmt.Printf("   [%s]: %s\n", strings.Title(prev.Role), truncateText(prev.Content, 70))
+			}
+			fmt.Printf("   [%s]: %s\n", strings.Title(msg.Role), searchExcerpt(msg.Content, terms[0], 100))
+			if i+1 < len(session.History) {
+				next := session.History[i+1]
+				fmt.Printf("   [%s]: %s\n", strings.Title(next.Role), truncateText(next.Content, 70))
+			}
+		}
+	}
+
+	switch {
+	case total == 0:
+		fmt.Println("No matches.")
+	case total > len(lastSearch):
+		fmt.Printf("\nShowing %d of %d matches. Narrow the search to see the rest.\n", len(lastSearch), total)
+	}
+	if total > 0 {
+		fmt.Println("Load a result using: /search open {number}")
+	}
+}
+
+func containsAllTerms(text string, terms []string) bool {
+	for _, term := range terms {
+		if !strings.Contains(text, term) {
+			return false
+		}
+	}
+	return true
+}
+
+// searchExcerpt returns about n characters of text around the first match
+// of term.
+func searchExcerpt(text, term string, n int) string {
+	text = strings.Join(strings.Fields(text), " ")
+	runes := []rune(text)
+	if len(runes) <= n {
+		return text
+	}
+	at := len([]rune(text[:max(strings.Index(strings.ToLower(text), term), 0)]))
+	start := at - n/3
+	if start < 0 {
+		start = 0
+	}
+	end := start + n
+	if end > len(runes) {
+		end, start = len(runes), max(len(runes)-n, 0)
+	}
+	excerpt := string(runes[start:end])
+	if start > 0 {
+		excerpt = "..." + excerpt
+	}
+	if end < len(runes) {
+		excerpt += "..."
+	}
+	return excerpt
+}
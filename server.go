@@ -0,0 +1,265 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+type serverRequest struct {
+	User      string `json:"user"`
+	Character string `json:"character"`
+	Message   string `json:"message"`
+
+	// caller is who sent the request; see chatServer.caller.
+	caller string
+}
+
+type serverResponse struct {
+	Reply string `json:"reply,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+// chatServer exposes characters over HTTP. Every user gets a separate chat
+// with every character, kept in memory for the lifetime of the server.
+type chatServer struct {
+	config Config
+	client *http.Client
+	debug  bool
+	quotas *quotaTracker
+
+	mu       sync.Mutex
+	sessions map[string]*serverSession
+}
+
+type serverSession struct {
+	mu        sync.Mutex
+	character Character
+	history   []Message
+}
+
+func runServer(addr string, config Config, client *http.Client, debug bool) {
+	s := &chatServer{
+		config:   config,
+		client:   client,
+		debug:    debug,
+		sessions: map[string]*serverSession{},
+	}
+	if config.Quotas != nil {
+		s.quotas = newQuotaTracker()
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/chat", s.handleChat)
+	mux.HandleFunc("/api/reset", s.handleReset)
+	mux.HandleFunc("/api/characters", s.handleCharacters)
+
+	fmt.Printf("Serving Character.Chat %s on %s\n", AppVersion, addr)
+	if err := http.ListenAndServe(addr, mux); err != nil {
+		printError("Error running server:", err)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func (s *chatServer) decodeRequest(w http.ResponseWriter, r *http.Request) (serverRequest, bool) {
+	var req serverRequest
+	if r.Method != http.MethodPost {
+		writeJSON(w, http.StatusMethodNotAllowed, serverResponse{Error: "use POST"})
+		return req, false
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, serverResponse{Error: "invalid JSON: " + err.Error()})
+		return req, false
+	}
+	var ok bool
+	if req.caller, ok = s.caller(r); !ok {
+		writeJSON(w, http.StatusUnauthorized, serverResponse{Error: "a valid API key is required"})
+		return req, false
+	}
+	return req, true
+}
+
+// caller is who a request comes from: the user its API key belongs to, or
+// its address when no keys are configured. It returns false for a missing
+// or unknown key. Chats and quotas are kept per caller, since any client
+// can set the user in the request body; that only tells apart the users
+// of one caller, such as a frontend with a single key.
+func (s *chatServer) caller(r *http.Request) (string, bool) {
+	if s.config.Quotas == nil || len(s.config.Quotas.APIKeys) == 0 {
+		host, _, _ := net.SplitHostPort(r.RemoteAddr)
+		return host, true
+	}
+	key := strings.TrimSpace(strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer "))
+	if key == "" {
+		return "", false
+	}
+	user, ok := s.config.Quotas.APIKeys[key]
+	return user, ok
+}
+
+func sessionKey(req serverRequest) string {
+	return req.caller + "\x00" + req.User + "\x00" + req.Character
+}
+
+// session returns the chat between the request's user and character,
+// starting a new one with the character's greeting if needed.
+func (s *chatServer) session(req serverRequest) (*serverSession, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := sessionKey(req)
+	name := req.Character
+	if session, ok := s.sessions[key]; ok {
+		return session, nil
+	}
+
+	character := defaultCharacter(s.config)
+	if name != "" {
+		var err error
+		if character, err = loadCharacter(name); err != nil {
+			return nil, err
+		}
+	}
+
+	session := &serverSession{
+		character: character,
+		history:   []Message{{Role: "assistant", Content: pickGreeting(character)}},
+	}
+	s.sessions[key] = session
+	return session, nil
+}
+
+func (s *chatServer) handleChat(w http.ResponseWriter, r *http.Request) {
+	req, ok := s.decodeRequest(w, r)
+	if !ok {
+		return
+	}
+	if req.Message == "" {
+		writeJSON(w, http.StatusBadRequest, serverResponse{Error: "message is required"})
+		return
+	}
+
+	session, err := s.session(req)
+	if err != nil {
+		writeJSON(w, http.StatusNotFound, serverResponse{Error: "unknown character"})
+		return
+	}
+
+	now := time.Now()
+	var quotas []quotaCheck
+	if s.quotas != nil {
+		name := characterKey(session.character)
+		subject := name + " has"
+		if session.character.Name == "" {
+			subject = "This character has"
+		}
+		quotas = []quotaCheck{
+			{key: "user:" + req.caller, subject: "You have", limits: s.config.Quotas.userLimits(req.caller)},
+			{key: "character:" + name, subject: subject, limits: s.config.Quotas.characterLimits(name)},
+		}
+		if msg := s.quotas.reserve(now, quotas...); msg != "" {
+			writeJSON(w, http.StatusTooManyRequests, serverResponse{Error: msg})
+			return
+		}
+	}
+	// Until the reply is sent, the reserved message is given back on failure.
+	replied := false
+	defer func() {
+		if s.quotas != nil && !replied {
+			s.quotas.release(now, quotas...)
+		}
+	}()
+
+	message := req.Message
+	if s.config.Safety != nil && s.config.Safety.CheckPrompts {
+		checked, ok := applySafetyPolicy(s.client, &s.config, []Message{{Role: "user", Content: message}}, "message", s.debug)
+		if !ok {
+			writeJSON(w, http.StatusForbidden, serverResponse{Error: "message blocked by safety policy"})
+			return
+		}
+		message = checked
+	}
+
+	session.mu.Lock()
+	defer session.mu.Unlock()
+
+	history := append(session.history, Message{Role: "user", Content: message})
+	system := s.config.System
+	if safety := safetyPrompt(&s.config); safety != "" {
+		system += "\n" + safety
+	}
+	messages := append([]Message{
+		{Role: "system", Content: system + "\n" + session.character.characterPrompt()},
+	}, history...)
+
+	data := newChatMessage(&s.config, messages)
+	tokens := 0
+	generate := func() (string, error) {
+		result, err := chatCompletionWithRetry(r.Context(), s.client, &s.config, data, s.debug)
+		if err != nil {
+			return "", err
+		}
+		tokens += result.PromptTokens + result.CompletionTokens
+		reply, _ := dedupReply(truncateAtStop(result.Content, s.config.StopSequences))
+		return reply, nil
+	}
+
+	reply, err := generate()
+	if err != nil {
+		writeJSON(w, http.StatusBadGateway, serverResponse{Error: "backend error: " + err.Error()})
+		return
+	}
+	if checkReplies(&s.config) {
+		checked, ok := checkReplyWith(s.client, &s.config, message, reply, generate, s.debug)
+		if !ok {
+			writeJSON(w, http.StatusForbidden, serverResponse{Error: "reply blocked by safety policy"})
+			return
+		}
+		reply = checked
+	}
+
+	session.history = append(history, Message{Role: "assistant", Content: reply})
+	replied = true
+	if s.quotas != nil {
+		s.quotas.addTokens(tokens, now, quotas...)
+	}
+	writeJSON(w, http.StatusOK, serverResponse{Reply: reply})
+}
+
+// handleReset drops the chat between a user and a character and returns the
+// greeting of the new one.
+func (s *chatServer) handleReset(w http.ResponseWriter, r *http.Request) {
+	req, ok := s.decodeRequest(w, r)
+	if !ok {
+		return
+	}
+
+	s.mu.Lock()
+	delete(s.sessions, sessionKey(req))
+	s.mu.Unlock()
+
+	session, err := s.session(req)
+	if err != nil {
+		writeJSON(w, http.StatusNotFound, serverResponse{Error: "unknown character"})
+		return
+	}
+	writeJSON(w, http.StatusOK, serverResponse{Reply: session.history[0].Content})
+}
+
+func (s *chatServer) handleCharacters(w http.ResponseWriter, r *http.Request) {
+	names, err := listCharacters()
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, serverResponse{Error: err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, names)
+}
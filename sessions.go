@@ -0,0 +1,285 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const SessionsDir = "sessions"
+
+// Session is a saved chat, including its branches and checkpoints.
+type Session struct {
+	Name        string                 `json:"name"`
+	Character   string                 `json:"character,omitempty"`
+	Created     time.Time              `json:"created"`
+	Updated     time.Time              `json:"updated"`
+	Branch      string                 `json:"branch"`
+	History     []Message              `json:"history"`
+	Branches    map[string][]Message   `json:"branches,omitempty"`
+	Checkpoints map[string][]Message   `json:"checkpoints,omitempty"`
+	AuthorsNote AuthorsNote            `json:"authors_note"`
+	GameState   map[string]interface{} `json:"game_state,omitempty"`
+	Gallery     []Illustration         `json:"gallery,omitempty"`
+	Tags        []string               `json:"tags,omitempty"`
+	Attachments []string               `json:"attachments,omitempty"`
+	Mood        string                 `json:"mood,omitempty"`
+	Affinity    *int                   `json:"affinity,omitempty"`
+	WorldTime   *time.Time             `json:"world_time,omitempty"`
+	Mode        string                 `json:"mode,omitempty"`
+	Scenario    string                 `json:"scenario,omitempty"`
+	Overrides   *ChatOverrides         `json:"overrides,omitempty"`
+	Context     string                 `json:"context_strategy,omitempty"`
+	Summary     string                 `json:"summary,omitempty"`
+	Memories    []string               `json:"memories,omitempty"`
+}
+
+// The saved session the current chat belongs to. Name is empty until the
+// chat is saved for the first time.
+var (
+	sessionName    string
+	sessionCreated = time.Now()
+)
+
+func getSessionsDir() string {
+	return filepath.Join(getConfigDir(), SessionsDir)
+}
+
+func sessionPath(name string) (string, error) {
+	if name == "" || name != filepath.Base(name) || strings.HasPrefix(name, ".") {
+		return "", fmt.Errorf("invalid session name %q", name)
+	}
+	return filepath.Join(getSessionsDir(), name+".json"), nil
+}
+
+func captureSession() Session {
+	saved := map[string][]Message{}
+	for name, history := range branches {
+		saved[name] = history
+	}
+	delete(saved, currentBranch)
+
+	return Session{
+		Name:        sessionName,
+		Character:   activeCharacter.Name,
+		Created:     sessionCreated,
+		Updated:     time.Now(),
+		Branch:      currentBranch,
+		History:     messageHistory,
+		Branches:    saved,
+		Checkpoints: checkpoints,
+		AuthorsNote: authorsNote,
+		GameState:   gameState,
+		Gallery:     gallery,
+		Tags:        sessionTags,
+		Attachments: attachmentNames(),
+		Mood:        characterMood,
+		Affinity:    relationshipScore,
+		WorldTime:   worldTime,
+		Mode:        chatMode,
+		Scenario:    chatScenario,
+		Overrides:   savedOverrides(),
+		Context:     chatContextStrategy,
+		Summary:     chatSummary,
+		Memories:    chatMemories,
+	}
+}
+
+func (fileStorage) SaveSession(session Session) error {
+	path, err := sessionPath(session.Name)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return err
+	}
+	data, _ := json.MarshalIndent(session, "", "  ")
+	return ioutil.WriteFile(path, data, 0644)
+}
+
+func (fileStorage) LoadSession(name string) (Session, error) {
+	path, err := sessionPath(name)
+	if err != nil {
+		return Session{}, err
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return Session{}, err
+	}
+	var session Session
+	if err := json.Unmarshal(data, &session); err != nil {
+		return Session{}, err
+	}
+	session.Name = name
+	return session, nil
+}
+
+// ListSessions returns all saved sessions, most recently updated first.
+func (fileStorage) ListSessions() ([]Session, error) {
+	files, err := filepath.Glob(filepath.Join(getSessionsDir(), "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	var sessions []Session
+	for _, file := range files {
+		session, err := fileStorage{}.LoadSession(strings.TrimSuffix(filepath.Base(file), ".json"))
+		if err != nil {
+			printErrorf("Error reading session %s: %v\n", filepath.Base(file), err)
+			continue
+		}
+		sessions = append(sessions, session)
+	}
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].Updated.After(sessions[j].Updated)
+	})
+	return sessions, nil
+}
+
+func hasUserMessages(history []Message) bool {
+	for _, msg := range history {
+		if msg.Role == "user" {
+			return true
+		}
+	}
+	return false
+}
+
+// autosaveSession saves the current chat before the app exits, naming it
+// with newSessionName if it was never saved.
+func autosaveSession() {
+	if !hasUserMessages(messageHistory) {
+		return
+	}
+	if sessionName == "" {
+		sessionName = newSessionName()
+	}
+	if err := saveSession(captureSession()); err != nil {
+		printError("Error saving session:", err)
+		return
+	}
+	fmt.Printf("Session saved as '%s'.\n", sessionName)
+}
+
+func handleSaveCommand(name string) {
+	if name != "" {
+		sessionName = name
+	} else if sessionName == "" {
+		sessionName = newSessionName()
+	}
+	if err := saveSession(captureSession()); err != nil {
+		printError("Error saving session:", err)
+		return
+	}
+	fmt.Printf("Session saved as '%s'.\n", sessionName)
+}
+
+func handleLoadCommand(name string, config *Config) {
+	if name == "" {
+		fmt.Println("Usage: /load {session}. List sessions using /sessions")
+		return
+	}
+	session, err := loadSession(name)
+	if err != nil {
+		printError("Error loading session:", err)
+		return
+	}
+
+	character := defaultCharacter(*config)
+	if session.Character != "" {
+		if character, err = loadCharacter(session.Character); err != nil {
+			printError("Error loading character:", err)
+			return
+		}
+	}
+	activeCharacter = character
+	config.Character = character.Name
+	saveConfig(*config)
+
+	resetSession()
+	sessionName, sessionCreated = session.Name, session.Created
+	for name, history := range session.Branches {
+		branches[name] = history
+	}
+	for name, history := range session.Checkpoints {
+		checkpoints[name] = history
+	}
+	if session.Branch != "" {
+		currentBranch = session.Branch
+	}
+	authorsNote = session.AuthorsNote
+	gallery = session.Gallery
+	sessionTags = session.Tags
+	attachments, retrievedChunks = loadAttachments(session.Attachments), nil
+	characterMood = session.Mood
+	relationshipScore = session.Affinity
+	worldTime, timeSkipped = session.WorldTime, 0
+	chatMode = session.Mode
+	chatScenario = session.Scenario
+	chatContextStrategy = ""
+	if _, ok := contextManagers[session.Context]; ok {
+		chatContextStrategy = session.Context
+	} else if session.Context != "" {
+		fmt.Printf("Warning: unknown context strategy '%s' in the session; using %s.\n", session.Context, contextStrategy(config))
+	}
+	chatSummary = session.Summary
+	chatMemories = session.Memories
+	chatOverrides = ChatOverrides{}
+	if session.Overrides != nil {
+		chatOverrides = *session.Overrides
+	}
+	applySettings(config)
+	if session.GameState != nil {
+		gameState = session.GameState
+	}
+	setHistory(session.History)
+
+	fmt.Printf("Loaded session '%s' (%d messages).\n", session.Name, len(session.History))
+	if n := len(messageHistory); n > 0 {
+		fmt.Printf("\n[%s]: %s\n", strings.Title(messageHistory[n-1].Role), messageHistory[n-1].Content)
+	}
+}
+
+func displaySessions(args string) {
+	filter, err := parseSessionFilter(args)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	sessions, err := listSessions()
+	if err != nil {
+		printError("Error listing sessions:", err)
+		return
+	}
+	if len(sessions) == 0 {
+		fmt.Println("No saved sessions. Save the current chat using: /save [name]")
+		return
+	}
+	shown := 0
+	for _, session := range sessions {
+		if !filter.matches(session) {
+			continue
+		}
+		if shown++; shown == 1 {
+			fmt.Println("\n[Sessions]:")
+		}
+		character := session.Character
+		if character == "" {
+			character = "default"
+		}
+		tags := ""
+		if len(session.Tags) > 0 {
+			tags = " [" + strings.Join(session.Tags, ", ") + "]"
+		}
+		fmt.Printf("- %s (%s, %d messages, %s)%s\n", session.Name, character, len(session.History), session.Updated.Format("Jan 2 2006 15:04"), tags)
+	}
+	if shown == 0 {
+		fmt.Println("No sessions match.")
+		return
+	}
+	fmt.Println("\nLoad a session using: /load {name}")
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// The game state doubles as a character sheet: numbers are stats, true or
+// false values are flags, lists are inventories and the rest are details.
+// It's sent with every prompt, so it survives the history being trimmed.
+
+// sheetSections groups the state keys by kind, each sorted.
+func sheetSections() (stats, flags, lists, details []string) {
+	keys := make([]string, 0, len(gameState))
+	for key := range gameState {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		switch gameState[key].(type) {
+		case float64, int:
+			stats = append(stats, key)
+		case bool:
+			flags = append(flags, key)
+		case []interface{}:
+			lists = append(lists, key)
+		default:
+			details = append(details, key)
+		}
+	}
+	return
+}
+
+func formatStateValue(value interface{}) string {
+	switch v := value.(type) {
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case []interface{}:
+		items := make([]string, len(v))
+		for i, item := range v {
+			items[i] = fmt.Sprint(item)
+		}
+		if len(items) == 0 {
+			return "(empty)"
+		}
+		return strings.Join(items, ", ")
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// displayGameState shows the state as a sheet, for /sheet and /gm state.
+func displayGameState() {
+	if len(gameState) == 0 {
+		fmt.Println("The game state is empty. Set something using: /set hp 12")
+		return
+	}
+	stats, flags, lists, details := sheetSections()
+	fmt.Println("\n[Sheet]:")
+	for _, section := range []struct {
+		title string
+		keys  []string
+	}{{"Stats", stats}, {"Inventory", lists}, {"Flags", flags}, {"Details", details}} {
+		if len(section.keys) == 0 {
+			continue
+		}
+		fmt.Printf("%s:\n", section.title)
+		for _, key := range section.keys {
+			fmt.Printf("  %s: %s\n", key, formatStateValue(gameState[key]))
+		}
+	}
+}
+
+// statePrompt is the state as sent to the model. Game master mode sends
+// the state with its own instructions instead.
+func statePrompt() string {
+	if len(gameState) == 0 || gmMode {
+		return ""
+	}
+	stats, flags, lists, details := sheetSections()
+	var lines []string
+	for _, key := range append(append(append(stats, lists...), flags...), details...) {
+		lines = append(lines, fmt.Sprintf("- %s: %s", key, formatStateValue(gameState[key])))
+	}
+	return "Current game state (keep the story consistent with it):\n" + strings.Join(lines, "\n")
+}
+
+// handleSetCommand handles /set {key} {value}. Numbers can be changed by
+// +N or -N, lists get items added with +item and removed with -item, and
+// "clear" removes the key.
+func handleSetCommand(args string) {
+	fields := strings.Fields(args)
+	if len(fields) < 2 {
+		fmt.Println("Usage: /set {key} {value | +N | -N | +item | -item | clear}, e.g. /set hp 12, /set inventory +torch")
+		return
+	}
+	key := fields[0]
+	raw := strings.TrimSpace(strings.TrimPrefix(args, key))
+	old, existed := gameState[key]
+
+	value, err := stateValue(old, raw)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	if value == nil {
+		if !existed {
+			fmt.Printf("%s isn't set.\n", key)
+			return
+		}
+		delete(gameState, key)
+		fmt.Printf("%s removed.\n", key)
+		return
+	}
+	gameState[key] = value
+	if existed {
+		fmt.Printf("%s: %s -> %s\n", key, formatStateValue(old), formatStateValue(value))
+	} else {
+		fmt.Printf("%s: %s\n", key, formatStateValue(value))
+	}
+}
+
+// stateValue works out a key's new value from what was typed and its old
+// one, returning nil to remove the key.
+func stateValue(old interface{}, raw string) (interface{}, error) {
+	if raw == "clear" {
+		return nil, nil
+	}
+	if list, ok := old.([]interface{}); ok || strings.HasPrefix(raw, "+") && old == nil && !isNumber(raw[1:]) {
+		switch {
+		case strings.HasPrefix(raw, "+"):
+			return append(append([]interface{}(nil), list...), strings.TrimSpace(raw[1:])), nil
+		case strings.HasPrefix(raw, "-"):
+			item := strings.TrimSpace(raw[1:])
+			for i, existing := range list {
+				if strings.EqualFold(fmt.Sprint(existing), item) {
+					return append(append([]interface{}(nil), list[:i]...), list[i+1:]...), nil
+				}
+			}
+			return nil, fmt.Errorf("there is no %s to remove", item)
+		}
+	}
+	if n, ok := old.(float64); ok && len(raw) > 1 && (raw[0] == '+' || raw[0] == '-') && isNumber(raw[1:]) {
+		delta, _ := strconv.ParseFloat(raw[1:], 64)
+		if raw[0] == '-' {
+			delta = -delta
+		}
+		return n + delta, nil
+	}
+	if isNumber(raw) {
+		n, _ := strconv.ParseFloat(raw, 64)
+		return n, nil
+	}
+	// Anything JSON reads is taken as it is: numbers, true and false, and
+	// lists like ["rope", "torch"]; the rest is text.
+	var parsed interface{}
+	if err := json.Unmarshal([]byte(raw), &parsed); err == nil && parsed != nil {
+		if _, isObject := parsed.(map[string]interface{}); !isObject {
+			return parsed, nil
+		}
+	}
+	return strings.Trim(raw, "\""), nil
+}
+
+func isNumber(s string) bool {
+	_, err := strconv.ParseFloat(s, 64)
+	return err == nil
+}
+
+// stateKeys are the state keys, for completion.
+func stateKeys() []string {
+	keys := make([]string, 0, len(gameState))
+	for key := range gameState {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
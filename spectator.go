@@ -0,0 +1,241 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+const DefaultSpectatorAddr = ":8765"
+
+// spectatorHub serves a read-only live view of the current session. Every
+// change to the history is pushed to connected viewers as a full snapshot,
+// so rewrites of the history show up the same way as new messages do.
+type spectatorHub struct {
+	mu       sync.Mutex
+	config   *Config
+	server   *http.Server
+	token    string
+	url      string
+	snapshot []byte
+	clients  map[chan []byte]struct{}
+}
+
+var spectators = &spectatorHub{}
+
+func handleSpectateCommand(option string, config *Config) {
+	switch option {
+	case "":
+		if link := spectators.link(); link != "" {
+			fmt.Printf("Spectator link: %s\n", link)
+			return
+		}
+		addr := config.SpectatorAddr
+		if addr == "" {
+			addr = DefaultSpectatorAddr
+		}
+		link, err := spectators.start(addr, config, messageHistory)
+		if err != nil {
+			printError("Error starting spectator server:", err)
+			return
+		}
+		fmt.Printf("Spectator link: %s\n", link)
+		fmt.Println("Anyone with this link can watch the chat. Stop sharing with /spectate stop")
+	case "stop":
+		if spectators.link() == "" {
+			fmt.Println("Spectator mode is not running.")
+			return
+		}
+		spectators.stop()
+		fmt.Println("Spectator mode stopped.")
+	default:
+		fmt.Println("Usage: /spectate [stop]")
+	}
+}
+
+func (h *spectatorHub) start(addr string, config *Config, history []Message) (string, error) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return "", err
+	}
+
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		listener.Close()
+		return "", err
+	}
+
+	h.mu.Lock()
+	h.token = hex.EncodeToString(buf)
+	h.clients = make(map[chan []byte]struct{})
+	h.config = config
+	h.snapshot, _ = json.Marshal(storyHistory(config, history))
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/watch/"+h.token, h.servePage)
+	mux.HandleFunc("/watch/"+h.token+"/events", h.serveEvents)
+	h.server = &http.Server{Handler: mux}
+	h.url = fmt.Sprintf("http://%s/watch/%s", spectatorHost(listener.Addr()), h.token)
+	server, link := h.server, h.url
+	h.mu.Unlock()
+
+	go server.Serve(listener)
+	return link, nil
+}
+
+func (h *spectatorHub) stop() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.server == nil {
+		return
+	}
+	h.server.Close()
+	for client := range h.clients {
+		close(client)
+	}
+	h.server = nil
+	h.clients = nil
+	h.token = ""
+	h.url = ""
+}
+
+func (h *spectatorHub) link() string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.url
+}
+
+// publish pushes the current history to every connected viewer, without
+// what isn't part of the story. It is a no-op while spectator mode is off.
+func (h *spectatorHub) publish(history []Message) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.server == nil {
+		return
+	}
+	h.snapshot, _ = json.Marshal(storyHistory(h.config, history))
+	for client := range h.clients {
+		select {
+		case client <- h.snapshot:
+		default:
+			// Slow viewer; it will catch up on the next snapshot.
+		}
+	}
+}
+
+func (h *spectatorHub) serveEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	client := make(chan []byte, 1)
+	h.mu.Lock()
+	if h.clients == nil {
+		h.mu.Unlock()
+		http.NotFound(w, r)
+		return
+	}
+	h.clients[client] = struct{}{}
+	client <- h.snapshot
+	h.mu.Unlock()
+
+	defer func() {
+		h.mu.Lock()
+		delete(h.clients, client)
+		h.mu.Unlock()
+	}()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case snapshot, ok := <-client:
+			if !ok {
+				return
+			}
+			fmt.Fprintf(w, "data: %s\n\n", snapshot)
+			flusher.Flush()
+		}
+	}
+}
+
+func (h *spectatorHub) servePage(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "spectator mode is read-only", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	fmt.Fprint(w, strings.ReplaceAll(spectatorPage, "{{events}}", r.URL.Path+"/events"))
+}
+
+// spectatorHost returns an address a friend on the same network can reach.
+// Wildcard listen addresses are replaced with the first non-loopback IPv4.
+func spectatorHost(addr net.Addr) string {
+	host, port, _ := net.SplitHostPort(addr.String())
+	if ip := net.ParseIP(host); ip != nil && !ip.IsUnspecified() {
+		return net.JoinHostPort(host, port)
+	}
+
+	host = "localhost"
+	if addrs, err := net.InterfaceAddrs(); err == nil {
+		for _, a := range addrs {
+			if ipNet, ok := a.(*net.IPNet); ok && !ipNet.IP.IsLoopback() && ipNet.IP.To4() != nil {
+				host = ipNet.IP.String()
+				break
+			}
+		}
+	}
+	return net.JoinHostPort(host, port)
+}
+
+const spectatorPage = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Character.Chat - Spectating</title>
+<style>
+body { font-family: sans-serif; max-width: 48em; margin: 2em auto; background: #16161d; color: #ddd; }
+.msg { margin: 1em 0; white-space: pre-wrap; }
+.role { font-weight: bold; color: #9ab; }
+.user .role { color: #ba9; }
+#status { color: #777; font-size: 0.9em; }
+</style>
+</head>
+<body>
+<h2>Character.Chat</h2>
+<div id="status">Connecting...</div>
+<div id="chat"></div>
+<script>
+const chat = document.getElementById("chat");
+const status = document.getElementById("status");
+const events = new EventSource("{{events}}");
+events.onopen = () => { status.textContent = "Watching live (read-only)"; };
+events.onerror = () => { status.textContent = "Disconnected"; };
+events.onmessage = (e) => {
+  const history = JSON.parse(e.data) || [];
+  chat.innerHTML = "";
+  for (const msg of history) {
+    const div = document.createElement("div");
+    div.className = "msg " + msg.role;
+    const role = document.createElement("span");
+    role.className = "role";
+    role.textContent = (msg.role === "user" ? "User" : "Chatbot") + ": ";
+    div.appendChild(role);
+    div.appendChild(document.createTextNode(msg.content));
+    chat.appendChild(div);
+  }
+  window.scrollTo(0, document.body.scrollHeight);
+};
+</script>
+</body>
+</html>
+`
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+const topWords = 10
+
+// stopWords are left out of the most frequent words.
+var stopWords = wordSet("a an the and or but if of to in on at by for with from as is are was were be been being it its it's this that these those i you he she we they me him her us them my your his our their i'm you're don't not no so do does did have has had will would can could just what there then than up out about into over all some very")
+
+type wordCount struct {
+	Word  string
+	Count int
+}
+
+func handleStatsCommand() {
+	if len(messageHistory) == 0 {
+		fmt.Println("No messages yet.")
+		return
+	}
+	roles := map[string]int{}
+	words, replies, replyWords := 0, 0, 0
+	for _, msg := range messageHistory {
+		roles[msg.Role]++
+		n := len(strings.Fields(msg.Content))
+		words += n
+		if msg.Role == "assistant" {
+			replies++
+			replyWords += n
+		}
+	}
+
+	fmt.Println("\n[Stats]:")
+	for _, role := range []string{"user", "assistant", "system"} {
+		if roles[role] > 0 {
+			fmt.Printf("%-20s %d\n", strings.Title(role)+" messages", roles[role])
+		}
+	}
+	fmt.Printf("%-20s %d (about %d tokens)\n", "Words", words, estimatePromptTokens(messageHistory))
+	if replies > 0 {
+		fmt.Printf("%-20s %d words\n", "Average reply", replyWords/replies)
+	}
+	fmt.Printf("%-20s %s\n", "Session length", time.Since(sessionCreated).Round(time.Second))
+
+	if top := frequentWords(messageHistory, topWords); len(top) > 0 {
+		list := make([]string, len(top))
+		for i, w := range top {
+			list[i] = fmt.Sprintf("%s (%d)", w.Word, w.Count)
+		}
+		fmt.Printf("%-20s %s\n", "Top reply words", strings.Join(list, ", "))
+	}
+	if phrases := repeatedPhrases(messageHistory, 5); len(phrases) > 0 {
+		fmt.Println("\nPhrases the character keeps repeating:")
+		for _, p := range phrases {
+			fmt.Printf("- %q in %d replies\n", p.Word, p.Count)
+		}
+	}
+}
+
+func replyWordsOf(content string) []string {
+	var words []string
+	for _, word := range strings.Fields(strings.ToLower(content)) {
+		if word = strings.Trim(word, ".,!?;:\"'*()-~…"); word != "" {
+			words = append(words, word)
+		}
+	}
+	return words
+}
+
+// frequentWords counts the words of the character's replies, leaving out
+// stop words.
+func frequentWords(history []Message, n int) []wordCount {
+	counts := map[string]int{}
+	for _, msg := range history {
+		if msg.Role != "assistant" {
+			continue
+		}
+		for _, word := range replyWordsOf(msg.Content) {
+			if !stopWords[word] && len(word) > 2 {
+				counts[word]++
+			}
+		}
+	}
+	return topCounts(counts, n, 2)
+}
+
+// repeatedPhrases finds four-word phrases that turn up in several replies,
+// the usual sign of a character stuck in a loop.
+func repeatedPhrases(history []Message, n int) []wordCount {
+	counts := map[string]int{}
+	for _, msg := range history {
+		if msg.Role != "assistant" {
+			continue
+		}
+		words := replyWordsOf(msg.Content)
+		seen := map[string]bool{}
+		for i := 0; i+4 <= len(words); i++ {
+			phrase := strings.Join(words[i:i+4], " ")
+			if !seen[phrase] {
+				seen[phrase] = true
+				counts[phrase]++
+			}
+		}
+	}
+	return topCounts(counts, n, 3)
+}
+
+// topCounts returns up to n entries seen at least min times, most used
+// first.
+func topCounts(counts map[string]int, n, min int) []wordCount {
+	var list []wordCount
+	for word, count := range counts {
+		if count >= min {
+			list = append(list, wordCount{word, count})
+		}
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Count != list[j].Count {
+			return list[i].Count > list[j].Count
+		}
+		return list[i].Word < list[j].Word
+	})
+	if len(list) > n {
+		list = list[:n]
+	}
+	return list
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+const DatabaseFile = "char-chat.db"
+
+// Storage persists sessions and characters. Flat JSON files are the
+// default; "storage": "sqlite" in the config keeps them in one database.
+type Storage interface {
+	LoadSession(name string) (Session, error)
+	SaveSession(session Session) error
+	// ListSessions returns all saved sessions, most recently updated first.
+	ListSessions() ([]Session, error)
+
+	LoadCharacter(name string) (Character, error)
+	SaveCharacter(character Character) error
+	ListCharacters() ([]string, error)
+}
+
+// fileStorage keeps each session and character in its own JSON file.
+type fileStorage struct{}
+
+var store Storage = fileStorage{}
+
+func initStorage(config Config) {
+	switch config.Storage {
+	case "", "files":
+		store = fileStorage{}
+	case "sqlite":
+		path := config.Database
+		if path == "" {
+			path = filepath.Join(getConfigDir(), DatabaseFile)
+		}
+		db, err := openSQLiteStorage(path)
+		if err != nil {
+			printError("Error opening database:", err)
+			os.Exit(1)
+		}
+		store = db
+	default:
+		fmt.Printf("Unknown storage %q, using files.\n", config.Storage)
+	}
+}
+
+func loadSession(name string) (Session, error) { return store.LoadSession(name) }
+func saveSession(session Session) error        { return store.SaveSession(session) }
+func listSessions() ([]Session, error)         { return store.ListSessions() }
+
+func loadCharacter(name string) (Character, error) { return store.LoadCharacter(name) }
+func saveCharacter(character Character) error      { return store.SaveCharacter(character) }
+func listCharacters() ([]string, error)            { return store.ListCharacters() }
@@ -0,0 +1,266 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+// sqliteMigrations are applied in order; PRAGMA user_version records how
+// many have run. Append new ones, never edit old ones.
+var sqliteMigrations = []string{
+	`CREATE TABLE metadata (
+		key   TEXT PRIMARY KEY,
+		value TEXT NOT NULL
+	);
+	CREATE TABLE characters (
+		name    TEXT PRIMARY KEY,
+		data    TEXT NOT NULL,
+		updated INTEGER NOT NULL
+	);
+	CREATE TABLE sessions (
+		name      TEXT PRIMARY KEY,
+		character TEXT NOT NULL,
+		created   INTEGER NOT NULL,
+		updated   INTEGER NOT NULL,
+		state     TEXT NOT NULL
+	);
+	CREATE TABLE messages (
+		session  TEXT NOT NULL REFERENCES sessions(name) ON DELETE CASCADE,
+		position INTEGER NOT NULL,
+		role     TEXT NOT NULL,
+		content  TEXT NOT NULL,
+		data     TEXT NOT NULL,
+		PRIMARY KEY (session, position)
+	);
+	CREATE INDEX sessions_updated ON sessions(updated);`,
+}
+
+// sqliteStorage keeps sessions, their messages and characters in a SQLite
+// database. Messages get their own rows so they can be queried; everything
+// else about a session is stored as JSON in its state column.
+type sqliteStorage struct {
+	db *sql.DB
+}
+
+func openSQLiteStorage(path string) (*sqliteStorage, error) {
+	db, err := sql.Open("sqlite", path+"?_pragma=foreign_keys(1)&_pragma=busy_timeout(5000)")
+	if err != nil {
+		return nil, err
+	}
+	s := &sqliteStorage{db: db}
+	fresh, err := s.migrate()
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	if fresh {
+		s.importFiles()
+	}
+	return s, nil
+}
+
+// migrate brings the schema up to date, reporting whether the database was
+// new.
+func (s *sqliteStorage) migrate() (bool, error) {
+	var version int
+	if err := s.db.QueryRow("PRAGMA user_version").Scan(&version); err != nil {
+		return false, err
+	}
+	for i := version; i < len(sqliteMigrations); i++ {
+		tx, err := s.db.Begin()
+		if err != nil {
+			return false, err
+		}
+		if _, err := tx.Exec(sqliteMigrations[i]); err != nil {
+			tx.Rollback()
+			return false, fmt.Errorf("migration %d: %v", i+1, err)
+		}
+		if _, err := tx.Exec(fmt.Sprintf("PRAGMA user_version = %d", i+1)); err != nil {
+			tx.Rollback()
+			return false, err
+		}
+		if err := tx.Commit(); err != nil {
+			return false, err
+		}
+	}
+	return version == 0, nil
+}
+
+// importFiles copies the JSON sessions and characters into a new database,
+// so switching storage keeps existing chats.
+func (s *sqliteStorage) importFiles() {
+	files := fileStorage{}
+	names, _ := files.ListCharacters()
+	for _, name := range names {
+		if character, err := files.LoadCharacter(name); err == nil {
+			if err := s.SaveCharacter(character); err != nil {
+				printErrorf("Error importing character '%s': %v\n", name, err)
+			}
+		}
+	}
+	sessions, _ := files.ListSessions()
+	for _, session := range sessions {
+		if err := s.SaveSession(session); err != nil {
+			printErrorf("Error importing session '%s': %v\n", session.Name, err)
+		}
+	}
+	if len(names) > 0 || len(sessions) > 0 {
+		fmt.Printf("Imported %d character(s) and %d session(s) into the database.\n", len(names), len(sessions))
+	}
+	s.db.Exec("INSERT OR REPLACE INTO metadata (key, value) VALUES ('imported_files', ?)", time.Now().Format(time.RFC3339))
+}
+
+func (s *sqliteStorage) SaveSession(session Session) error {
+	if _, err := sessionPath(session.Name); err != nil {
+		return err
+	}
+	history := session.History
+	session.History = nil
+	state, err := json.Marshal(session)
+	if err != nil {
+		return err
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(`INSERT INTO sessions (name, character, created, updated, state) VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT(name) DO UPDATE SET character = excluded.character, updated = excluded.updated, state = excluded.state`,
+		session.Name, session.Character, session.Created.UnixNano(), session.Updated.UnixNano(), string(state)); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM messages WHERE session = ?", session.Name); err != nil {
+		return err
+	}
+	for i, msg := range history {
+		data, _ := json.Marshal(msg)
+		if _, err := tx.Exec("INSERT INTO messages (session, position, role, content, data) VALUES (?, ?, ?, ?, ?)",
+			session.Name, i, msg.Role, msg.Content, string(data)); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (s *sqliteStorage) LoadSession(name string) (Session, error) {
+	if _, err := sessionPath(name); err != nil {
+		return Session{}, err
+	}
+	var state string
+	err := s.db.QueryRow("SELECT state FROM sessions WHERE name = ?", name).Scan(&state)
+	if err == sql.ErrNoRows {
+		return Session{}, fmt.Errorf("no session named %q", name)
+	}
+	if err != nil {
+		return Session{}, err
+	}
+	var session Session
+	if err := json.Unmarshal([]byte(state), &session); err != nil {
+		return Session{}, err
+	}
+	session.Name = name
+	session.History, err = s.loadMessages(name)
+	return session, err
+}
+
+func (s *sqliteStorage) loadMessages(session string) ([]Message, error) {
+	rows, err := s.db.Query("SELECT data FROM messages WHERE session = ? ORDER BY position", session)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var history []Message
+	for rows.Next() {
+		var data string
+		if err := rows.Scan(&data); err != nil {
+			return nil, err
+		}
+		var msg Message
+		if err := json.Unmarshal([]byte(data), &msg); err != nil {
+			return nil, err
+		}
+		history = append(history, msg)
+	}
+	return history, rows.Err()
+}
+
+func (s *sqliteStorage) ListSessions() ([]Session, error) {
+	rows, err := s.db.Query("SELECT name FROM sessions ORDER BY updated DESC")
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		names = append(names, name)
+	}
+	rows.Close()
+
+	var sessions []Session
+	for _, name := range names {
+		session, err := s.LoadSession(name)
+		if err != nil {
+			printErrorf("Error reading session %s: %v\n", name, err)
+			continue
+		}
+		sessions = append(sessions, session)
+	}
+	return sessions, nil
+}
+
+func (s *sqliteStorage) SaveCharacter(character Character) error {
+	if _, err := characterPath(character.Name); err != nil {
+		return err
+	}
+	data, _ := json.Marshal(character)
+	_, err := s.db.Exec("INSERT OR REPLACE INTO characters (name, data, updated) VALUES (?, ?, ?)",
+		character.Name, string(data), time.Now().UnixNano())
+	return err
+}
+
+func (s *sqliteStorage) LoadCharacter(name string) (Character, error) {
+	if _, err := characterPath(name); err != nil {
+		return Character{}, err
+	}
+	var data string
+	err := s.db.QueryRow("SELECT data FROM characters WHERE name = ?", name).Scan(&data)
+	if err == sql.ErrNoRows {
+		return Character{}, fmt.Errorf("no character named %q", name)
+	}
+	if err != nil {
+		return Character{}, err
+	}
+	var character Character
+	if err := json.Unmarshal([]byte(data), &character); err != nil {
+		return Character{}, err
+	}
+	character.Name = name
+	return character, nil
+}
+
+func (s *sqliteStorage) ListCharacters() ([]string, error) {
+	rows, err := s.db.Query("SELECT name FROM characters ORDER BY name")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var names []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+	}
+	return names, rows.Err()
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// chatCompletionStream is chatCompletion with streaming on, calling onChunk
+// with each piece of the reply as it arrives. Returning false from onChunk
+// stops reading early.
+func chatCompletionStream(ctx context.Context, client *http.Client, url string, data ChatMessage, onChunk func(string) bool) (ChatResult, error) {
+	data.Stream = true
+	jsonData, _ := json.Marshal(data)
+	req, _ := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBuffer(jsonData))
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := client.Do(req)
+	if ctx.Err() != nil {
+		return ChatResult{}, errGenerationCancelled
+	}
+	if err != nil {
+		return ChatResult{}, requestError(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(resp.Body)
+		var response struct {
+			Error string `json:"error"`
+		}
+		_ = json.Unmarshal(body, &response)
+		return ChatResult{}, backendError(resp, response.Error)
+	}
+
+	var result ChatResult
+	decoder := json.NewDecoder(resp.Body)
+	for {
+		var chunk struct {
+			Message         Message `json:"message"`
+			Done            bool    `json:"done"`
+			PromptEvalCount int     `json:"prompt_eval_count"`
+			EvalCount       int     `json:"eval_count"`
+			Error           string  `json:"error"`
+			ollamaTimings
+		}
+		err := decoder.Decode(&chunk)
+		if ctx.Err() != nil {
+			return ChatResult{}, errGenerationCancelled
+		}
+		if err == io.EOF {
+			return result, nil
+		}
+		if err != nil {
+			return result, requestError(err)
+		}
+		if chunk.Error != "" {
+			return result, errors.New(chunk.Error)
+		}
+
+		result.Content += chunk.Message.Content
+		if chunk.Done {
+			result.PromptTokens, result.CompletionTokens = chunk.PromptEvalCount, chunk.EvalCount
+			chunk.ollamaTimings.apply(&result)
+			usage.record(data.Model, result)
+		}
+		if !onChunk(chunk.Message.Content) || chunk.Done {
+			return result, nil
+		}
+	}
+}
+
+// streamReply is requestReply for streaming: onDelta gets the reply as it
+// is generated, holding back text that could be the start of a stop
+// sequence or the relationship tag. Transient failures are retried as long
+// as nothing was sent to onDelta yet. The returned reply is final; the
+// dedup filter can make it differ from the deltas.
+func streamReply(ctx context.Context, client *http.Client, config *Config, messages []Message, onDelta func(string), debug bool) (string, error) {
+	holdback := 0
+	for _, stop := range config.StopSequences {
+		if len(stop) > holdback {
+			holdback = len(stop)
+		}
+	}
+	if config.Relationship != nil && len(relationshipTagOpen) > holdback {
+		holdback = len(relationshipTagOpen)
+	}
+
+	attempts := config.MaxAttempts
+	if attempts <= 0 {
+		attempts = DefaultMaxAttempts
+	}
+
+	var full string
+	emitted := 0
+	emit := func(final bool) bool {
+		if emitted == 0 {
+			full = strings.TrimLeftFunc(full, unicode.IsSpace)
+		}
+		safe, stopped := cutAtStop(full, config.StopSequences)
+		// The relationship tag ends the reply and isn't shown, but the
+		// rest of it is still read for the score.
+		hidden := false
+		if config.Relationship != nil {
+			if i := strings.Index(strings.ToLower(safe), relationshipTagOpen); i >= 0 {
+				safe, hidden = safe[:i], true
+			}
+		}
+		limit := len(safe)
+		if !final && !stopped && !hidden {
+			limit -= holdback
+		}
+		for limit > emitted && limit < len(safe) && !utf8.RuneStart(safe[limit]) {
+			limit--
+		}
+		if limit > emitted {
+			onDelta(safe[emitted:limit])
+			emitted = limit
+		}
+		return !stopped
+	}
+
+	var err error
+	for attempt := 1; ; attempt++ {
+		full = ""
+		_, err = chatCompletionStream(ctx, client, config.URL, newChatMessage(config, messages), func(chunk string) bool {
+			full += chunk
+			return emit(false)
+		})
+
+		var retryable *retryableError
+		if err == nil || emitted > 0 || !errors.As(err, &retryable) || attempt >= attempts {
+			break
+		}
+		delay := retryDelay(attempt)
+		if debug {
+			notice("[Debug] Attempt %d of %d failed: %v. Retrying in %s.\n", attempt, attempts, err, delay.Round(time.Millisecond))
+		}
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return "", errGenerationCancelled
+		}
+	}
+	if err != nil {
+		return "", err
+	}
+	emit(true)
+
+	content := takeRelationshipTag(truncateAtStop(full, config.StopSequences))
+	if deduped, removed := dedupReply(content); removed > 0 {
+		if debug {
+			notice("[Debug] Dedup filter removed %d repeated sentence(s) or paragraph(s).\n", removed)
+		}
+		content = deduped
+	}
+	if content == "" {
+		return "", errors.New("no response content received")
+	}
+	return content, nil
+}
+
+// cutAtStop is truncateAtStop without trimming, so offsets into content stay
+// valid while it is streamed.
+func cutAtStop(content string, stops []string) (string, bool) {
+	cut := false
+	for _, stop := range stops {
+		if i := strings.Index(content, stop); i >= 0 {
+			content, cut = content[:i], true
+		}
+	}
+	return content, cut
+}
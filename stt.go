@@ -0,0 +1,222 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"golang.org/x/term"
+)
+
+const (
+	DefaultMaxRecording = 60
+	transcribeTimeout   = 2 * time.Minute
+)
+
+// STTConfig is speech-to-text for /mic. URL is a Whisper transcription
+// endpoint taking a multipart upload, such as OpenAI's
+// /v1/audio/transcriptions or whisper.cpp's /inference.
+type STTConfig struct {
+	URL      string            `json:"url"`
+	Model    string            `json:"model,omitempty"`
+	Language string            `json:"language,omitempty"`
+	Headers  map[string]string `json:"headers,omitempty"`
+	// Recorder is the command that records from the microphone into
+	// {file}, a WAV file, until it is interrupted. By default sox's rec or
+	// arecord.
+	Recorder string `json:"recorder,omitempty"`
+	// MaxSeconds ends a recording nobody stopped.
+	MaxSeconds int `json:"max_seconds,omitempty"`
+}
+
+// handleMicCommand records a message from the microphone, transcribes it
+// and sends the transcript as the next message.
+func handleMicCommand(client *http.Client, config *Config) {
+	stt := config.STT
+	if stt == nil || stt.URL == "" {
+		fmt.Println("Set a Whisper endpoint first, e.g. \"stt\": {\"url\": \"http://localhost:8080/inference\"} in the config.")
+		return
+	}
+	dir, err := ioutil.TempDir("", "char-chat-mic-")
+	if err != nil {
+		printError("Error recording:", err)
+		return
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "message.wav")
+
+	ok, err := recordAudio(stt, path)
+	if err != nil {
+		printError("Error recording:", err)
+		return
+	}
+	if !ok {
+		fmt.Println("Recording cancelled.")
+		return
+	}
+
+	fmt.Println("Transcribing...")
+	text, err := transcribe(client, stt, path)
+	if err != nil {
+		printError("Error transcribing:", err)
+		return
+	}
+	if text == "" {
+		fmt.Println("No speech was heard.")
+		return
+	}
+	// Sent by the main loop as if it had been typed.
+	pendingInputs = append([]string{text}, pendingInputs...)
+}
+
+// recordAudio runs the recorder until a key is pressed, Ctrl-C cancels,
+// the recorder stops or the time runs out. It reports whether there is a
+// recording to use.
+func recordAudio(stt *STTConfig, path string) (bool, error) {
+	args := recorderCommand(stt.Recorder)
+	if args == nil {
+		return false, fmt.Errorf("no recorder found; install sox or set the stt recorder option")
+	}
+	for i := range args {
+		args[i] = strings.ReplaceAll(args[i], "{file}", path)
+	}
+	cmd := exec.Command(args[0], args[1:]...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Start(); err != nil {
+		return false, err
+	}
+	done := make(chan error, 1)
+	go func() { done <- cmd.Wait() }()
+
+	maxSeconds := stt.MaxSeconds
+	if maxSeconds <= 0 {
+		maxSeconds = DefaultMaxRecording
+	}
+	fmt.Printf("Recording for up to %d seconds. Press any key to stop, or Ctrl-C to cancel.\n", maxSeconds)
+
+	stdin := int(os.Stdin.Fd())
+	if term.IsTerminal(stdin) {
+		if state, err := term.MakeRaw(stdin); err == nil {
+			defer term.Restore(stdin, state)
+		}
+	}
+	deadline := time.Now().Add(time.Duration(maxSeconds) * time.Second)
+	cancelled := false
+wait:
+	for time.Now().Before(deadline) {
+		select {
+		case err := <-done:
+			// The recorder stopped by itself.
+			if err != nil {
+				return false, fmt.Errorf("%v %s", err, strings.TrimSpace(stderr.String()))
+			}
+			return true, nil
+		default:
+		}
+		if keyPressed(stdin, 100*time.Millisecond) {
+			r, _, _ := stdinReader.ReadRune()
+			stdinReader.Discard(stdinReader.Buffered())
+			cancelled = r == 3
+			break wait
+		}
+	}
+
+	// Interrupting lets recorders finish the file; Windows can't, so kill.
+	if err := cmd.Process.Signal(os.Interrupt); err != nil {
+		cmd.Process.Kill()
+	}
+	select {
+	case <-done:
+	case <-time.After(3 * time.Second):
+		cmd.Process.Kill()
+		<-done
+	}
+	if cancelled {
+		return false, nil
+	}
+	if info, err := os.Stat(path); err != nil || info.Size() == 0 {
+		return false, fmt.Errorf("the recorder saved nothing %s", strings.TrimSpace(stderr.String()))
+	}
+	return true, nil
+}
+
+// recorderCommand splits the configured recorder, or picks an installed
+// one, recording 16 kHz mono as Whisper expects.
+func recorderCommand(recorder string) []string {
+	if recorder != "" {
+		args := strings.Fields(recorder)
+		if !strings.Contains(recorder, "{file}") {
+			args = append(args, "{file}")
+		}
+		return args
+	}
+	for _, args := range [][]string{
+		{"rec", "-q", "-c", "1", "-r", "16000", "{file}"},
+		{"arecord", "-q", "-f", "S16_LE", "-r", "16000", "-c", "1", "{file}"},
+	} {
+		if _, err := exec.LookPath(args[0]); err == nil {
+			return args
+		}
+	}
+	return nil
+}
+
+// transcribe uploads the recording and returns the text heard.
+func transcribe(client *http.Client, stt *STTConfig, path string) (string, error) {
+	audio, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	part, err := form.CreateFormFile("file", filepath.Base(path))
+	if err != nil {
+		return "", err
+	}
+	part.Write(audio)
+	fields := map[string]string{"response_format": "json", "model": stt.Model, "language": stt.Language}
+	for _, name := range []string{"model", "language", "response_format"} {
+		if fields[name] != "" {
+			form.WriteField(name, fields[name])
+		}
+	}
+	form.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), transcribeTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "POST", stt.URL, &body)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	for k, v := range stt.Headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		message, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+		return "", fmt.Errorf("the transcription server returned %s: %s", resp.Status, strings.TrimSpace(string(message)))
+	}
+	var result struct {
+		Text string `json:"text"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(result.Text), nil
+}
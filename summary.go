@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+const (
+	summaryPrefix       = "[Summary of earlier events: "
+	summaryInstructions = "Summarize the following roleplay conversation in one or two paragraphs. Keep names, relationships, important events and unresolved plot threads. Respond with only the summary."
+)
+
+// chatSummary is the recap of the chat kept for when older messages are
+// trimmed: it takes their place at the start of the history. It is saved
+// with the session.
+var chatSummary string
+
+// summarize asks the model for a recap of messages.
+func summarize(client *http.Client, config *Config, messages []Message, debug bool) (string, error) {
+	var transcript strings.Builder
+	for _, msg := range storyHistory(config, messages) {
+		fmt.Fprintf(&transcript, "%s: %s\n\n", strings.Title(msg.Role), msg.Content)
+	}
+	summary, err := requestReply(client, config, []Message{
+		{Role: "system", Content: summaryInstructions},
+		{Role: "user", Content: transcript.String()},
+	}, debug)
+	return strings.TrimSpace(summary), err
+}
+
+func isSummary(msg Message) bool {
+	return msg.Role == "system" && strings.HasPrefix(msg.Content, summaryPrefix)
+}
+
+// withSummary puts the chat's summary at the start of a trimmed history,
+// in place of any earlier one.
+func withSummary(history []Message) []Message {
+	if chatSummary == "" {
+		return history
+	}
+	trimmed := []Message{{Role: "system", Content: summaryPrefix + chatSummary + "]"}}
+	for _, msg := range history {
+		if !isSummary(msg) {
+			trimmed = append(trimmed, msg)
+		}
+	}
+	return trimmed
+}
+
+// handleSummaryCommand handles /summary [show | clear].
+func handleSummaryCommand(args string, client *http.Client, config *Config, debug bool) {
+	switch strings.TrimSpace(args) {
+	case "":
+	case "show":
+		if chatSummary == "" {
+			fmt.Println("This chat has no summary yet. Write one using /summary")
+			return
+		}
+		fmt.Printf("\n[Summary]:\n%s\n", chatSummary)
+		return
+	case "clear":
+		chatSummary = ""
+		fmt.Println("Summary cleared.")
+		return
+	default:
+		fmt.Println("Usage: /summary [show | clear]")
+		return
+	}
+
+	if len(messageHistory) == 0 {
+		fmt.Println("No messages yet.")
+		return
+	}
+	fmt.Println("Summarizing the chat...")
+	summary, err := summarize(client, config, messageHistory, debug)
+	if err != nil {
+		printError("Error summarizing conversation:", err)
+		return
+	}
+	if summary == "" {
+		printError("The model returned an empty summary.")
+		return
+	}
+	fmt.Printf("\n[Summary]:\n%s\n", summary)
+	answer := promptUserForInput("Keep it as the chat's summary, used when older messages are trimmed? (y/n)", "y")
+	if strings.HasPrefix(strings.ToLower(answer), "y") {
+		chatSummary = summary
+		fmt.Println("Summary kept.")
+	}
+}
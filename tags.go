@@ -0,0 +1,136 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// sessionTags label the current chat, e.g. "slowburn". They are saved with
+// the session and can be used to filter /sessions.
+var sessionTags []string
+
+func handleTagCommand(args string) {
+	fields := strings.Fields(strings.ToLower(args))
+	if len(fields) == 0 {
+		if len(sessionTags) == 0 {
+			fmt.Println("This chat has no tags. Add some using: /tag add {tag}...")
+			return
+		}
+		fmt.Println("Tags: " + strings.Join(sessionTags, ", "))
+		return
+	}
+	if len(fields) == 1 || (fields[0] != "add" && fields[0] != "remove") {
+		fmt.Println("Usage: /tag [add | remove] {tag}...")
+		return
+	}
+
+	for _, tag := range fields[1:] {
+		i := indexOf(sessionTags, tag)
+		switch {
+		case fields[0] == "add" && i < 0:
+			sessionTags = append(sessionTags, tag)
+		case fields[0] == "remove" && i >= 0:
+			sessionTags = append(sessionTags[:i], sessionTags[i+1:]...)
+		}
+	}
+	sort.Strings(sessionTags)
+	if len(sessionTags) == 0 {
+		fmt.Println("This chat has no tags.")
+	} else {
+		fmt.Println("Tags: " + strings.Join(sessionTags, ", "))
+	}
+	if sessionName != "" {
+		handleSaveCommand("")
+	}
+}
+
+func indexOf(list []string, s string) int {
+	for i, item := range list {
+		if item == s {
+			return i
+		}
+	}
+	return -1
+}
+
+// sessionFilter selects sessions for /sessions. Empty fields match
+// anything; To includes the whole day.
+type sessionFilter struct {
+	Tags      []string
+	Character string
+	From, To  time.Time
+}
+
+const sessionFilterUsage = "Usage: /sessions [tag:{tag}] [char:{name}] [from:YYYY-MM-DD] [to:YYYY-MM-DD]"
+
+func parseSessionFilter(args string) (sessionFilter, error) {
+	var filter sessionFilter
+	for _, field := range strings.Fields(args) {
+		key, value, ok := strings.Cut(field, ":")
+		if !ok || value == "" {
+			return filter, errors.New(sessionFilterUsage)
+		}
+		var err error
+		switch key {
+		case "tag":
+			filter.Tags = append(filter.Tags, strings.ToLower(value))
+		case "char":
+			filter.Character = value
+		case "from":
+			filter.From, err = time.ParseInLocation("2006-01-02", value, time.Local)
+		case "to":
+			filter.To, err = time.ParseInLocation("2006-01-02", value, time.Local)
+			filter.To = filter.To.AddDate(0, 0, 1)
+		default:
+			return filter, errors.New(sessionFilterUsage)
+		}
+		if err != nil {
+			return filter, fmt.Errorf("Invalid date %q. Use YYYY-MM-DD", value)
+		}
+	}
+	return filter, nil
+}
+
+func (f sessionFilter) matches(session Session) bool {
+	for _, tag := range f.Tags {
+		if indexOf(session.Tags, tag) < 0 {
+			return false
+		}
+	}
+	if f.Character != "" {
+		character := session.Character
+		if character == "" {
+			character = "default"
+		}
+		if !strings.EqualFold(character, f.Character) {
+			return false
+		}
+	}
+	if !f.From.IsZero() && session.Updated.Before(f.From) {
+		return false
+	}
+	if !f.To.IsZero() && !session.Updated.Before(f.To) {
+		return false
+	}
+	return true
+}
+
+// allTags returns every tag used by a saved session, for completion.
+func allTags() []string {
+	sessions, _ := listSessions()
+	seen := map[string]bool{}
+	var tags []string
+	for _, session := range sessions {
+		for _, tag := range session.Tags {
+			if !seen[tag] {
+				seen[tag] = true
+				tags = append(tags, tag)
+			}
+		}
+	}
+	sort.Strings(tags)
+	return tags
+}
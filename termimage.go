@@ -0,0 +1,228 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	"image/png"
+	"os"
+	"strings"
+
+	"golang.org/x/term"
+)
+
+// Approximate size of a terminal cell in pixels, for protocols that take
+// the image size in pixels.
+const (
+	cellPixelWidth  = 10
+	cellPixelHeight = 20
+)
+
+// imageProtocol is how the terminal draws images: "kitty", "iterm",
+// "sixel" or "ascii", or "" when it can't. The config can name one; the
+// others are recognised from the environment, except sixel, which
+// terminals don't announce.
+func imageProtocol(config *Config) string {
+	if !term.IsTerminal(int(os.Stdout.Fd())) {
+		return ""
+	}
+	switch config.ImageProtocol {
+	case "", "auto":
+	case "none", "off":
+		return ""
+	default:
+		return config.ImageProtocol
+	}
+	switch {
+	case os.Getenv("KITTY_WINDOW_ID") != "" || os.Getenv("TERM") == "xterm-kitty":
+		return "kitty"
+	case os.Getenv("TERM_PROGRAM") == "iTerm.app" || os.Getenv("TERM_PROGRAM") == "WezTerm":
+		return "iterm"
+	case strings.Contains(os.Getenv("TERM"), "sixel") || os.Getenv("TERM") == "foot" || os.Getenv("TERM") == "mlterm":
+		return "sixel"
+	}
+	return "ascii"
+}
+
+// drawImage prints image data about cols cells wide using protocol.
+func drawImage(data []byte, cols int, protocol string) error {
+	switch protocol {
+	case "kitty":
+		if !bytes.HasPrefix(data, pngSignature) {
+			img, _, err := image.Decode(bytes.NewReader(data))
+			if err != nil {
+				return err
+			}
+			var b bytes.Buffer
+			if err := png.Encode(&b, img); err != nil {
+				return err
+			}
+			data = b.Bytes()
+		}
+		encoded := base64.StdEncoding.EncodeToString(data)
+		first := true
+		for len(encoded) > 0 {
+			chunk := encoded
+			if len(chunk) > 4096 {
+				chunk = chunk[:4096]
+			}
+			encoded = encoded[len(chunk):]
+			more := 0
+			if len(encoded) > 0 {
+				more = 1
+			}
+			if first {
+				fmt.Printf("\x1b_Gf=100,a=T,c=%d,m=%d;%s\x1b\\", cols, more, chunk)
+				first = false
+			} else {
+				fmt.Printf("\x1b_Gm=%d;%s\x1b\\", more, chunk)
+			}
+		}
+	case "iterm":
+		fmt.Printf("\x1b]1337;File=inline=1;width=%d;preserveAspectRatio=1;size=%d:%s\a", cols, len(data), base64.StdEncoding.EncodeToString(data))
+	case "sixel", "ascii":
+		img, _, err := image.Decode(bytes.NewReader(data))
+		if err != nil {
+			return err
+		}
+		if protocol == "sixel" {
+			fmt.Print(encodeSixel(img, cols*cellPixelWidth))
+		} else {
+			fmt.Print(asciiArt(img, cols))
+		}
+	default:
+		return fmt.Errorf("unknown image protocol %q", protocol)
+	}
+	fmt.Println()
+	return nil
+}
+
+// scaledSize is the size of img scaled to width, keeping its aspect ratio
+// with pixels aspect times as tall as they are wide.
+func scaledSize(img image.Image, width int, aspect float64) (int, int) {
+	b := img.Bounds()
+	if width > b.Dx() {
+		width = b.Dx()
+	}
+	height := int(float64(b.Dy()) * float64(width) / float64(b.Dx()) / aspect)
+	return max(width, 1), max(height, 1)
+}
+
+// sample returns the pixel of img at x, y in a w by h scaled copy, as
+// 8-bit RGB and whether it's mostly opaque.
+func sample(img image.Image, x, y, w, h int) (uint8, uint8, uint8, bool) {
+	b := img.Bounds()
+	r, g, bl, a := img.At(b.Min.X+x*b.Dx()/w, b.Min.Y+y*b.Dy()/h).RGBA()
+	return uint8(r >> 8), uint8(g >> 8), uint8(bl >> 8), a >= 0x8000
+}
+
+// encodeSixel draws img in sixel graphics using a 6x6x6 color cube.
+func encodeSixel(img image.Image, width int) string {
+	w, h := scaledSize(img, width, 1)
+	pixels := make([]int, w*h) // palette index, or -1 for transparent
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			r, g, b, opaque := sample(img, x, y, w, h)
+			pixels[y*w+x] = -1
+			if opaque {
+				pixels[y*w+x] = int(r)*6/256*36 + int(g)*6/256*6 + int(b)*6/256
+			}
+		}
+	}
+
+	var s strings.Builder
+	fmt.Fprintf(&s, "\x1bPq\"1;1;%d;%d", w, h)
+	for i := 0; i < 216; i++ {
+		fmt.Fprintf(&s, "#%d;2;%d;%d;%d", i, i/36*20, i/6%6*20, i%6*20)
+	}
+	for top := 0; top < h; top += 6 {
+		used := map[int]bool{}
+		for y := top; y < top+6 && y < h; y++ {
+			for x := 0; x < w; x++ {
+				if p := pixels[y*w+x]; p >= 0 {
+					used[p] = true
+				}
+			}
+		}
+		for color := range used {
+			fmt.Fprintf(&s, "#%d", color)
+			run, count := byte(0), 0
+			flush := func() {
+				if count > 3 {
+					fmt.Fprintf(&s, "!%d%c", count, run)
+				} else {
+					s.WriteString(strings.Repeat(string(run), count))
+				}
+			}
+			for x := 0; x < w; x++ {
+				bits := 0
+				for dy := 0; dy < 6 && top+dy < h; dy++ {
+					if pixels[(top+dy)*w+x] == color {
+						bits |= 1 << dy
+					}
+				}
+				c := byte(63 + bits)
+				if c != run {
+					flush()
+					run, count = c, 0
+				}
+				count++
+			}
+			flush()
+			s.WriteByte('$')
+		}
+		s.WriteByte('-')
+	}
+	s.WriteString("\x1b\\")
+	return s.String()
+}
+
+const asciiRamp = " .:-=+*#%@"
+
+// asciiArt draws img cols characters wide: in colored half blocks when
+// colors are on, otherwise as characters of increasing density.
+func asciiArt(img image.Image, cols int) string {
+	var s strings.Builder
+	if colorsOn {
+		w, h := scaledSize(img, cols, 1)
+		for y := 0; y < h; y += 2 {
+			for x := 0; x < w; x++ {
+				r, g, b, top := sample(img, x, y, w, h)
+				r2, g2, b2, bottom := r, g, b, top
+				if y+1 < h {
+					r2, g2, b2, bottom = sample(img, x, y+1, w, h)
+				}
+				switch {
+				case top && bottom:
+					fmt.Fprintf(&s, "\x1b[38;2;%d;%d;%d;48;2;%d;%d;%dm▀", r, g, b, r2, g2, b2)
+				case top:
+					fmt.Fprintf(&s, "\x1b[0;38;2;%d;%d;%dm▀", r, g, b)
+				case bottom:
+					fmt.Fprintf(&s, "\x1b[0;38;2;%d;%d;%dm▄", r2, g2, b2)
+				default:
+					s.WriteString("\x1b[0m ")
+				}
+			}
+			s.WriteString("\x1b[0m\n")
+		}
+		return strings.TrimSuffix(s.String(), "\n")
+	}
+
+	w, h := scaledSize(img, cols, 2)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			r, g, b, opaque := sample(img, x, y, w, h)
+			if !opaque {
+				s.WriteByte(' ')
+				continue
+			}
+			luma := (299*int(r) + 587*int(g) + 114*int(b)) / 1000
+			s.WriteByte(asciiRamp[luma*len(asciiRamp)/256])
+		}
+		s.WriteByte('\n')
+	}
+	return strings.TrimSuffix(s.String(), "\n")
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"golang.org/x/term"
+)
+
+// Theme styles each kind of output. A style is a list of words such as
+// "bold cyan", "italic bright-black", "on-blue", a 256-color number or a
+// "#rrggbb" color; an empty style leaves the text as it is.
+type Theme struct {
+	User      string `json:"user,omitempty"`
+	Character string `json:"character,omitempty"`
+	// Action is text between asterisks in replies, like *waves*, and
+	// Speech is quoted dialogue; see FormatConfig. Speech defaults to the
+	// Character style.
+	Action string `json:"action,omitempty"`
+	Speech string `json:"speech,omitempty"`
+	Notice string `json:"notice,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+var builtinThemes = map[string]Theme{
+	"default": {User: "bold", Action: "dim italic", Notice: "yellow", Error: "bold red"},
+	"dark":    {User: "bold bright-white", Character: "bright-white", Action: "italic bright-cyan", Notice: "bright-yellow", Error: "bold bright-red"},
+	"light":   {User: "bold black", Character: "black", Action: "italic blue", Notice: "magenta", Error: "bold red"},
+	"none":    {},
+}
+
+// theme is the active theme; colorsOn is false for terminals that can't
+// show it.
+var (
+	theme    = builtinThemes["default"]
+	colorsOn = false
+)
+
+// initTheme picks the theme from the config, with the colors set there
+// replacing its styles. Colors are off when stdout isn't a terminal, for
+// TERM=dumb and when NO_COLOR is set.
+func initTheme(config Config) {
+	name := config.Theme
+	if name == "" {
+		name = "default"
+	}
+	t, ok := builtinThemes[name]
+	if !ok {
+		fmt.Printf("Unknown theme %q, using the default. Themes: default, dark, light, none\n", name)
+		t = builtinThemes["default"]
+	}
+	if c := config.Colors; c != nil {
+		for _, field := range []struct {
+			dst *string
+			src string
+		}{
+			{&t.User, c.User}, {&t.Character, c.Character}, {&t.Action, c.Action}, {&t.Speech, c.Speech}, {&t.Notice, c.Notice}, {&t.Error, c.Error},
+		} {
+			if field.src != "" {
+				*field.dst = field.src
+			}
+		}
+	}
+	theme = t
+	initFormatting(config)
+	colorsOn = os.Getenv("NO_COLOR") == "" && os.Getenv("TERM") != "dumb" && term.IsTerminal(int(os.Stdout.Fd()))
+}
+
+var colorNames = map[string]int{"black": 0, "red": 1, "green": 2, "yellow": 3, "blue": 4, "magenta": 5, "cyan": 6, "white": 7}
+
+// sgr turns a style into an escape sequence, ignoring words it doesn't
+// know.
+func sgr(style string) string {
+	var codes []string
+	for _, word := range strings.Fields(strings.ToLower(style)) {
+		background := strings.HasPrefix(word, "on-")
+		word = strings.TrimPrefix(word, "on-")
+		base := 30
+		if background {
+			base = 40
+		}
+		switch {
+		case word == "bold":
+			codes = append(codes, "1")
+		case word == "dim":
+			codes = append(codes, "2")
+		case word == "italic":
+			codes = append(codes, "3")
+		case word == "underline":
+			codes = append(codes, "4")
+		case strings.HasPrefix(word, "bright-"):
+			if n, ok := colorNames[strings.TrimPrefix(word, "bright-")]; ok {
+				codes = append(codes, strconv.Itoa(base+60+n))
+			}
+		case strings.HasPrefix(word, "#") && len(word) == 7:
+			if rgb, err := strconv.ParseUint(word[1:], 16, 32); err == nil {
+				codes = append(codes, fmt.Sprintf("%d;2;%d;%d;%d", base+8, rgb>>16, rgb>>8&0xff, rgb&0xff))
+			}
+		default:
+			if n, ok := colorNames[word]; ok {
+				codes = append(codes, strconv.Itoa(base+n))
+			} else if n, err := strconv.Atoi(word); err == nil && n >= 0 && n < 256 {
+				codes = append(codes, fmt.Sprintf("%d;5;%d", base+8, n))
+			}
+		}
+	}
+	if len(codes) == 0 {
+		return ""
+	}
+	return "\x1b[" + strings.Join(codes, ";") + "m"
+}
+
+// paint applies style to text, line by line so a pager or a wrapped line
+// doesn't carry the style onto what follows.
+func paint(style, text string) string {
+	code := sgr(style)
+	if !colorsOn || code == "" || text == "" {
+		return text
+	}
+	lines := strings.Split(text, "\n")
+	for i, line := range lines {
+		if line != "" {
+			lines[i] = code + line + "\x1b[0m"
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// notice prints a message from the app itself, such as a warning, in the
+// notice style.
+func notice(format string, a ...interface{}) {
+	fmt.Print(paint(theme.Notice, fmt.Sprintf(format, a...)))
+}
+
+func printError(a ...interface{}) {
+	fmt.Print(paint(theme.Error, strings.TrimSuffix(fmt.Sprintln(a...), "\n")) + "\n")
+}
+
+func printErrorf(format string, a ...interface{}) {
+	fmt.Print(paint(theme.Error, fmt.Sprintf(format, a...)))
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	titlePrompt   = "Give this roleplay chat a short title of two to five words, like the title of a story. Respond with only the title."
+	titleMessages = 6
+	titleTimeout  = 20 * time.Second
+	maxTitleLen   = 50
+)
+
+// titleSession names new sessions when they are first saved. It is set up
+// in main once there is a backend to ask; until then sessions are named
+// after the time they started.
+var titleSession func(history []Message) string
+
+func newTitler(client *http.Client, config *Config) func([]Message) string {
+	return func(history []Message) string {
+		if config.AutoTitle != nil && !*config.AutoTitle {
+			return ""
+		}
+		return generateTitle(client, config, history)
+	}
+}
+
+// generateTitle asks the model for a title from the first few exchanges,
+// returning "" if it can't get a usable one.
+func generateTitle(client *http.Client, config *Config, history []Message) string {
+	history = storyHistory(config, history)
+	if len(history) > titleMessages {
+		history = history[:titleMessages]
+	}
+	messages := append(copyHistory(history), Message{Role: "user", Content: titlePrompt})
+
+	ctx, cancel := context.WithTimeout(context.Background(), titleTimeout)
+	defer cancel()
+	result, err := chatCompletion(ctx, client, config.URL, newChatMessage(config, messages))
+	if err != nil {
+		return ""
+	}
+	return cleanTitle(result.Content)
+}
+
+// cleanTitle turns the model's answer into something usable as a session
+// name, which is also a file name.
+func cleanTitle(title string) string {
+	title = strings.TrimSpace(strings.SplitN(strings.TrimSpace(title), "\n", 2)[0])
+	for _, prefix := range []string{"Title:", "title:"} {
+		title = strings.TrimPrefix(title, prefix)
+	}
+	title = strings.Map(func(r rune) rune {
+		if strings.ContainsRune(`/\:*?"<>|`, r) || r < 32 {
+			return -1
+		}
+		return r
+	}, title)
+	title = strings.Trim(strings.TrimSpace(title), "'*_.`")
+	if runes := []rune(title); len(runes) > maxTitleLen {
+		title = strings.TrimSpace(string(runes[:maxTitleLen]))
+	}
+	if title == "" || strings.HasPrefix(title, ".") {
+		return ""
+	}
+	return title
+}
+
+// newSessionName picks a name for a session saved for the first time: a
+// generated title not used by another session, or the time it started.
+func newSessionName() string {
+	if titleSession != nil {
+		if title := titleSession(messageHistory); title != "" {
+			name := title
+			for i := 2; ; i++ {
+				if _, err := loadSession(name); err != nil {
+					return name
+				}
+				name = title + " " + strconv.Itoa(i)
+			}
+		}
+	}
+	return sessionCreated.Format("2006-01-02_15-04-05")
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"time"
+)
+
+const (
+	// maxToolRounds caps how many times one reply can go back to the model
+	// with tool results, in case it keeps calling tools.
+	maxToolRounds = 4
+	// maxToolOutput is how much of a tool's output the model gets.
+	maxToolOutput      = 8000
+	DefaultToolTimeout = 30
+)
+
+// ToolConfig declares a tool in the config. It runs Command, with the
+// call's arguments as JSON on stdin and in CHARCHAT_TOOL_ARGS, and gives
+// the model what it prints; or it names a Builtin: "web_search",
+// "current_time" or "roll_dice". Parameters is a JSON schema of the arguments.
+type ToolConfig struct {
+	Name        string          `json:"name"`
+	Description string          `json:"description"`
+	Parameters  json.RawMessage `json:"parameters,omitempty"`
+	Command     string          `json:"command,omitempty"`
+	Builtin     string          `json:"builtin,omitempty"`
+	// Timeout is in seconds.
+	Timeout int `json:"timeout,omitempty"`
+}
+
+// ToolDefinition describes a tool to the backend, in the function calling
+// format Ollama shares with OpenAI.
+type ToolDefinition struct {
+	Type     string       `json:"type"`
+	Function ToolFunction `json:"function"`
+}
+
+type ToolFunction struct {
+	Name        string      `json:"name"`
+	Description string      `json:"description"`
+	Parameters  interface{} `json:"parameters"`
+}
+
+// ToolCall is the model asking for a tool to be run.
+type ToolCall struct {
+	Function struct {
+		Name      string                 `json:"name"`
+		Arguments map[string]interface{} `json:"arguments"`
+	} `json:"function"`
+}
+
+// tool is something the model can call while replying.
+type tool struct {
+	definition ToolFunction
+	run        func(client *http.Client, config *Config, args map[string]interface{}) (string, error)
+}
+
+var builtinTools = map[string]tool{
+	"web_search":   webSearchTool,
+	"current_time": currentTimeTool,
+	"roll_dice":    rollDiceTool,
+}
+
+var currentTimeTool = tool{
+	definition: ToolFunction{
+		Name:        "current_time",
+		Description: "Get the current date and time.",
+		Parameters:  map[string]interface{}{"type": "object", "properties": map[string]interface{}{}},
+	},
+	run: func(client *http.Client, config *Config, args map[string]interface{}) (string, error) {
+		return time.Now().Format("Monday, January 2 2006, 15:04 MST"), nil
+	},
+}
+
+// availableTools are the tools set up in the config, if the backend can
+// call them. Without a tools list, web search is offered when it's set up
+// and dice rolling when dice_tool is on.
+func availableTools(config *Config) []tool {
+	if backendCaps.Probed && !backendCaps.Tools {
+		return nil
+	}
+	var tools []tool
+	if config.Tools == nil {
+		if config.WebSearch != nil && config.WebSearch.URL != "" {
+			tools = append(tools, webSearchTool)
+		}
+		if config.DiceTool {
+			tools = append(tools, rollDiceTool)
+		}
+		return tools
+	}
+	for _, declared := range config.Tools {
+		if t, ok := configuredTool(declared); ok {
+			tools = append(tools, t)
+		}
+	}
+	return tools
+}
+
+// configuredTool turns a declared tool into one that can run, dropping
+// ones that can't.
+func configuredTool(declared ToolConfig) (tool, bool) {
+	if declared.Builtin != "" {
+		t, ok := builtinTools[declared.Builtin]
+		if !ok {
+			return tool{}, false
+		}
+		if declared.Name != "" {
+			t.definition.Name = declared.Name
+		}
+		if declared.Description != "" {
+			t.definition.Description = declared.Description
+		}
+		return t, true
+	}
+	if declared.Name == "" || declared.Command == "" {
+		return tool{}, false
+	}
+	var parameters interface{} = map[string]interface{}{"type": "object", "properties": map[string]interface{}{}}
+	if len(declared.Parameters) > 0 {
+		parameters = declared.Parameters
+	}
+	return tool{
+		definition: ToolFunction{Name: declared.Name, Description: declared.Description, Parameters: parameters},
+		run: func(client *http.Client, config *Config, args map[string]interface{}) (string, error) {
+			return runToolCommand(declared, args)
+		},
+	}, true
+}
+
+// runToolCommand runs a declared tool's command and returns its output.
+func runToolCommand(declared ToolConfig, args map[string]interface{}) (string, error) {
+	timeout := declared.Timeout
+	if timeout <= 0 {
+		timeout = DefaultToolTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(timeout)*time.Second)
+	defer cancel()
+	input, _ := json.Marshal(args)
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.CommandContext(ctx, "cmd", "/c", declared.Command)
+	} else {
+		cmd = exec.CommandContext(ctx, "sh", "-c", declared.Command)
+	}
+	cmd.Stdin = bytes.NewReader(input)
+	cmd.Env = append(os.Environ(), "CHARCHAT_TOOL_ARGS="+string(input))
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if ctx.Err() == context.DeadlineExceeded {
+		return "", fmt.Errorf("timed out after %d seconds", timeout)
+	}
+	if err != nil {
+		return "", fmt.Errorf("%v %s", err, strings.TrimSpace(stderr.String()))
+	}
+	output := strings.TrimSpace(string(out))
+	if len(output) > maxToolOutput {
+		output = output[:maxToolOutput] + "\n[output truncated]"
+	}
+	return output, nil
+}
+
+// displayTools lists the tools offered to the model, for /tools.
+func displayTools(config *Config) {
+	if backendCaps.Probed && !backendCaps.Tools {
+		fmt.Println("The current backend or model doesn't support function calling. See /caps.")
+		return
+	}
+	tools := availableTools(config)
+	if len(tools) == 0 {
+		fmt.Println("No tools are set up. Declare them in the config's tools list.")
+		return
+	}
+	fmt.Println("\n[Tools]:")
+	for _, t := range tools {
+		fmt.Printf("- %s: %s\n", t.definition.Name, t.definition.Description)
+	}
+}
+
+func toolDefinitions(tools []tool) []ToolDefinition {
+	var definitions []ToolDefinition
+	for _, t := range tools {
+		definitions = append(definitions, ToolDefinition{Type: "function", Function: t.definition})
+	}
+	return definitions
+}
+
+// runToolCalls carries out the calls in a reply, returning the messages
+// that give the model the results. Failures are reported to the model as
+// the result, so it can carry on without them.
+func runToolCalls(client *http.Client, config *Config, calls []ToolCall, debug bool) []Message {
+	tools := availableTools(config)
+	var results []Message
+	for _, call := range calls {
+		name := call.Function.Name
+		output := fmt.Sprintf("Error: there is no tool named %q.", name)
+		for _, t := range tools {
+			if t.definition.Name != name {
+				continue
+			}
+			if debug {
+				args, _ := json.Marshal(call.Function.Arguments)
+				notice("[Debug] Calling %s %s\n", name, args)
+			}
+			result, err := t.run(client, config, call.Function.Arguments)
+			if err != nil {
+				printErrorf("Error running tool %s: %v\n", name, err)
+				result = "Error: " + err.Error()
+			}
+			output = result
+		}
+		results = append(results, Message{Role: "tool", Content: output, ToolName: name})
+	}
+	return results
+}
+
+// stringArg returns a string argument of a tool call.
+func stringArg(args map[string]interface{}, name string) string {
+	s, _ := args[name].(string)
+	return s
+}
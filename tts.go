@@ -0,0 +1,305 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// TTSConfig reads replies aloud. Engine is "say" (macOS), "espeak",
+// "sapi" (Windows) or "http"; by default it's the one for the system.
+type TTSConfig struct {
+	Enabled bool   `json:"enabled"`
+	Engine  string `json:"engine,omitempty"`
+	// Voice and Rate (words per minute) apply to characters without voice
+	// settings of their own.
+	Voice string `json:"voice,omitempty"`
+	Rate  int    `json:"rate,omitempty"`
+
+	// The http engine POSTs {"text": ..., "voice": ...} and Fields to URL,
+	// with {voice} in the URL replaced, and plays the audio it returns
+	// using Player. This fits servers such as Piper's, or ElevenLabs with
+	// an xi-api-key header.
+	URL     string                 `json:"url,omitempty"`
+	Headers map[string]string      `json:"headers,omitempty"`
+	Fields  map[string]interface{} `json:"fields,omitempty"`
+	// Player is the command that plays an audio file, e.g. "mpv
+	// --really-quiet". By default the first of afplay, mpv, ffplay, paplay
+	// and aplay that is installed.
+	Player string `json:"player,omitempty"`
+
+	// SpeakActions reads actions like *waves* as well as the rest.
+	SpeakActions bool `json:"speak_actions,omitempty"`
+}
+
+// VoiceSettings are a character's own voice.
+type VoiceSettings struct {
+	Voice string `json:"voice,omitempty"`
+	Rate  int    `json:"rate,omitempty"`
+}
+
+// speaker plays one reply at a time; a new one cuts off the last.
+type speaker struct {
+	client *http.Client
+	config *Config
+	mu     sync.Mutex
+	cancel context.CancelFunc
+}
+
+// ttsSpeaker reads replies aloud when TTS is on. It is set up in main with
+// the HTTP client to use.
+var ttsSpeaker *speaker
+
+func newSpeaker(client *http.Client, config *Config) *speaker {
+	return &speaker{client: client, config: config}
+}
+
+func (s *speaker) speak(text string) {
+	tts := s.config.TTS
+	if tts == nil || !tts.Enabled {
+		return
+	}
+	text = speechText(text, tts.SpeakActions)
+	if text == "" {
+		return
+	}
+	voice := VoiceSettings{Voice: tts.Voice, Rate: tts.Rate}
+	if v := activeCharacter.Voice; v != nil {
+		if v.Voice != "" {
+			voice.Voice = v.Voice
+		}
+		if v.Rate > 0 {
+			voice.Rate = v.Rate
+		}
+	}
+
+	s.stop()
+	ctx, cancel := context.WithCancel(context.Background())
+	s.mu.Lock()
+	s.cancel = cancel
+	s.mu.Unlock()
+	go func() {
+		defer cancel()
+		if err := s.play(ctx, *tts, voice, text); err != nil && ctx.Err() == nil {
+			printError("\nError speaking reply:", err)
+		}
+	}()
+}
+
+// stop cuts off the reply being read.
+func (s *speaker) stop() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.cancel != nil {
+		s.cancel()
+		s.cancel = nil
+	}
+}
+
+func (s *speaker) play(ctx context.Context, tts TTSConfig, voice VoiceSettings, text string) error {
+	engine := tts.Engine
+	if engine == "" {
+		engine = defaultTTSEngine()
+	}
+	var cmd *exec.Cmd
+	switch engine {
+	case "say":
+		args := []string{}
+		if voice.Voice != "" {
+			args = append(args, "-v", voice.Voice)
+		}
+		if voice.Rate > 0 {
+			args = append(args, "-r", strconv.Itoa(voice.Rate))
+		}
+		cmd = exec.CommandContext(ctx, "say", append(args, "--", text)...)
+	case "espeak":
+		name := "espeak"
+		if _, err := exec.LookPath(name); err != nil {
+			name = "espeak-ng"
+		}
+		args := []string{}
+		if voice.Voice != "" {
+			args = append(args, "-v", voice.Voice)
+		}
+		if voice.Rate > 0 {
+			args = append(args, "-s", strconv.Itoa(voice.Rate))
+		}
+		cmd = exec.CommandContext(ctx, name, append(args, "--", text)...)
+	case "sapi":
+		script := "Add-Type -AssemblyName System.Speech; $s = New-Object System.Speech.Synthesis.SpeechSynthesizer; "
+		if voice.Voice != "" {
+			script += "$s.SelectVoice('" + strings.ReplaceAll(voice.Voice, "'", "''") + "'); "
+		}
+		script += "$s.Speak([Console]::In.ReadToEnd())"
+		cmd = exec.CommandContext(ctx, "powershell", "-NoProfile", "-Command", script)
+		cmd.Stdin = strings.NewReader(text)
+	case "http":
+		return s.playHTTP(ctx, tts, voice, text)
+	default:
+		return fmt.Errorf("unknown TTS engine %q", engine)
+	}
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("%v %s", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+func (s *speaker) playHTTP(ctx context.Context, tts TTSConfig, voice VoiceSettings, text string) error {
+	if tts.URL == "" {
+		return fmt.Errorf("the http engine needs a url")
+	}
+	fields := map[string]interface{}{"text": text}
+	if voice.Voice != "" {
+		fields["voice"] = voice.Voice
+	}
+	for k, v := range tts.Fields {
+		fields[k] = v
+	}
+	body, _ := json.Marshal(fields)
+	req, err := http.NewRequestWithContext(ctx, "POST", strings.ReplaceAll(tts.URL, "{voice}", voice.Voice), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range tts.Headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		message, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("the TTS server returned %s: %s", resp.Status, strings.TrimSpace(string(message)))
+	}
+
+	file, err := ioutil.TempFile("", "char-chat-tts-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+	_, err = io.Copy(file, resp.Body)
+	file.Close()
+	if err != nil {
+		return err
+	}
+
+	player := strings.Fields(tts.Player)
+	if len(player) == 0 {
+		player = defaultAudioPlayer()
+		if player == nil {
+			return fmt.Errorf("no audio player found; set one in the tts player option")
+		}
+	}
+	cmd := exec.CommandContext(ctx, player[0], append(player[1:], file.Name())...)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("%v %s", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+func defaultTTSEngine() string {
+	switch runtime.GOOS {
+	case "darwin":
+		return "say"
+	case "windows":
+		return "sapi"
+	default:
+		return "espeak"
+	}
+}
+
+func defaultAudioPlayer() []string {
+	for _, player := range [][]string{
+		{"afplay"},
+		{"mpv", "--really-quiet", "--no-video"},
+		{"ffplay", "-nodisp", "-autoexit", "-loglevel", "quiet"},
+		{"paplay"},
+		{"aplay", "-q"},
+	} {
+		if _, err := exec.LookPath(player[0]); err == nil {
+			return player
+		}
+	}
+	return nil
+}
+
+// speechText is what gets read of a reply: the speech and narration, and
+// the actions too if asked, without the markers around them.
+func speechText(text string, actions bool) string {
+	var parts []string
+	for _, span := range splitReply(text) {
+		switch span.kind {
+		case "action":
+			if actions {
+				parts = append(parts, stripFormatMarkers(span.text))
+			}
+		default:
+			parts = append(parts, span.text)
+		}
+	}
+	return strings.Join(strings.Fields(strings.Join(parts, " ")), " ")
+}
+
+// handleTTSCommand handles /tts [on | off | voice ...]. Voices set here are
+// the active character's; save the character to keep them.
+func handleTTSCommand(args string, config *Config) {
+	fields := strings.Fields(args)
+	if len(fields) == 0 {
+		state := "off"
+		if config.TTS != nil && config.TTS.Enabled {
+			state = "on"
+		}
+		fmt.Printf("Text-to-speech is %s.\n", state)
+		if v := activeCharacter.Voice; v != nil {
+			fmt.Printf("Character voice: %s, rate %d\n", v.Voice, v.Rate)
+		}
+		fmt.Println("Usage: /tts [on | off | voice {name} [rate] | voice clear]")
+		return
+	}
+	switch fields[0] {
+	case "on", "off":
+		if config.TTS == nil {
+			config.TTS = &TTSConfig{}
+		}
+		config.TTS.Enabled = fields[0] == "on"
+		saveConfig(*config)
+		if ttsSpeaker != nil && !config.TTS.Enabled {
+			ttsSpeaker.stop()
+		}
+		fmt.Printf("Text-to-speech %s.\n", fields[0])
+	case "voice":
+		switch {
+		case len(fields) == 2 && fields[1] == "clear":
+			activeCharacter.Voice = nil
+			fmt.Println("Character voice cleared.")
+		case len(fields) == 2 || len(fields) == 3:
+			voice := &VoiceSettings{Voice: fields[1]}
+			if len(fields) == 3 {
+				rate, err := strconv.Atoi(fields[2])
+				if err != nil || rate <= 0 {
+					fmt.Println("Invalid rate. Use words per minute, e.g. 180")
+					return
+				}
+				voice.Rate = rate
+			}
+			activeCharacter.Voice = voice
+			fmt.Println("Character voice set. Save the character to keep it.")
+		default:
+			fmt.Println("Usage: /tts voice {name} [rate] | /tts voice clear")
+		}
+	default:
+		fmt.Println("Usage: /tts [on | off | voice {name} [rate] | voice clear]")
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/term"
+)
+
+// TypewriterConfig prints replies gradually, which makes backends that
+// don't stream feel alive. Pressing any key prints the rest at once.
+type TypewriterConfig struct {
+	// Delay is in milliseconds per character, or per word with Words.
+	Delay int  `json:"delay_ms"`
+	Words bool `json:"words,omitempty"`
+}
+
+// typewrite prints text with the configured delay, falling back to
+// printing it at once when stdout or stdin isn't a terminal.
+func typewrite(config *TypewriterConfig, text string) {
+	stdin := int(os.Stdin.Fd())
+	if config == nil || config.Delay <= 0 || !term.IsTerminal(int(os.Stdout.Fd())) || !term.IsTerminal(stdin) {
+		fmt.Print(text)
+		return
+	}
+	// Raw mode so a key press is seen without Enter, and isn't echoed.
+	state, err := term.MakeRaw(stdin)
+	if err != nil {
+		fmt.Print(text)
+		return
+	}
+	defer term.Restore(stdin, state)
+
+	delay := time.Duration(config.Delay) * time.Millisecond
+	pieces := typewriterPieces(text, config.Words)
+	for i, piece := range pieces {
+		// Raw mode doesn't turn "\n" into "\r\n" on output.
+		fmt.Print(strings.ReplaceAll(piece, "\n", "\r\n"))
+		if strings.TrimSpace(escapePattern.ReplaceAllString(piece, "")) == "" {
+			continue
+		}
+		if keyPressed(stdin, delay) {
+			skipKeyPress()
+			fmt.Print(strings.ReplaceAll(strings.Join(pieces[i+1:], ""), "\n", "\r\n"))
+			return
+		}
+	}
+}
+
+// typewriterPieces splits text into the units printed between delays:
+// characters, or words with the space after them. Color escape sequences
+// are kept whole.
+func typewriterPieces(text string, words bool) []string {
+	var pieces []string
+	if !words {
+		for i := 0; i < len(text); {
+			if loc := escapePattern.FindStringIndex(text[i:]); loc != nil && loc[0] == 0 {
+				pieces = append(pieces, text[i:i+loc[1]])
+				i += loc[1]
+				continue
+			}
+			_, size := utf8.DecodeRuneInString(text[i:])
+			pieces = append(pieces, text[i:i+size])
+			i += size
+		}
+		return pieces
+	}
+	start := 0
+	for i, r := range text {
+		if unicode.IsSpace(r) && i > start {
+			pieces = append(pieces, text[start:i])
+			start = i
+		}
+	}
+	return append(pieces, text[start:])
+}
+
+var escapePattern = regexp.MustCompile(`\x1b\[[0-9;]*m`)
+
+// skipKeyPress drops the key that ended the effect, so it isn't typed into
+// the next message.
+func skipKeyPress() {
+	stdinReader.ReadRune()
+	stdinReader.Discard(stdinReader.Buffered())
+}
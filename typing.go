@@ -0,0 +1,47 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"golang.org/x/term"
+)
+
+var typingFrames = []rune("⠋⠙⠹⠸⠼⠴⠦⠧⠇⠏")
+
+// showTypingIndicator animates "Name is typing…" until the returned
+// function is called, which clears it. It does nothing unless stdout is a
+// terminal, or if typing_indicator is false in the config.
+func showTypingIndicator(config *Config) func() {
+	if config.TypingIndicator != nil && !*config.TypingIndicator || !term.IsTerminal(int(os.Stdout.Fd())) {
+		return func() {}
+	}
+	label := characterDisplayName(activeCharacter) + " is typing…"
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ticker := time.NewTicker(100 * time.Millisecond)
+		defer ticker.Stop()
+		for frame := 0; ; frame++ {
+			fmt.Printf("\r%c %s", typingFrames[frame%len(typingFrames)], label)
+			select {
+			case <-done:
+				fmt.Print("\r\x1b[K")
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			close(done)
+			wg.Wait()
+		})
+	}
+}
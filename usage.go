@@ -0,0 +1,170 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+const UsageFile = "usage.json"
+
+// ModelPrice is what a model costs, in dollars per million tokens.
+type ModelPrice struct {
+	Prompt     float64 `json:"prompt"`
+	Completion float64 `json:"completion"`
+}
+
+type modelUsage struct {
+	Requests         int `json:"requests"`
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+}
+
+func (u modelUsage) cost(price ModelPrice) float64 {
+	return (float64(u.PromptTokens)*price.Prompt + float64(u.CompletionTokens)*price.Completion) / 1e6
+}
+
+// usageTracker counts tokens per model for the current chat and per month.
+// The monthly totals are persisted, so they cover every run of the app.
+type usageTracker struct {
+	mu      sync.Mutex
+	loaded  bool
+	session map[string]*modelUsage
+	months  map[string]map[string]*modelUsage
+}
+
+var usage = &usageTracker{session: map[string]*modelUsage{}}
+
+func usagePath() string {
+	return filepath.Join(getConfigDir(), UsageFile)
+}
+
+func (t *usageTracker) load() {
+	if t.loaded {
+		return
+	}
+	t.loaded = true
+	t.months = map[string]map[string]*modelUsage{}
+	if data, err := ioutil.ReadFile(usagePath()); err == nil {
+		if err := json.Unmarshal(data, &t.months); err != nil {
+			printError("Error parsing usage file:", err)
+		}
+	}
+}
+
+// record adds the token counts of a completed request, as reported by the
+// backend.
+func (t *usageTracker) record(model string, result ChatResult) {
+	if result.PromptTokens == 0 && result.CompletionTokens == 0 {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.load()
+
+	month := time.Now().Format("2006-01")
+	if t.months[month] == nil {
+		t.months[month] = map[string]*modelUsage{}
+	}
+	for _, totals := range []map[string]*modelUsage{t.session, t.months[month]} {
+		u := totals[model]
+		if u == nil {
+			u = &modelUsage{}
+			totals[model] = u
+		}
+		u.Requests++
+		u.PromptTokens += result.PromptTokens
+		u.CompletionTokens += result.CompletionTokens
+	}
+
+	data, _ := json.MarshalIndent(t.months, "", "  ")
+	if err := ioutil.WriteFile(usagePath(), data, 0644); err != nil {
+		printError("Error saving usage:", err)
+	}
+}
+
+func (t *usageTracker) resetSession() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.session = map[string]*modelUsage{}
+}
+
+func handleUsageCommand(config *Config) {
+	usage.mu.Lock()
+	defer usage.mu.Unlock()
+	usage.load()
+
+	month := time.Now().Format("2006-01")
+	displayUsage("This chat", usage.session, config.Prices)
+	displayUsage("This month ("+month+")", usage.months[month], config.Prices)
+	if len(config.Prices) == 0 {
+		fmt.Println("\nSet per-model prices in the config to see costs, e.g. \"prices\": {\"gpt-4o\": {\"prompt\": 2.5, \"completion\": 10}} in dollars per million tokens.")
+	}
+}
+
+func displayUsage(title string, totals map[string]*modelUsage, prices map[string]ModelPrice) {
+	fmt.Printf("\n[Usage: %s]:\n", title)
+	if len(totals) == 0 {
+		fmt.Println("No requests yet.")
+		return
+	}
+	models := make([]string, 0, len(totals))
+	for model := range totals {
+		models = append(models, model)
+	}
+	sort.Strings(models)
+
+	var total float64
+	priced := false
+	for _, model := range models {
+		u := totals[model]
+		line := fmt.Sprintf("- %s: %d request(s), %d prompt + %d completion tokens", model, u.Requests, u.PromptTokens, u.CompletionTokens)
+		if price, ok := prices[model]; ok {
+			cost := u.cost(price)
+			total += cost
+			priced = true
+			line += fmt.Sprintf(", $%.4f", cost)
+		}
+		fmt.Println(line)
+	}
+	if priced {
+		fmt.Printf("Total cost: $%.4f\n", total)
+	}
+}
+
+// displayReplyUsage prints the token counts the backend reported for a
+// reply and how full the context window was.
+func displayReplyUsage(config *Config, result ChatResult) {
+	if result.PromptTokens == 0 && result.CompletionTokens == 0 {
+		notice("[Usage] The backend did not report token counts.\n")
+		return
+	}
+	used, limit := result.PromptTokens+result.CompletionTokens, contextLimit(config)
+	notice("[Usage] %d prompt + %d completion tokens, context %d%% full (%d/%d)\n",
+		result.PromptTokens, result.CompletionTokens, used*100/limit, used, limit)
+}
+
+// displayReplySpeed prints the generation speed of a reply from the
+// backend's timings, or just how long it took if there are none. A sudden
+// drop in tokens per second usually means the model no longer fits on the
+// GPU.
+func displayReplySpeed(result ChatResult, elapsed time.Duration) {
+	if result.EvalDuration <= 0 || result.CompletionTokens == 0 {
+		notice("[Speed] Reply took %.1fs\n", elapsed.Seconds())
+		return
+	}
+	line := fmt.Sprintf("[Speed] %.1f tokens/s (%d tokens in %.1fs), prompt %.1fs",
+		float64(result.CompletionTokens)/result.EvalDuration.Seconds(), result.CompletionTokens,
+		result.EvalDuration.Seconds(), result.PromptEvalDuration.Seconds())
+	if result.LoadDuration > time.Second {
+		line += fmt.Sprintf(", model load %.1fs", result.LoadDuration.Seconds())
+	}
+	if result.TotalDuration > 0 {
+		elapsed = result.TotalDuration
+	}
+	notice("%s, total %.1fs\n", line, elapsed.Seconds())
+}
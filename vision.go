@@ -0,0 +1,79 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"strings"
+)
+
+// pendingImages are attached to the next message sent, base64 encoded as
+// Ollama takes them.
+var pendingImages []string
+
+// handleImgCommand handles /img {file} [message]: the image goes with the
+// message, or with the next one typed if there isn't one.
+func handleImgCommand(args string) {
+	path, text := splitImageArgs(args)
+	if path == "" {
+		fmt.Println("Usage: /img {file} [message]")
+		return
+	}
+	if !requireCapability(backendCaps.Vision, "Image input") {
+		return
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		printError("Error reading image:", err)
+		return
+	}
+	if !bytes.HasPrefix(data, pngSignature) && !bytes.HasPrefix(data, []byte{0xff, 0xd8, 0xff}) {
+		printErrorf("Error: %s is not a PNG or JPEG image.\n", path)
+		return
+	}
+	pendingImages = append(pendingImages, base64.StdEncoding.EncodeToString(data))
+	if text == "" {
+		fmt.Printf("Image attached (%d KB). It will be sent with your next message.\n", len(data)/1024)
+		return
+	}
+	pendingInputs = append([]string{text}, pendingInputs...)
+}
+
+// splitImageArgs separates the file from the message, allowing a quoted
+// file name with spaces.
+func splitImageArgs(args string) (string, string) {
+	args = strings.TrimSpace(args)
+	if strings.HasPrefix(args, "\"") {
+		if end := strings.Index(args[1:], "\""); end >= 0 {
+			return args[1 : end+1], strings.TrimSpace(args[end+2:])
+		}
+	}
+	fields := strings.SplitN(args, " ", 2)
+	if len(fields) == 1 {
+		return fields[0], ""
+	}
+	return fields[0], strings.TrimSpace(fields[1])
+}
+
+// imageNote marks messages with images in listings.
+func imageNote(msg Message) string {
+	switch len(msg.Images) {
+	case 0:
+		return ""
+	case 1:
+		return " [1 image]"
+	default:
+		return fmt.Sprintf(" [%d images]", len(msg.Images))
+	}
+}
+
+// attachPendingImages adds the attached images to the last message.
+func attachPendingImages() {
+	if len(pendingImages) == 0 || len(messageHistory) == 0 {
+		return
+	}
+	last := &messageHistory[len(messageHistory)-1]
+	last.Images = append(last.Images, pendingImages...)
+	pendingImages = nil
+}
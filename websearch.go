@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	DefaultSearchResults = 5
+	searchTimeout        = 15 * time.Second
+)
+
+// WebSearchConfig lets the model search the web. Engine is "searxng" (the
+// default, with URL the instance's address and its JSON format enabled) or
+// "brave", for the Brave Search API, with APIKey.
+type WebSearchConfig struct {
+	URL     string `json:"url"`
+	Engine  string `json:"engine,omitempty"`
+	APIKey  string `json:"api_key,omitempty"`
+	Results int    `json:"results,omitempty"`
+}
+
+var webSearchTool = tool{
+	definition: ToolFunction{
+		Name:        "web_search",
+		Description: "Search the web for current information, such as news, facts or events after your training. Returns titles, links and snippets.",
+		Parameters: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"query": map[string]interface{}{"type": "string", "description": "What to search for"},
+			},
+			"required": []string{"query"},
+		},
+	},
+	run: runWebSearch,
+}
+
+type searchHit struct {
+	Title   string
+	URL     string
+	Snippet string
+}
+
+func runWebSearch(client *http.Client, config *Config, args map[string]interface{}) (string, error) {
+	query := strings.TrimSpace(stringArg(args, "query"))
+	if query == "" {
+		return "", fmt.Errorf("no query given")
+	}
+	notice("[Search]: %s\n", query)
+	hits, err := webSearch(client, config.WebSearch, query)
+	if err != nil {
+		return "", err
+	}
+	if len(hits) == 0 {
+		return "No results.", nil
+	}
+	var b strings.Builder
+	for i, hit := range hits {
+		fmt.Fprintf(&b, "%d. %s\n%s\n%s\n\n", i+1, hit.Title, hit.URL, hit.Snippet)
+	}
+	return strings.TrimSpace(b.String()), nil
+}
+
+func webSearch(client *http.Client, settings *WebSearchConfig, query string) ([]searchHit, error) {
+	limit := settings.Results
+	if limit <= 0 {
+		limit = DefaultSearchResults
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), searchTimeout)
+	defer cancel()
+
+	var hits []searchHit
+	switch settings.Engine {
+	case "", "searxng":
+		var result struct {
+			Results []struct {
+				Title   string `json:"title"`
+				URL     string `json:"url"`
+				Content string `json:"content"`
+			} `json:"results"`
+		}
+		address := strings.TrimSuffix(settings.URL, "/") + "/search?" + url.Values{"q": {query}, "format": {"json"}}.Encode()
+		req, err := http.NewRequestWithContext(ctx, "GET", address, nil)
+		if err != nil {
+			return nil, err
+		}
+		if err := doJSON(client, req, &result); err != nil {
+			return nil, err
+		}
+		for _, r := range result.Results {
+			hits = append(hits, searchHit{r.Title, r.URL, r.Content})
+		}
+	case "brave":
+		var result struct {
+			Web struct {
+				Results []struct {
+					Title       string `json:"title"`
+					URL         string `json:"url"`
+					Description string `json:"description"`
+				} `json:"results"`
+			} `json:"web"`
+		}
+		address := strings.TrimSuffix(settings.URL, "/") + "?" + url.Values{"q": {query}}.Encode()
+		req, err := http.NewRequestWithContext(ctx, "GET", address, nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Accept", "application/json")
+		req.Header.Set("X-Subscription-Token", settings.APIKey)
+		if err := doJSON(client, req, &result); err != nil {
+			return nil, err
+		}
+		for _, r := range result.Web.Results {
+			hits = append(hits, searchHit{r.Title, r.URL, r.Description})
+		}
+	default:
+		return nil, fmt.Errorf("unknown search engine %q", settings.Engine)
+	}
+	if len(hits) > limit {
+		hits = hits[:limit]
+	}
+	return hits, nil
+}
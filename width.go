@@ -0,0 +1,93 @@
+package main
+
+import "unicode"
+
+const zeroWidthJoiner = '\u200d'
+
+// wideRanges are the East Asian wide and emoji ranges that take two
+// terminal columns.
+var wideRanges = [][2]rune{
+	{0x1100, 0x115F}, {0x231A, 0x231B}, {0x2329, 0x232A}, {0x23E9, 0x23EC},
+	{0x2E80, 0x303E}, {0x3041, 0x33FF}, {0x3400, 0x4DBF}, {0x4E00, 0x9FFF},
+	{0xA000, 0xA4CF}, {0xA960, 0xA97F}, {0xAC00, 0xD7A3}, {0xF900, 0xFAFF},
+	{0xFE10, 0xFE19}, {0xFE30, 0xFE6F}, {0xFF00, 0xFF60}, {0xFFE0, 0xFFE6},
+	{0x1F004, 0x1F004}, {0x1F0CF, 0x1F0CF}, {0x1F18E, 0x1F18E}, {0x1F191, 0x1F19A},
+	{0x1F200, 0x1F251}, {0x1F300, 0x1F64F}, {0x1F680, 0x1F6FF}, {0x1F7E0, 0x1F7EB},
+	{0x1F900, 0x1F9FF}, {0x1FA70, 0x1FAFF}, {0x20000, 0x2FFFD}, {0x30000, 0x3FFFD},
+}
+
+// runeWidth is how many terminal columns r takes: 0 for combining marks,
+// joiners and other characters drawn onto the previous one, 2 for wide
+// CJK characters and emoji, 1 otherwise.
+func runeWidth(r rune) int {
+	switch {
+	case r == 0 || isZeroWidth(r):
+		return 0
+	case r < 0x1100:
+		return 1
+	}
+	for _, wide := range wideRanges {
+		if r >= wide[0] && r <= wide[1] {
+			return 2
+		}
+	}
+	return 1
+}
+
+func isZeroWidth(r rune) bool {
+	return unicode.In(r, unicode.Mn, unicode.Me, unicode.Cf) ||
+		r >= 0x1160 && r <= 0x11FF || // Hangul vowels and final consonants
+		r >= 0x1F3FB && r <= 0x1F3FF // Emoji skin tones
+}
+
+func stringWidth(s string) int {
+	width := 0
+	for _, r := range s {
+		width += runeWidth(r)
+	}
+	return width
+}
+
+// nextBoundary returns the index after the character starting at i in
+// text, treating a base rune with its combining marks, or an emoji joined
+// to others, as one character.
+func nextBoundary(text []rune, i int) int {
+	if i >= len(text) {
+		return len(text)
+	}
+	for i++; i < len(text) && (isZeroWidth(text[i]) || text[i-1] == zeroWidthJoiner); i++ {
+	}
+	return i
+}
+
+// prevBoundary returns the index of the character before i in text.
+func prevBoundary(text []rune, i int) int {
+	if i <= 0 {
+		return 0
+	}
+	for i--; i > 0 && (isZeroWidth(text[i]) || text[i-1] == zeroWidthJoiner); i-- {
+	}
+	return i
+}
+
+// cellLayout returns the row and column where each rune of text is drawn
+// on a terminal width columns wide, and after the last one where the
+// cursor ends up. A wide character that doesn't fit at the end of a row
+// moves to the next one, as terminals do.
+func cellLayout(text []rune, width int) (rows, cols []int) {
+	rows, cols = make([]int, len(text)+1), make([]int, len(text)+1)
+	row, col := 0, 0
+	for i, r := range text {
+		w := runeWidth(r)
+		if col+w > width {
+			row, col = row+1, 0
+		}
+		rows[i], cols[i] = row, col
+		col += w
+	}
+	if col >= width {
+		row, col = row+1, 0
+	}
+	rows[len(text)], cols[len(text)] = row, col
+	return rows, cols
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"os"
+	"strings"
+
+	"golang.org/x/term"
+)
+
+const replyLabel = "Chatbot: "
+
+// wrapReply wraps text to the terminal width on word boundaries, indenting
+// the lines after the first to line up under the label. Text is left as it
+// is when stdout isn't a terminal or word_wrap is false in the config.
+func wrapReply(config *Config, text string) string {
+	return wrapLabeled(config, text, replyLabel)
+}
+
+// wrapLabeled is wrapReply for text printed after some other label.
+func wrapLabeled(config *Config, text, label string) string {
+	fd := int(os.Stdout.Fd())
+	if config.WordWrap != nil && !*config.WordWrap || !term.IsTerminal(fd) {
+		return text
+	}
+	return wrapText(text, terminalWidth(fd), stringWidth(label))
+}
+
+// wrapText wraps each line of text to width columns, counting the indent
+// before the first one as already used. Words longer than a line are
+// broken.
+func wrapText(text string, width, indent int) string {
+	if width-indent < 20 {
+		return text
+	}
+	pad := strings.Repeat(" ", indent)
+	var b strings.Builder
+	for i, line := range strings.Split(text, "\n") {
+		if i > 0 {
+			b.WriteString("\n")
+			if strings.TrimSpace(line) != "" {
+				b.WriteString(pad)
+			}
+		}
+		col := indent
+		for j, word := range strings.Fields(line) {
+			w := stringWidth(word)
+			if j > 0 {
+				if col+1+w > width {
+					b.WriteString("\n" + pad)
+					col = indent
+				} else {
+					b.WriteString(" ")
+					col++
+				}
+			}
+			for col+w > width {
+				// Break a word that can't fit on a line of its own.
+				runes, fit := []rune(word), 0
+				for n := 0; fit < len(runes) && n+runeWidth(runes[fit]) <= width-col; fit++ {
+					n += runeWidth(runes[fit])
+				}
+				b.WriteString(string(runes[:fit]) + "\n" + pad)
+				word, col = string(runes[fit:]), indent
+				w = stringWidth(word)
+			}
+			b.WriteString(word)
+			col += w
+		}
+	}
+	return b.String()
+}